@@ -0,0 +1,88 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// ErrEnvironmentArchived is returned when writing the state of an Atlas
+// environment that has been archived, which makes its state read-only.
+type ErrEnvironmentArchived struct {
+	Environment string
+}
+
+func (e *ErrEnvironmentArchived) Error() string {
+	return fmt.Sprintf(
+		"The Atlas environment %s is archived, so its state is read-only.\n"+
+			"Unarchive the environment before writing to its state.",
+		e.Environment)
+}
+
+// ArchiveState archives the configured environment in Atlas. Its state is
+// kept and can still be read, but it can't be written until the
+// environment is unarchived. Archiving an archived environment does
+// nothing.
+func (b *Backend) ArchiveState(ctx context.Context) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	return b.stateClient.setArchived(ctx, true)
+}
+
+// UnarchiveState unarchives the configured environment in Atlas so that
+// its state can be written again. Unarchiving an environment that isn't
+// archived does nothing.
+func (b *Backend) UnarchiveState(ctx context.Context) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	return b.stateClient.setArchived(ctx, false)
+}
+
+func (c *stateClient) setArchived(ctx context.Context, archived bool) error {
+	method, action := "POST", "archive"
+	if !archived {
+		method, action = "DELETE", "unarchive"
+	}
+	if c.dryRun("%s %s", action, path.Join(c.User, c.Name)) {
+		return nil
+	}
+
+	req, err := retryablehttp.NewRequest(method, c.archiveURL().String(), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to %s environment: %v", action, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		// Unarchiving an environment that isn't archived is fine
+		if !archived {
+			return nil
+		}
+		return c.httpError(resp)
+	default:
+		return c.httpError(resp)
+	}
+}
+
+// archiveURL returns the URL of the archive status of the environment.
+func (c *stateClient) archiveURL() *url.URL {
+	return c.endpoints().ArchiveURL(c.User, c.Name)
+}
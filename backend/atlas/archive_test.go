@@ -0,0 +1,76 @@
+package atlas
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+)
+
+func TestBackend_ArchiveState(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.ArchiveState(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !fakeAtlas.archived {
+		t.Fatal("the environment should be archived")
+	}
+
+	s, err := b.State(backend.DefaultStateName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.WriteState(testSeedState("lineage", 1)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Writes are blocked while the environment is archived
+	err = s.PersistState()
+	archived, ok := err.(*ErrEnvironmentArchived)
+	if !ok {
+		t.Fatalf("expected *ErrEnvironmentArchived, got: %#v", err)
+	}
+	if archived.Environment != "someuser/some-test-remote-state" {
+		t.Fatalf("bad: %s", archived.Environment)
+	}
+	if fakeAtlas.puts != 0 {
+		t.Fatalf("the state shouldn't be written, got %d puts", fakeAtlas.puts)
+	}
+
+	// Once unarchived, the state can be written again
+	if err := b.UnarchiveState(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = foo
+	`)
+}
+
+func TestBackend_UnarchiveStateNotArchived(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.UnarchiveState(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBackend_ArchiveStateNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if err := b.ArchiveState(context.Background()); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+	if err := b.UnarchiveState(context.Background()); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
@@ -1,13 +1,22 @@
 package atlas
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform/backend"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/state"
@@ -17,6 +26,14 @@ import (
 	"github.com/mitchellh/colorstring"
 )
 
+// Backend must implement these interfaces; these assertions catch drift
+// as they evolve.
+var (
+	_ backend.Enhanced = (*Backend)(nil)
+	_ backend.Local    = (*Backend)(nil)
+	_ backend.CLI      = (*Backend)(nil)
+)
+
 // Backend is an implementation of EnhancedBackend that performs all operations
 // in Atlas. State must currently also be stored in Atlas, although it is worth
 // investigating in the future if state storage can be external as well.
@@ -28,32 +45,227 @@ type Backend struct {
 
 	// ContextOpts are the base context options to set when initializing a
 	// Terraform context. Many of these will be overridden or merged by
-	// Operation. See Operation for more details.
+	// Operation. See mergeContextOpts for the rules.
 	ContextOpts *terraform.ContextOpts
 
+	// OpInput will ask for necessary input prior to performing any operations.
+	//
+	// OpValidation will perform validation prior to running an operation. The
+	// variable naming doesn't match the style of others since we have a func
+	// Validate.
+	OpInput      bool
+	OpValidation bool
+
+	// Metrics, if not nil, observes every request the backend makes to
+	// Atlas. It must be set before Configure.
+	Metrics Metrics
+
+	// Serializer, if not nil, encodes states as they're stored in Atlas,
+	// rather than as Terraform writes them. It must be set before Configure.
+	Serializer StateSerializer
+
+	// Observer, if not nil, is notified of the milestones of each
+	// operation. It must be set before Configure.
+	Observer OperationObserver
+
 	//---------------------------------------------------------------
 	// Internal fields, do not set
 	//---------------------------------------------------------------
 	// stateClient is the legacy state client, setup in Configure
 	stateClient *stateClient
 
+	// httpClient is the HTTP client shared by every client of the backend,
+	// including the copies made for each state, so that connections are
+	// pooled across them. It's built once by Configure and never modified
+	// after, so it's safe to use concurrently.
+	httpClient *retryablehttp.Client
+
+	// name is the full "organization/environment" name, set in Configure
+	name string
+
+	// outputFormat is the format of the CLI output, set in Configure
+	outputFormat string
+
+	// pollInterval is how often the status of a remote run is polled, and
+	// runTimeout how long to wait for it to finish
+	pollInterval time.Duration
+	runTimeout   time.Duration
+
+	// envVariables are the environment variables to upload for runs, and
+	// sensitiveVariables the names of variables whose values are
+	// sensitive. Both are set in Configure.
+	envVariables       map[string]string
+	sensitiveVariables map[string]bool
+
+	// secrets are the values masked in CLI output and errors, set in
+	// Configure
+	secrets *redactor
+
+	// parallelism limits the concurrent operations of the context. If
+	// zero, the parallelism of ContextOpts is used.
+	parallelism int
+
+	// runMessage describes the runs that the backend records in Atlas
+	runMessage string
+
+	// executionMode is how operations are executed, set in Configure
+	executionMode string
+
+	// costEstimate is true if the cost estimate of the run is shown
+	// before applying
+	costEstimate bool
+
+	// allowCLIApply is true if applies from the CLI are allowed even if
+	// the environment's runs are triggered by a VCS repository
+	allowCLIApply bool
+
+	// confirmDestroy is the name of the environment if destroying it must
+	// go ahead even if it's protected from destroys
+	confirmDestroy string
+
+	// savePlan is true if plans are uploaded to Atlas, so that they can
+	// be applied later by their ID
+	savePlan bool
+
+	// terraformVersion is the version of Terraform for Atlas to use for
+	// runs. If empty, the environment's default is used.
+	terraformVersion string
+
+	// serverInfo is the cached result of ServerInfo, guarded by
+	// serverInfoLock. It's reset by Configure.
+	serverInfoLock sync.Mutex
+	serverInfo     *ServerInfo
+
 	// schema is the schema for configuration, set by init
 	schema *schema.Backend
 	once   sync.Once
 
-	// opLock locks operations
-	opLock sync.Mutex
+	// opLock guards opRunning, which is true while an operation is running.
+	// Only one operation may run at a time.
+	opLock    sync.Mutex
+	opRunning bool
+
+	// opForceCh is closed by the Cancel function of the running operation
+	// to force it to abort rather than wait to stop gracefully.
+	opForceCh chan struct{}
+
+	// correlation is the ID of the running operation, which is sent with
+	// each of its requests
+	correlation *correlationID
+
+	// opCancel interrupts the running operation, and opDone is closed once
+	// it's done. Both are guarded by opLock and used by Close.
+	opCancel context.CancelFunc
+	opDone   <-chan struct{}
 }
 
 func (b *Backend) Input(
 	ui terraform.UIInput, c *terraform.ResourceConfig) (*terraform.ResourceConfig, error) {
 	b.once.Do(b.init)
-	return b.schema.Input(ui, c)
+	c, err := b.schema.Input(ui, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// The access token isn't a required field since it can be given in a
+	// number of ways, so ask for it ourselves if none of them are used.
+	if ui != nil && !hasAccessToken(c) {
+		token, err := ui.Input(&terraform.InputOpts{
+			Id:          "access_token",
+			Query:       "access_token",
+			Description: schemaDescriptions["access_token"],
+			Secret:      true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("access_token: %s", err)
+		}
+		if token != "" {
+			c.Config["access_token"] = token
+		}
+	}
+
+	return c, nil
 }
 
 func (b *Backend) Validate(c *terraform.ResourceConfig) ([]string, []error) {
 	b.once.Do(b.init)
-	return b.schema.Validate(c)
+	warns, errs := b.schema.Validate(c)
+
+	// Fields that depend on each other are checked together, so that every
+	// problem is reported at once. If the configuration can't be read at
+	// all, the schema has already said why.
+	if d, err := b.schema.Data(c); err == nil {
+		errs = append(errs, validateCombined(d)...)
+	}
+
+	// Custom headers can't override the ones we set ourselves
+	if raw, ok := c.Get("headers"); ok {
+		for _, k := range headerKeys(raw) {
+			if isReservedHeader(k) {
+				errs = append(errs, fmt.Errorf(
+					"headers: %s is set by Terraform and can't be overridden", k))
+			}
+		}
+	}
+
+	return warns, errs
+}
+
+// validateCombined checks the fields of the configuration that constrain
+// each other, returning every problem found. Constraints between a pair of
+// fields that the schema can express, with ConflictsWith, are left to it.
+func validateCombined(d *schema.ResourceData) []error {
+	var errs []error
+
+	// The access token is required, but may come from either field, the
+	// environment or the CLI credentials file.
+	if d.Get("access_token").(string) == "" &&
+		d.Get("access_token_file").(string) == "" &&
+		!hasCredentialsToken(d.Get("address").(string)) {
+		errs = append(errs, errAccessTokenRequired)
+	}
+
+	// Basic auth needs both a user and a password
+	hasBasicUser := d.Get("http_basic_user").(string) != ""
+	hasBasicPassword := d.Get("http_basic_password").(string) != ""
+	if hasBasicUser != hasBasicPassword {
+		errs = append(errs, errors.New(
+			"http_basic_user and http_basic_password must be set together"))
+	}
+
+	// The wait between retries can't start above its cap. Durations that
+	// don't parse are already reported by their own validation.
+	retryWaitMin, errMin := time.ParseDuration(d.Get("retry_wait_min").(string))
+	retryWaitMax, errMax := time.ParseDuration(d.Get("retry_wait_max").(string))
+	if errMin == nil && errMax == nil && retryWaitMin > retryWaitMax {
+		errs = append(errs, fmt.Errorf(
+			"retry_wait_min (%s) can't be more than retry_wait_max (%s)",
+			retryWaitMin, retryWaitMax))
+	}
+
+	// In the local-apply mode Atlas's plan is applied as it is, so nothing
+	// that would be sent with a new run has any effect.
+	if d.Get("execution_mode").(string) == executionModeLocalApply {
+		var ignored []string
+		if _, ok := d.GetOk("environment_variables"); ok {
+			ignored = append(ignored, "environment_variables")
+		}
+		if d.Get("env_var_prefix").(string) != "" {
+			ignored = append(ignored, "env_var_prefix")
+		}
+		if d.Get("run_message").(string) != defaultRunMessage {
+			ignored = append(ignored, "run_message")
+		}
+
+		for _, k := range ignored {
+			errs = append(errs, fmt.Errorf(
+				"%s can't be set with execution_mode %q, which applies the plan\n"+
+					"of the latest Atlas run as it is",
+				k, executionModeLocalApply))
+		}
+	}
+
+	return errs
 }
 
 func (b *Backend) Configure(c *terraform.ResourceConfig) error {
@@ -61,27 +273,274 @@ func (b *Backend) Configure(c *terraform.ResourceConfig) error {
 	return b.schema.Configure(c)
 }
 
+// States returns the Terraform environments of the backend, which map onto
+// Atlas environments in the configured organization. The default
+// environment is the one given by the name in the configuration, and every
+// other environment has the name of the Atlas environment.
 func (b *Backend) States() ([]string, error) {
-	return nil, backend.ErrNamedStatesNotSupported
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	envs, err := b.stateClient.listEnvironments()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{backend.DefaultStateName}
+	for _, env := range envs {
+		if env != b.stateClient.Name && env != backend.DefaultStateName {
+			result = append(result, env)
+		}
+	}
+	sort.Strings(result[1:])
+
+	return result, nil
 }
 
+// DeleteState deletes the state of the given environment from Atlas.
 func (b *Backend) DeleteState(name string) error {
-	return backend.ErrNamedStatesNotSupported
+	if name == backend.DefaultStateName || name == "" {
+		return errors.New("can't delete default state")
+	}
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	return b.stateClient.forEnvironment(name).Delete()
 }
 
 func (b *Backend) State(name string) (state.State, error) {
-	if name != backend.DefaultStateName {
-		return nil, backend.ErrNamedStatesNotSupported
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	env, err := b.environment(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each state gets its own copy of the client, so that the state it
+	// caches lives only as long as the state.State.
+	client := b.stateClient.forEnvironment(env)
+
+	return &remote.State{Client: client}, nil
+}
+
+// environment returns the Atlas environment of the named state. The
+// default state is the environment given in name.
+func (b *Backend) environment(name string) (string, error) {
+	if name == backend.DefaultStateName || name == "" {
+		return b.stateClient.Name, nil
+	}
+
+	if _, _, err := parseName(b.stateClient.User + "/" + name); err != nil {
+		return "", fmt.Errorf("invalid environment name %q", name)
+	}
+
+	return name, nil
+}
+
+// FetchState reads the state of the configured environment from Atlas with
+// a single request, for callers that only need to read it, such as to read
+// its outputs. It doesn't take the operation lock and is safe to call
+// concurrently. If no state is stored, the state returned is nil.
+func (b *Backend) FetchState(ctx context.Context) (*terraform.State, error) {
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	return b.stateClient.fetchState(ctx)
+}
+
+// fetchState reads the state from Atlas with a single request, bypassing
+// the cache. If no state is stored, the state returned is nil.
+func (c *stateClient) fetchState(ctx context.Context) (*terraform.State, error) {
+	payload, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, nil
+	}
+
+	s, err := terraform.ReadState(bytes.NewReader(payload.Data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read state from Atlas: %v", err)
+	}
+
+	return s, nil
+}
+
+// Operation implements backend.Enhanced
+//
+// This will initialize an in-memory terraform.Context to perform the
+// operation within this process, using Atlas for state storage.
+//
+// The given operation parameter will be merged with the ContextOpts on
+// the structure with the following rules. If a rule isn't specified and the
+// name conflicts, assume that the field is overwritten if set.
+//
+// The returned RunningOperation is done once the operation has completed.
+// If runningOp.Err is nil at that point then the operation succeeded.
+func (b *Backend) Operation(ctx context.Context, op *backend.Operation) (*backend.RunningOperation, error) {
+	// Determine the function to call for our operation
+	var f opFunc
+	switch op.Type {
+	case backend.OperationTypeRefresh:
+		f = b.opRefresh
+	case backend.OperationTypePlan:
+		f = b.opPlan
+	case backend.OperationTypeApply:
+		f = b.opApply
+		if op.Destroy {
+			f = b.opDestroy
+		}
+	case backend.OperationTypeOutput:
+		// Outputs are only read, so they don't wait for the operation lock
+		return b.outputOperation(ctx, op), nil
+	default:
+		return nil, fmt.Errorf(
+			"Unsupported operation type: %s\n\n"+
+				"This is a bug in Terraform and should be reported. The Atlas backend\n"+
+				"is built-in to Terraform and should always support all operations.",
+			op.Type)
+	}
+
+	// Only one operation may run at a time. Rather than blocking until the
+	// running one completes, fail right away.
+	b.opLock.Lock()
+	if b.opRunning {
+		b.opLock.Unlock()
+		return nil, errOperationInProgress
+	}
+	b.opRunning = true
+	forceCh := make(chan struct{})
+	b.opForceCh = forceCh
+
+	// Every request of the operation carries the same correlation ID
+	b.correlation.Start()
+
+	// Build our running operation
+	ctx, opCancel := context.WithCancel(ctx)
+	runningCtx, runningCtxCancel := context.WithCancel(context.Background())
+	b.opCancel = opCancel
+	b.opDone = runningCtx.Done()
+	b.opLock.Unlock()
+
+	var forceOnce sync.Once
+	runningOp := &backend.RunningOperation{
+		Context: runningCtx,
+		Cancel:  func() { forceOnce.Do(func() { close(forceCh) }) },
+	}
+
+	// Do it
+	// The operation is no longer running by the time it's reported done,
+	// so another can be started straight away.
+	//
+	// Cancelling ctx interrupts the operation, which then winds down and
+	// saves whatever state it has before the running context is done.
+	// Cancelling the running context any earlier would let the caller
+	// exit before the state is saved. Calling runningOp.Cancel after that
+	// stops an apply from waiting for the resources still in progress.
+	go func() {
+		defer runningCtxCancel()
+		defer func() {
+			b.opLock.Lock()
+			b.opRunning = false
+			b.opCancel = nil
+			b.opDone = nil
+			b.correlation.Set("")
+			b.opLock.Unlock()
+			opCancel()
+		}()
+
+		// Don't start at all if we were cancelled while being set up
+		select {
+		case <-ctx.Done():
+			runningOp.Err = ctx.Err()
+			return
+		default:
+		}
+
+		b.observer().OperationStarted(op.Type)
+		f(ctx, op, runningOp)
+		b.observer().OperationFinished(op.Type, runningOp.Err)
+	}()
+
+	// Return
+	return runningOp, nil
+}
+
+// Close releases the resources held by the backend, for callers that
+// create and discard backends in a long-lived process. A running operation
+// is interrupted as if its context was cancelled, and Close waits for it to
+// save its state and stop. The idle connections to Atlas are then closed.
+//
+// It's safe to call Close more than once, and on a backend that isn't
+// configured.
+func (b *Backend) Close() error {
+	b.opLock.Lock()
+	cancel, done := b.opCancel, b.opDone
+	b.opLock.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	if b.httpClient != nil {
+		closeIdleConnections(b.httpClient.HTTPClient.Transport)
 	}
 
-	return &remote.State{Client: b.stateClient}, nil
+	return nil
+}
+
+// ForceUnlock removes the lock with the given ID on the named state, which
+// is how `terraform force-unlock` unlocks Atlas states. If id is empty,
+// whichever lock holds the state is removed, as with `force-unlock -any`.
+// The lock that was discarded is output to the CLI so that it's clear what
+// was overridden.
+func (b *Backend) ForceUnlock(name, id string) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	env, err := b.environment(name)
+	if err != nil {
+		return err
+	}
+
+	info, err := b.stateClient.forEnvironment(env).ForceUnlock(id)
+	b.warnClockSkew()
+	if err != nil {
+		return fmt.Errorf("Error force-unlocking state: %s", err)
+	}
+
+	if b.CLI != nil {
+		if info == nil {
+			b.CLI.Output("The state was not locked.")
+		} else {
+			msg := "Discarded the following lock:"
+			if b.stateClient.DryRun {
+				msg = "Dry run: would discard the following lock:"
+			}
+			b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+				"[reset][yellow]%s[reset]\n\n%s", msg, info)))
+		}
+	}
+
+	return nil
 }
 
 // Colorize returns the Colorize structure that can be used for colorizing
 // output. This is gauranteed to always return a non-nil value and so is useful
 // as a helper to wrap any potentially colored strings.
+//
+// Color is disabled if NO_COLOR is set, or if the CLI is known to write
+// to something other than a terminal.
 func (b *Backend) Colorize() *colorstring.Colorize {
-	if b.CLIColor != nil {
+	if b.CLIColor != nil && !b.colorDisabled() {
 		return b.CLIColor
 	}
 
@@ -95,23 +554,345 @@ func (b *Backend) init() {
 	b.schema = &schema.Backend{
 		Schema: map[string]*schema.Schema{
 			"name": &schema.Schema{
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: schemaDescriptions["name"],
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  schemaDescriptions["name"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_NAME", nil),
+				ValidateFunc: validateName,
 			},
 
 			"access_token": &schema.Schema{
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				Description: schemaDescriptions["access_token"],
 				DefaultFunc: schema.EnvDefaultFunc("ATLAS_TOKEN", nil),
 			},
 
+			"access_token_file": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   schemaDescriptions["access_token_file"],
+				DefaultFunc:   schema.EnvDefaultFunc("ATLAS_ACCESS_TOKEN_FILE", nil),
+				ConflictsWith: []string{"access_token"},
+			},
+
 			"address": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["address"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_ADDRESS", defaultAtlasServer),
+				ValidateFunc: validateAddress,
+			},
+
+			"read_address": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["read_address"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_READ_ADDRESS", nil),
+				ValidateFunc: validateAddress,
+			},
+
+			"read_fallback": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["read_fallback"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_READ_FALLBACK", true),
+			},
+
+			"gzip": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["gzip"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_GZIP", true),
+			},
+
+			"poll_interval": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["poll_interval"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_POLL_INTERVAL", defaultPollInterval.String()),
+				ValidateFunc: validatePollInterval,
+			},
+
+			"run_timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["run_timeout"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_RUN_TIMEOUT", defaultRunTimeout.String()),
+				ValidateFunc: validateTimeout,
+			},
+
+			"timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["timeout"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_TIMEOUT", defaultTimeout.String()),
+				ValidateFunc: validateTimeout,
+			},
+
+			"retry_max": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  schemaDescriptions["retry_max"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_RETRY_MAX", defaultRetryMax),
+				ValidateFunc: validateRetryMax,
+			},
+
+			"ca_cert": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["ca_cert"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_CAFILE", ""),
+			},
+
+			"http_basic_user": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["http_basic_user"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_HTTP_BASIC_USER", nil),
+			},
+
+			"http_basic_password": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["http_basic_password"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_HTTP_BASIC_PASSWORD", nil),
+			},
+
+			"user_agent": &schema.Schema{
 				Type:        schema.TypeString,
 				Optional:    true,
-				Description: schemaDescriptions["address"],
-				DefaultFunc: schema.EnvDefaultFunc("ATLAS_ADDRESS", defaultAtlasServer),
+				Description: schemaDescriptions["user_agent"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_USER_AGENT", nil),
+			},
+
+			"headers": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: schemaDescriptions["headers"],
+			},
+
+			"environment_variables": &schema.Schema{
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: schemaDescriptions["environment_variables"],
+			},
+
+			"env_var_prefix": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["env_var_prefix"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_ENV_VAR_PREFIX", nil),
+			},
+
+			"sensitive_variables": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: schemaDescriptions["sensitive_variables"],
+			},
+
+			"chunk_size": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  schemaDescriptions["chunk_size"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_CHUNK_SIZE", defaultChunkSize),
+				ValidateFunc: validateChunkSize,
+			},
+
+			"parallelism": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  schemaDescriptions["parallelism"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_PARALLELISM", nil),
+				ValidateFunc: validateParallelism,
+			},
+
+			"run_message": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["run_message"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_RUN_MESSAGE", defaultRunMessage),
+			},
+
+			"execution_mode": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["execution_mode"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_EXECUTION_MODE", executionModeRemote),
+				ValidateFunc: validateExecutionMode,
+			},
+
+			"cost_estimate": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["cost_estimate"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_COST_ESTIMATE", true),
+			},
+
+			"terraform_version": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["terraform_version"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_TERRAFORM_VERSION", nil),
+				ValidateFunc: validateTerraformVersion,
+			},
+
+			"backup_dir": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["backup_dir"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_BACKUP_DIR", nil),
+			},
+
+			"disable_backup": &schema.Schema{
+				Type:          schema.TypeBool,
+				Optional:      true,
+				Description:   schemaDescriptions["disable_backup"],
+				DefaultFunc:   schema.EnvDefaultFunc("ATLAS_DISABLE_BACKUP", nil),
+				ConflictsWith: []string{"backup_dir"},
+			},
+
+			"backup_count": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  schemaDescriptions["backup_count"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_BACKUP_COUNT", defaultBackupCount),
+				ValidateFunc: validateBackupCount,
+			},
+
+			"output_format": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["output_format"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_OUTPUT_FORMAT", outputFormatHuman),
+				ValidateFunc: validateOutputFormat,
+			},
+
+			"proxy_url": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["proxy_url"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_PROXY_URL", nil),
+				ValidateFunc: validateAddress,
+			},
+
+			"skip_cert_verification": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["skip_cert_verification"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_SKIP_CERT_VERIFICATION", false),
+			},
+
+			"force_lineage": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["force_lineage"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_FORCE_LINEAGE", false),
+			},
+
+			"max_idle_conns": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  schemaDescriptions["max_idle_conns"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_MAX_IDLE_CONNS", defaultMaxIdleConns),
+				ValidateFunc: validateMaxIdleConns,
+			},
+
+			"disable_keep_alives": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["disable_keep_alives"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_DISABLE_KEEP_ALIVES", false),
+			},
+
+			"lock_timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["lock_timeout"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_LOCK_TIMEOUT", "0s"),
+				ValidateFunc: validateLockTimeout,
+			},
+
+			"save_plan": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["save_plan"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_SAVE_PLAN", false),
+			},
+
+			"allow_cli_apply": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["allow_cli_apply"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_ALLOW_CLI_APPLY", false),
+			},
+
+			"encryption_passphrase": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["encryption_passphrase"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_STATE_KEY", ""),
+			},
+
+			"tls_min_version": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["tls_min_version"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_TLS_MIN_VERSION", defaultTLSMinVersion),
+				ValidateFunc: validateTLSMinVersion,
+			},
+
+			"create_environment": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["create_environment"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_CREATE_ENVIRONMENT", false),
+			},
+
+			"confirm_destroy": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: schemaDescriptions["confirm_destroy"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_CONFIRM_DESTROY", nil),
+			},
+
+			"max_state_size": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  schemaDescriptions["max_state_size"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_MAX_STATE_SIZE", defaultMaxStateSize),
+				ValidateFunc: validateMaxStateSize,
+			},
+
+			"retry_wait_min": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["retry_wait_min"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_RETRY_WAIT_MIN", defaultRetryWaitMin.String()),
+				ValidateFunc: validateTimeout,
+			},
+
+			"retry_wait_max": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  schemaDescriptions["retry_wait_max"],
+				DefaultFunc:  schema.EnvDefaultFunc("ATLAS_RETRY_WAIT_MAX", defaultRetryWaitMax.String()),
+				ValidateFunc: validateTimeout,
+			},
+
+			"dry_run": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["dry_run"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_DRY_RUN", false),
+			},
+
+			"check_organization_access": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: schemaDescriptions["check_organization_access"],
+				DefaultFunc: schema.EnvDefaultFunc("ATLAS_CHECK_ORGANIZATION_ACCESS", true),
 			},
 		},
 
@@ -129,35 +910,649 @@ func (b *Backend) schemaConfigure(ctx context.Context) error {
 		return fmt.Errorf("Error parsing 'address': %s", err)
 	}
 
+	// Parse the address of the read replica, if any
+	var readURL *url.URL
+	if v := d.Get("read_address").(string); v != "" {
+		readURL, err = url.Parse(v)
+		if err != nil {
+			return fmt.Errorf("Error parsing 'read_address': %s", err)
+		}
+	}
+
 	// Parse the org/env
-	name := d.Get("name").(string)
-	parts := strings.Split(name, "/")
-	if len(parts) != 2 {
-		return fmt.Errorf("malformed name '%s', expected format '<org>/<name>'", name)
+	b.name = d.Get("name").(string)
+	org, env, err := b.parsedName()
+	if err != nil {
+		return err
+	}
+
+	// Read the access token, from a file if one is given
+	accessToken := d.Get("access_token").(string)
+	if path := d.Get("access_token_file").(string); path != "" {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Error reading 'access_token_file' %s: %s", path, err)
+		}
+
+		accessToken = strings.TrimRight(string(raw), " \t\r\n")
+	}
+
+	// Otherwise use the token that terraform login stored for the host
+	if accessToken == "" {
+		accessToken, err = credentialsToken(addrUrl.Host)
+		if err != nil {
+			return err
+		}
+	}
+	b.outputFormat = d.Get("output_format").(string)
+
+	// Parse the poll interval. This has already been validated.
+	pollInterval, err := time.ParseDuration(d.Get("poll_interval").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing 'poll_interval': %s", err)
+	}
+	b.pollInterval = pollInterval
+
+	runTimeout, err := time.ParseDuration(d.Get("run_timeout").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing 'run_timeout': %s", err)
+	}
+	b.runTimeout = runTimeout
+
+	// Parse the request timeout. This has already been validated.
+	timeout, err := time.ParseDuration(d.Get("timeout").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing 'timeout': %s", err)
+	}
+
+	lockTimeout, err := time.ParseDuration(d.Get("lock_timeout").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing 'lock_timeout': %s", err)
 	}
-	org := parts[0]
-	env := parts[1]
+
+	retryWaitMin, err := time.ParseDuration(d.Get("retry_wait_min").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing 'retry_wait_min': %s", err)
+	}
+	retryWaitMax, err := time.ParseDuration(d.Get("retry_wait_max").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing 'retry_wait_max': %s", err)
+	}
+
+	// Load the CA certificates, if any
+	var rootCAs *x509.CertPool
+	if v := d.Get("ca_cert").(string); v != "" {
+		rootCAs, err = loadCACert(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse the proxy URL, if any. Otherwise the proxy is taken from the
+	// environment.
+	var proxyURL *url.URL
+	if v := d.Get("proxy_url").(string); v != "" {
+		proxyURL, err = url.Parse(v)
+		if err != nil {
+			return fmt.Errorf("Error parsing 'proxy_url': %s", err)
+		}
+	}
+
+	// Read the custom headers
+	headers := make(map[string]string)
+	for k, v := range d.Get("headers").(map[string]interface{}) {
+		headers[k] = v.(string)
+	}
+
+	// Read the variables to upload for runs. Those forwarded from the
+	// environment are treated as sensitive, since there's no telling what
+	// they hold, and the ones that are configured take precedence.
+	b.envVariables = make(map[string]string)
+	b.sensitiveVariables = make(map[string]bool)
+	for k, v := range d.Get("environment_variables").(map[string]interface{}) {
+		b.envVariables[k] = v.(string)
+	}
+	for k, v := range prefixedEnv(d.Get("env_var_prefix").(string)) {
+		if _, ok := b.envVariables[k]; !ok {
+			b.envVariables[k] = v
+			b.sensitiveVariables[k] = true
+		}
+	}
+	for _, v := range d.Get("sensitive_variables").([]interface{}) {
+		b.sensitiveVariables[v.(string)] = true
+	}
+
+	// Secrets must never be output
+	b.secrets = new(redactor)
+	passphrase := d.Get("encryption_passphrase").(string)
+	b.secrets.Add(accessToken, d.Get("http_basic_password").(string), passphrase)
+	for k, v := range b.envVariables {
+		if b.sensitiveVariables[k] {
+			b.secrets.Add(v)
+		}
+	}
+
+	b.terraformVersion = d.Get("terraform_version").(string)
+	b.costEstimate = d.Get("cost_estimate").(bool)
+	b.executionMode = d.Get("execution_mode").(string)
+	b.runMessage = d.Get("run_message").(string)
+	b.savePlan = d.Get("save_plan").(bool)
+	b.allowCLIApply = d.Get("allow_cli_apply").(bool)
+	b.confirmDestroy = d.Get("confirm_destroy").(string)
+	b.parallelism = d.Get("parallelism").(int)
+
+	// Backups go to a temporary directory unless told otherwise. With no
+	// directory at all, the state is written straight to Atlas.
+	backupDir := d.Get("backup_dir").(string)
+	if backupDir == "" {
+		backupDir = defaultBackupDir()
+	}
+	if d.Get("disable_backup").(bool) {
+		backupDir = ""
+	}
+
+	// The server may have changed, so what's known about it is forgotten
+	b.serverInfoLock.Lock()
+	b.serverInfo = nil
+	b.serverInfoLock.Unlock()
 
 	// Setup the client
 	b.stateClient = &stateClient{
 		Server:      addr,
 		ServerURL:   addrUrl,
-		AccessToken: d.Get("access_token").(string),
+		AccessToken: accessToken,
 		User:        org,
 		Name:        env,
+		GZip:        d.Get("gzip").(bool),
+		RootCAs:     rootCAs,
+		ProxyURL:    proxyURL,
+		BasicUser:   d.Get("http_basic_user").(string),
+		BasicPass:   d.Get("http_basic_password").(string),
+		UserAgent:   d.Get("user_agent").(string),
+		Headers:     headers,
+		BackupDir:   backupDir,
+		BackupCount: d.Get("backup_count").(int),
+		ChunkSize:   d.Get("chunk_size").(int),
+		Timeout:     timeout,
+		LockTimeout: lockTimeout,
+		RetryMax:    d.Get("retry_max").(int),
+		Metrics:     b.Metrics,
+		Serializer:  b.Serializer,
+		Observer:    b.Observer,
+
+		ReadServerURL:        readURL,
+		ReadFallback:         d.Get("read_fallback").(bool),
+		SkipCertVerification: d.Get("skip_cert_verification").(bool),
+		Force:                d.Get("force_lineage").(bool),
+		MaxIdleConns:         d.Get("max_idle_conns").(int),
+		DisableKeepAlives:    d.Get("disable_keep_alives").(bool),
+		TLSMinVersion:        tlsVersions[d.Get("tls_min_version").(string)],
+		MaxStateSize:         d.Get("max_state_size").(int),
+		RetryWaitMin:         retryWaitMin,
+		RetryWaitMax:         retryWaitMax,
+		DryRun:               d.Get("dry_run").(bool),
 
 		// This is optionally set during Atlas Terraform runs.
 		RunId: os.Getenv("ATLAS_RUN_ID"),
 	}
+	if passphrase != "" {
+		b.stateClient.Cipher = &stateCipher{passphrase: passphrase}
+	}
+
+	b.stateClient.redactor = b.secrets
+	b.stateClient.skew = new(clockSkew)
+	b.stateClient.etags = new(etagCache)
+	b.stateClient.connStats = new(transportCounters)
+	b.stateClient.dryRunOutput = func(msg string) {
+		if b.CLI != nil {
+			b.CLI.Output(b.Colorize().Color("[reset][yellow]" + msg))
+		}
+	}
+	b.correlation = new(correlationID)
+	b.stateClient.correlation = b.correlation
+
+	// Build the HTTP client now rather than on first use, so that the
+	// client can be used concurrently, such as by FetchState.
+	b.httpClient, err = b.stateClient.newHTTPClient()
+	if err != nil {
+		return fmt.Errorf("Failed to create HTTP client for Atlas: %v", err)
+	}
+	b.stateClient.HTTPClient = b.httpClient
+
+	if d.Get("check_organization_access").(bool) {
+		if err := b.stateClient.checkOrganizationAccess(ctx); err != nil {
+			return err
+		}
+	}
+
+	if d.Get("create_environment").(bool) {
+		if _, err := b.stateClient.ensureEnvironment(ctx); err != nil {
+			return fmt.Errorf("Error creating environment %s: %s", b.name, err)
+		}
+	}
 
 	return nil
 }
 
+// hasAccessToken returns true if an access token is given by the
+// configuration, the environment or the CLI credentials file.
+func hasAccessToken(c *terraform.ResourceConfig) bool {
+	if _, ok := c.Get("access_token"); ok {
+		return true
+	}
+	if _, ok := c.Get("access_token_file"); ok {
+		return true
+	}
+	if os.Getenv("ATLAS_TOKEN") != "" {
+		return true
+	}
+
+	addr := os.Getenv("ATLAS_ADDRESS")
+	if addr == "" {
+		addr = defaultAtlasServer
+	}
+	if v, ok := c.Get("address"); ok {
+		addr, _ = v.(string)
+	}
+
+	return hasCredentialsToken(addr)
+}
+
+// hasCredentialsToken returns true if the CLI credentials file has a token
+// for the host of the given address.
+func hasCredentialsToken(addr string) bool {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return false
+	}
+
+	// Errors reading the file are reported by Configure
+	token, _ := credentialsToken(u.Host)
+	return token != ""
+}
+
+// headerKeys returns the names of the headers in the raw headers
+// configuration, which may be a map or a list of maps depending on how it
+// was parsed.
+func headerKeys(raw interface{}) []string {
+	var keys []string
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case []map[string]interface{}:
+		for _, m := range v {
+			for k := range m {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// loadCACert builds a certificate pool from ca_cert, which is either a PEM
+// encoded certificate bundle or the path to one.
+func loadCACert(v string) (*x509.CertPool, error) {
+	pem := []byte(v)
+	if !strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN") {
+		var err error
+		pem, err = ioutil.ReadFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading 'ca_cert': %s", err)
+		}
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(
+			"Error parsing 'ca_cert': no valid PEM encoded certificates found")
+	}
+
+	return pool, nil
+}
+
+// parsedName returns the organization and environment of the configured
+// name.
+func (b *Backend) parsedName() (org, env string, err error) {
+	return parseName(b.name)
+}
+
+// nameSegmentRegexp matches a single segment of a name.
+var nameSegmentRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// parseName splits a name in the form "organization/environment".
+func parseName(name string) (org, env string, err error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 ||
+		!nameSegmentRegexp.MatchString(parts[0]) ||
+		!nameSegmentRegexp.MatchString(parts[1]) {
+		return "", "", fmt.Errorf(
+			"name must be in the form \"organization/environment\", got %q", name)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// validateName requires name to be in the form "organization/environment".
+func validateName(v interface{}, k string) ([]string, []error) {
+	if _, _, err := parseName(v.(string)); err != nil {
+		return nil, []error{err}
+	}
+
+	return nil, nil
+}
+
+// validateAddress requires address to be an absolute HTTP or HTTPS URL,
+// such as "https://atlas.hashicorp.com", which may include a base path but
+// no query or fragment.
+func validateAddress(v interface{}, k string) ([]string, []error) {
+	addr := v.(string)
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s is not a valid URL: %s", k, err)}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, []error{fmt.Errorf(
+			"%s must be an http or https URL, such as \"https://%s\"",
+			k, strings.TrimPrefix(addr, u.Scheme+"://"))}
+	}
+	if u.Host == "" {
+		return nil, []error{fmt.Errorf("%s must include a host: %s", k, addr)}
+	}
+	// A path is allowed, for Atlas served beneath one, but the API paths
+	// are joined beneath it so there can't be a query or fragment.
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, []error{fmt.Errorf(
+			"%s must not include a query or fragment: %s", k, addr)}
+	}
+
+	return nil, nil
+}
+
+// validatePollInterval requires poll_interval to be a duration of at least
+// minPollInterval, so that remote runs don't hammer the Atlas API.
+func validatePollInterval(v interface{}, k string) ([]string, []error) {
+	d, err := time.ParseDuration(v.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf(
+			"%s must be a duration such as \"3s\": %s", k, err)}
+	}
+	if d < minPollInterval {
+		return nil, []error{fmt.Errorf(
+			"%s must be at least %s, got %s", k, minPollInterval, d)}
+	}
+
+	return nil, nil
+}
+
+// validateTimeout requires timeout to be a positive duration.
+func validateTimeout(v interface{}, k string) ([]string, []error) {
+	d, err := time.ParseDuration(v.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf(
+			"%s must be a duration such as \"30s\": %s", k, err)}
+	}
+	if d <= 0 {
+		return nil, []error{fmt.Errorf("%s must be positive, got %s", k, d)}
+	}
+
+	return nil, nil
+}
+
+// validateLockTimeout requires lock_timeout to be a duration that isn't
+// negative. Zero disables waiting for the lock.
+func validateLockTimeout(v interface{}, k string) ([]string, []error) {
+	d, err := time.ParseDuration(v.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf(
+			"%s must be a duration such as \"5m\": %s", k, err)}
+	}
+	if d < 0 {
+		return nil, []error{fmt.Errorf("%s can't be negative, got %s", k, d)}
+	}
+
+	return nil, nil
+}
+
+// validateOutputFormat requires output_format to be a known format.
+func validateOutputFormat(v interface{}, k string) ([]string, []error) {
+	switch v.(string) {
+	case outputFormatHuman, outputFormatJSON:
+		return nil, nil
+	default:
+		return nil, []error{fmt.Errorf(
+			"%s must be %q or %q", k, outputFormatHuman, outputFormatJSON)}
+	}
+}
+
+// validateExecutionMode requires execution_mode to be a known mode.
+func validateExecutionMode(v interface{}, k string) ([]string, []error) {
+	switch v.(string) {
+	case executionModeRemote, executionModeLocalApply:
+		return nil, nil
+	default:
+		return nil, []error{fmt.Errorf(
+			"%s must be %q or %q", k, executionModeRemote, executionModeLocalApply)}
+	}
+}
+
+// validateBackupCount requires backup_count to be positive.
+func validateBackupCount(v interface{}, k string) ([]string, []error) {
+	if v.(int) < 1 {
+		return nil, []error{fmt.Errorf("%s must be at least 1", k)}
+	}
+
+	return nil, nil
+}
+
+// validateRetryMax requires retry_max to not be negative.
+func validateRetryMax(v interface{}, k string) ([]string, []error) {
+	if v.(int) < 0 {
+		return nil, []error{fmt.Errorf("%s must not be negative", k)}
+	}
+
+	return nil, nil
+}
+
+// validateMaxIdleConns requires max_idle_conns to be positive.
+func validateMaxIdleConns(v interface{}, k string) ([]string, []error) {
+	if v.(int) < 1 {
+		return nil, []error{fmt.Errorf("%s must be at least 1", k)}
+	}
+
+	return nil, nil
+}
+
+// validateTLSMinVersion requires tls_min_version to be a known version of
+// TLS.
+func validateTLSMinVersion(v interface{}, k string) ([]string, []error) {
+	if _, ok := tlsVersions[v.(string)]; ok {
+		return nil, nil
+	}
+
+	versions := make([]string, 0, len(tlsVersions))
+	for version := range tlsVersions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	return nil, []error{fmt.Errorf(
+		"%s must be one of %s, got %q", k, strings.Join(versions, ", "), v)}
+}
+
+// validateParallelism requires parallelism to be positive.
+func validateParallelism(v interface{}, k string) ([]string, []error) {
+	if v.(int) < 1 {
+		return nil, []error{fmt.Errorf("%s must be at least 1", k)}
+	}
+
+	return nil, nil
+}
+
+func validateChunkSize(v interface{}, k string) ([]string, []error) {
+	if v.(int) < minChunkSize {
+		return nil, []error{fmt.Errorf(
+			"%s must be at least %d bytes", k, minChunkSize)}
+	}
+
+	return nil, nil
+}
+
+func validateMaxStateSize(v interface{}, k string) ([]string, []error) {
+	if v.(int) < 1 {
+		return nil, []error{fmt.Errorf("%s must be at least 1 byte", k)}
+	}
+
+	return nil, nil
+}
+
+func validateTerraformVersion(v interface{}, k string) ([]string, []error) {
+	if _, err := version.NewVersion(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf(
+			"%s must be a version such as \"0.9.3\": %s", k, err)}
+	}
+
+	return nil, nil
+}
+
+// errAccessTokenRequired is returned by Validate when no access token is
+// given.
+var errAccessTokenRequired = errors.New(
+	"\"access_token\": required field is not set. It can also be set with\n" +
+		"access_token_file, the ATLAS_TOKEN environment variable or the CLI\n" +
+		"credentials file written by terraform login.")
+
+// opFunc is the signature of the functions that perform each operation.
+//
+// An operation reports failure by setting runningOp.Err, along with any
+// partial State, before returning. The running context is only cancelled
+// after the function returns, so callers never see it done with the error
+// still unset.
+type opFunc func(ctx context.Context, op *backend.Operation, runningOp *backend.RunningOperation)
+
+// errOperationInProgress is returned by Operation when another operation is
+// already running.
+var errOperationInProgress = errors.New(
+	"another operation is already in progress on this Atlas backend")
+
+// errNotConfigured is returned when state is requested before Configure.
+var errNotConfigured = errors.New(
+	"the Atlas backend must be configured before its state can be accessed")
+
 var schemaDescriptions = map[string]string{
 	"name": "Full name of the environment in Atlas, such as 'hashicorp/myenv'",
 	"access_token": "Access token to use to access Atlas. If ATLAS_TOKEN is set then\n" +
-		"this will override any saved value for this.",
+		"this will override any saved value for this. If neither is set, the\n" +
+		"token for the host in the CLI credentials file is used.",
+	"access_token_file": "Path to a file containing the access token to use to access\n" +
+		"Atlas. This can't be used together with access_token.",
 	"address": "Address to your Atlas installation. This defaults to the publicly\n" +
 		"hosted version at 'https://atlas.hashicorp.com/'. This address\n" +
-		"should contain the full HTTP scheme to use.",
+		"should contain the full HTTP scheme to use, and may include a path\n" +
+		"if Atlas is served beneath one.",
+	"read_address": "Address of a read-only replica of Atlas to read the state and list\n" +
+		"runs from. Writes are always made to address.",
+	"read_fallback": "Read the state from address when the replica at read_address has\n" +
+		"none, in case the replica is behind. This defaults to true.",
+	"gzip": "Compress the state with gzip when uploading it to Atlas. This\n" +
+		"defaults to true.",
+	"timeout": "How long to wait for each request to Atlas to complete, such as\n" +
+		"'30s'. This defaults to 30s.",
+	"retry_max": "How many times to retry a request to Atlas that fails with a\n" +
+		"transient error. State writes are only retried on connection errors.\n" +
+		"This defaults to 3.",
+	"ca_cert": "PEM encoded CA certificates, or the path to a file containing\n" +
+		"them, to trust when connecting to Atlas. If ATLAS_CAFILE is set then\n" +
+		"it is used when this isn't.",
+	"http_basic_user": "User for HTTP basic auth, for Atlas installations behind a\n" +
+		"gateway that requires it. This is sent in addition to access_token.",
+	"http_basic_password": "Password for HTTP basic auth. This must be set if\n" +
+		"http_basic_user is.",
+	"user_agent": "Text to append to the User-Agent sent with requests to Atlas,\n" +
+		"such as the name of the CI system running Terraform.",
+	"headers": "Custom HTTP headers to send with every request to Atlas, such as\n" +
+		"those required by a gateway in front of it. Headers set by Terraform,\n" +
+		"like Authorization and Content-MD5, can't be overridden.",
+	"backup_dir": "Directory to write a local backup of the state to before each\n" +
+		"write to Atlas. This defaults to a directory in the system's\n" +
+		"temporary directory.",
+	"disable_backup": "Write the state straight to Atlas without a local backup, such\n" +
+		"as on ephemeral or read-only CI runners. This defaults to false.",
+	"backup_count": "How many local backups of each environment's state to keep.\n" +
+		"Older backups are removed. This defaults to 10.",
+	"output_format": "Format of the output of operations: 'human' for colored text,\n" +
+		"or 'json' for a JSON object per line. This defaults to 'human'.",
+	"proxy_url": "URL of an HTTP proxy to connect to Atlas through. By default the\n" +
+		"proxy is taken from the HTTP_PROXY and HTTPS_PROXY environment variables.",
+	"skip_cert_verification": "Skip verification of the Atlas server's TLS certificate. This\n" +
+		"makes the connection vulnerable to interception, exposing the state\n" +
+		"and access token, and should only be used for testing.",
+	"poll_interval": "How often to poll Atlas for the status of a run, such as '3s'.\n" +
+		"This must be at least 1s. If ATLAS_POLL_INTERVAL is set then it is\n" +
+		"used when this isn't.",
+	"environment_variables": "Environment variables to set for runs in Atlas, uploaded along\n" +
+		"with the Terraform variables of each plan and apply.",
+	"env_var_prefix": "Prefix of the variables in Terraform's environment to forward to\n" +
+		"runs in Atlas as environment variables, without the prefix. Their\n" +
+		"values are treated as sensitive.",
+	"sensitive_variables": "Names of variables whose values are sensitive. Their values are\n" +
+		"write-only in Atlas and are never output by Terraform.",
+	"run_timeout": "How long to wait for a run in Atlas to finish, such as '1h'.\n" +
+		"This defaults to 1h.",
+	"chunk_size": "Size in bytes of each part when a state that is too large for a\n" +
+		"single request is uploaded in parts. This defaults to 4MB.",
+	"parallelism": "Limit on the number of concurrent operations, for both local and\n" +
+		"Atlas runs. If not set, the -parallelism flag is used, which defaults\n" +
+		"to 10.",
+	"run_message": "Message describing the runs recorded in Atlas, such as the commit\n" +
+		"or pull request they're for.",
+	"execution_mode": "How operations are executed: 'remote', the default, or 'local-apply'\n" +
+		"to apply the plan of the latest Atlas run locally. local-apply needs\n" +
+		"local provider credentials.",
+	"cost_estimate": "Show Atlas's estimate of the change in monthly cost before applying,\n" +
+		"when running in Atlas. This defaults to true.",
+	"terraform_version": "Version of Terraform for Atlas to use for runs, such as '0.9.3'.\n" +
+		"If this isn't set, the environment's default version is used.",
+	"force_lineage": "Write the state even if its lineage differs from the lineage of\n" +
+		"the state stored in Atlas. By default such writes are refused, since\n" +
+		"they usually mean the state belongs to a different environment.",
+	"max_idle_conns": "How many idle connections to Atlas to keep open for reuse. This\n" +
+		"defaults to 100.",
+	"disable_keep_alives": "Make a new connection to Atlas for every request rather than\n" +
+		"reusing them. This can help when a load balancer holds connections\n" +
+		"open. This defaults to false.",
+	"lock_timeout": "How long to keep retrying to lock the state while it's locked\n" +
+		"by someone else, such as '5m'. This defaults to 0s, which fails\n" +
+		"at once.",
+	"save_plan": "Upload each plan to Atlas, so that it can be reviewed there and\n" +
+		"applied later by its ID. This defaults to false.",
+	"allow_cli_apply": "Allow applies from the CLI even if the environment's runs are\n" +
+		"triggered by a VCS repository. This defaults to false.",
+	"encryption_passphrase": "A passphrase to encrypt the state with before it's written to\n" +
+		"Atlas, using AES-GCM. The state is then only readable with it. This\n" +
+		"can also be set with ATLAS_STATE_KEY.",
+	"tls_min_version": "The oldest version of TLS to connect to Atlas with, such as '1.3'.\n" +
+		"This defaults to 1.2.",
+	"create_environment": "Create the environment in Atlas when the backend is configured,\n" +
+		"if it doesn't exist yet. This defaults to false.",
+	"confirm_destroy": "The name of the environment, to destroy it even if Atlas protects\n" +
+		"it from destroys.",
+	"max_state_size": "The largest state in bytes that's written to Atlas. Writing a\n" +
+		"larger state fails rather than attempting the upload. This defaults\n" +
+		"to 100MB.",
+	"retry_wait_min": "How long to wait before the first retry of a failed request,\n" +
+		"such as '1s'. The wait doubles with each retry, up to retry_wait_max.\n" +
+		"This defaults to 1s.",
+	"retry_wait_max": "The longest wait between retries of a failed request, such as\n" +
+		"'30s'. This defaults to 30s.",
+	"dry_run": "Output the changes that would be made in Atlas, such as writing\n" +
+		"or locking the state, rather than making them. The state is still\n" +
+		"read. This defaults to false.",
+	"check_organization_access": "Check that the access token has access to the organization in\n" +
+		"name when the backend is configured, so that a mis-scoped token\n" +
+		"fails straight away. This defaults to true; turning it off saves a\n" +
+		"request to Atlas on every command.",
 }
@@ -0,0 +1,392 @@
+package atlas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform/backend"
+	backendlocal "github.com/hashicorp/terraform/backend/local"
+	"github.com/hashicorp/terraform/command/clistate"
+	"github.com/hashicorp/terraform/command/format"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func (b *Backend) opApply(
+	ctx context.Context,
+	op *backend.Operation,
+	runningOp *backend.RunningOperation) {
+	log.Printf("[INFO] backend/atlas: starting Apply operation")
+
+	if err := b.checkCLIApply(); err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	// A plan saved to Atlas is downloaded and applied as it is
+	if op.PlanId != "" && op.Plan == nil {
+		plan, err := b.stateClient.getPlan(ctx, op.PlanId)
+		if err != nil {
+			runningOp.Err = err
+			return
+		}
+
+		savedOp := *op
+		savedOp.Plan = plan
+		op = &savedOp
+	}
+
+	// In the local-apply execution mode, the plan made by Atlas is
+	// downloaded and applied here.
+	var remoteRun *Run
+	if b.executionMode == executionModeLocalApply && op.Plan == nil {
+		plan, run, err := b.remotePlan(ctx)
+		if err != nil {
+			runningOp.Err = err
+			return
+		}
+
+		remoteOp := *op
+		remoteOp.Plan = plan
+		op = &remoteOp
+		remoteRun = run
+	}
+
+	// An apply requires either a plan or a module
+	if op.Plan == nil && op.Module == nil && !op.Destroy {
+		runningOp.Err = errors.New(strings.TrimSpace(applyErrNoConfig))
+		return
+	}
+
+	// If we have a nil module at this point, then set it to an empty tree
+	// to avoid any potential crashes.
+	if op.Module == nil {
+		op.Module = module.NewEmptyTree()
+	}
+
+	// Setup our count hook that keeps track of resource changes, and our
+	// state hook that persists the state as resources complete.
+	countHook := new(backendlocal.CountHook)
+	stateHook := new(stateHook)
+	if b.ContextOpts == nil {
+		b.ContextOpts = new(terraform.ContextOpts)
+	}
+	old := b.ContextOpts.Hooks
+	defer func() { b.ContextOpts.Hooks = old }()
+	b.ContextOpts.Hooks = append(b.ContextOpts.Hooks, countHook, stateHook)
+
+	// Get our context
+	tfCtx, opState, err := b.context(op)
+	if err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	if op.LockState {
+		lockCtx, cancel := context.WithTimeout(ctx, op.StateLockTimeout)
+		defer cancel()
+
+		lockInfo := state.NewLockInfo()
+		lockInfo.Operation = op.Type.String()
+		lockID, err := clistate.Lock(lockCtx, opState, lockInfo, b.CLI, b.Colorize())
+		b.warnClockSkew()
+		if err != nil {
+			runningOp.Err = errwrap.Wrapf("Error locking state: {{err}}", err)
+			return
+		}
+
+		// This is deferred so that the lock is released even if the apply
+		// panics.
+		defer func() {
+			if err := clistate.Unlock(opState, lockID, b.CLI, b.Colorize()); err != nil {
+				runningOp.Err = multierror.Append(runningOp.Err, err)
+			}
+		}()
+	}
+
+	// Configure the run in Atlas, unless Atlas has already made the plan
+	var runID string
+	if remoteRun == nil {
+		run, err := b.prepareRun(ctx, op)
+		if err != nil {
+			runningOp.Err = err
+			return
+		}
+		if run != nil {
+			runID = run.ID
+		}
+	} else {
+		runID = remoteRun.ID
+	}
+
+	b.warnTargeted(op)
+
+	// Setup the state
+	runningOp.State = tfCtx.State()
+
+	// If we weren't given a plan, then we refresh/plan
+	var needsApproval bool
+	var add, change, destroy int
+	if op.Plan == nil {
+		// Start the refresh and plan in a goroutine so that we can be
+		// interrupted.
+		var plan *terraform.Plan
+		var planErr error
+		planDoneCh := make(chan struct{})
+		go func() {
+			defer close(planDoneCh)
+
+			// If we're refreshing before apply, perform that
+			if op.PlanRefresh {
+				log.Printf("[INFO] backend/atlas: apply calling Refresh")
+				if _, err := tfCtx.Refresh(); err != nil {
+					planErr = errwrap.Wrapf("Error refreshing state: {{err}}", err)
+					return
+				}
+			}
+
+			// Perform the plan
+			log.Printf("[INFO] backend/atlas: apply calling Plan")
+			var err error
+			plan, err = tfCtx.Plan()
+			if err != nil {
+				planErr = errwrap.Wrapf("Error running plan: {{err}}", err)
+			}
+		}()
+
+		select {
+		case <-ctx.Done():
+			if b.CLI != nil {
+				b.CLI.Output("Interrupt received. Gracefully shutting down...")
+			}
+
+			// Stop execution and wait for the plan to wind down. Nothing
+			// has been changed yet, so nothing is applied.
+			go tfCtx.Stop()
+			<-planDoneCh
+			if planErr == nil {
+				planErr = ctx.Err()
+			}
+		case <-planDoneCh:
+		}
+
+		if planErr != nil {
+			runningOp.Err = planErr
+			return
+		}
+
+		// Plans given as an argument have already been reviewed, so only
+		// the plan made here needs to be approved.
+		needsApproval = !plan.Diff.Empty() && !op.AutoApprove
+		add = countHook.ToAdd + countHook.ToRemoveAndAdd
+		change = countHook.ToChange
+		destroy = countHook.ToRemove + countHook.ToRemoveAndAdd
+	}
+
+	// The plan made by Atlas is shown so that it can be reviewed before
+	// it's applied.
+	if remoteRun != nil {
+		if b.CLI != nil {
+			b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+				"[reset][bold]Applying the plan of Atlas run %s.[reset]\n", remoteRun.ID)))
+			b.CLI.Output(format.Plan(&format.PlanOpts{
+				Plan:        op.Plan,
+				Color:       b.Colorize(),
+				ModuleDepth: -1,
+			}))
+		}
+
+		needsApproval = !op.Plan.Diff.Empty() && !op.AutoApprove
+		add, change, destroy = countChanges(op.Plan.Diff)
+	}
+
+	// Show what the apply is estimated to cost before making any changes
+	b.outputCostEstimate(ctx, runID)
+
+	if needsApproval {
+		if err := b.confirmApply(op, add, change, destroy); err != nil {
+			runningOp.Err = err
+			return
+		}
+	}
+
+	// Setup our hook for continuous state updates
+	stateHook.State = opState
+
+	// Start the apply in a goroutine so that we can be interrupted.
+	var applyState *terraform.State
+	var applyErr error
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		_, applyErr = tfCtx.Apply()
+		// we always want the state, even if apply failed
+		applyState = tfCtx.State()
+	}()
+
+	// Wait for the apply to finish or for us to be interrupted so
+	// we can handle it properly.
+	select {
+	case <-ctx.Done():
+		if b.CLI != nil {
+			b.CLI.Output("Interrupt received. Gracefully shutting down...")
+		}
+
+		// Stop execution after the resources in progress
+		go tfCtx.Stop()
+
+		// Wait for completion still, unless we're forced to abort
+		select {
+		case <-doneCh:
+		case <-b.opForceCh:
+			runningOp.Err = b.abortApply(stateHook, opState)
+			runningOp.State = opState.State()
+			return
+		}
+	case <-doneCh:
+	}
+
+	// Store the final state
+	runningOp.State = applyState
+
+	// Persist the state, even if the apply failed, so that any resources
+	// that were created are tracked.
+	if err := opState.WriteState(applyState); err != nil {
+		runningOp.Err = fmt.Errorf("Failed to save state: %s", err)
+		return
+	}
+	if err := opState.PersistState(); err != nil {
+		runningOp.Err = persistError(err, opState)
+		return
+	}
+
+	if applyErr != nil {
+		runningOp.Err = fmt.Errorf(
+			"Error applying plan:\n\n"+
+				"%s\n\n"+
+				"Terraform does not automatically rollback in the face of errors.\n"+
+				"Instead, your Terraform state in Atlas has been partially updated with\n"+
+				"any resources that successfully completed. Please address the error\n"+
+				"above and apply again to incrementally change your infrastructure.",
+			multierror.Flatten(applyErr))
+		return
+	}
+
+	// If we have a UI, output the results
+	if b.CLI != nil {
+		if op.Destroy {
+			b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+				"[reset][bold][green]\n"+
+					"Destroy complete! Resources: %d destroyed.",
+				countHook.Removed)))
+		} else {
+			b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+				"[reset][bold][green]\n"+
+					"Apply complete! Resources: %d added, %d changed, %d destroyed.",
+				countHook.Added,
+				countHook.Changed,
+				countHook.Removed)))
+		}
+	}
+}
+
+// persistError returns the error of failing to persist the state s as an
+// *ErrStatePersistFailed, so that it says how to recover. Errors that
+// already say so are returned as they are.
+func persistError(err error, s state.State) error {
+	switch err := err.(type) {
+	case *ErrStatePersistFailed, *ErrStateSerialConflict, *ErrStateLineageMismatch,
+		*ErrEnvironmentArchived:
+		return err
+	}
+	if err == ErrUnauthorized || err == ErrForbidden {
+		return err
+	}
+
+	var serial int64
+	if current := s.State(); current != nil {
+		serial = current.Serial
+	}
+
+	return &ErrStatePersistFailed{Err: err, Serial: serial}
+}
+
+// abortApply gives up on an apply that is still stopping. The apply keeps
+// running in the background, so the state hook is detached first; what it
+// last persisted, which covers every completed resource, is saved again and
+// becomes the final state.
+func (b *Backend) abortApply(h *stateHook, s state.State) error {
+	h.Lock()
+	defer h.Unlock()
+	h.State = nil
+
+	if b.CLI != nil {
+		b.CLI.Output("Aborting the apply. Resources still in progress may be left partially created.")
+	}
+
+	if err := s.PersistState(); err != nil {
+		return persistError(err, s)
+	}
+
+	return errApplyAborted
+}
+
+// confirmApply asks the user to confirm the given numbers of changes before
+// they're applied. Only "yes" confirms them.
+func (b *Backend) confirmApply(op *backend.Operation, add, change, destroy int) error {
+	if !b.OpInput || op.UIIn == nil {
+		return errors.New(strings.TrimSpace(applyErrNoInput))
+	}
+
+	colorize := b.Colorize()
+	desc := colorize.Color(fmt.Sprintf(
+		"[reset][bold]Plan:[reset] "+
+			"%d to add, %d to change, %d to destroy.\n\n"+
+			"Terraform will perform these actions in the environment %s.\n"+
+			"Only 'yes' will be accepted to approve.",
+		add, change, destroy,
+		b.stateClient.User+"/"+b.stateClient.Name))
+
+	v, err := op.UIIn.Input(&terraform.InputOpts{
+		Id:          "approve",
+		Query:       colorize.Color("[reset][bold]Do you want to perform these actions?"),
+		Description: desc,
+	})
+	if err != nil {
+		return fmt.Errorf("Error asking for approval: %s", err)
+	}
+	if v != "yes" {
+		return errors.New("Apply cancelled.")
+	}
+
+	return nil
+}
+
+const applyErrNoConfig = `
+No configuration files found!
+
+Apply requires configuration to be present. Applying without a configuration
+would mark everything for destruction, which is normally not what is desired.
+If you would like to destroy everything, please run 'terraform destroy' instead
+which does not require any configuration files.
+`
+
+const applyErrNoInput = `
+Applying to Atlas needs to be approved, but input is disabled.
+
+Terraform asks for approval before applying changes to an Atlas environment.
+To apply without approval, for example in automation, run 'terraform apply'
+with the -auto-approve flag.
+`
+
+// errApplyAborted is the error of an apply that was forced to abort.
+var errApplyAborted = errors.New(
+	"The apply was aborted before it completed. The state in Atlas tracks the\n" +
+		"resources that completed. Resources that were still being changed may\n" +
+		"need to be checked by hand before applying again.")
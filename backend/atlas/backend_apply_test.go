@@ -0,0 +1,595 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_applyBasic(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if p.RefreshCalled {
+		t.Fatal("refresh should not be called")
+	}
+
+	if !p.DiffCalled {
+		t.Fatal("diff should be called")
+	}
+
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+
+	if fakeAtlas.puts == 0 {
+		t.Fatal("state should be written to Atlas")
+	}
+
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = yes
+	`)
+}
+
+func TestBackend_applyEmptyDir(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+	op := testOperationApply()
+	op.Module = nil
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err == nil {
+		t.Fatal("should error")
+	}
+
+	if p.ApplyCalled {
+		t.Fatal("apply should not be called")
+	}
+
+	if fakeAtlas.puts != 0 {
+		t.Fatal("state should not be written")
+	}
+}
+
+func TestBackend_applyNoConfig(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	testProvider(t, b, "test")
+
+	op := testOperationApply()
+	op.Module = nil
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+
+	err = run.Err
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), "configuration") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestBackend_applyError(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	var lock sync.Mutex
+	errored := false
+	p.ApplyFn = func(
+		info *terraform.InstanceInfo,
+		s *terraform.InstanceState,
+		d *terraform.InstanceDiff) (*terraform.InstanceState, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if !errored && info.Id == "test_instance.bar" {
+			errored = true
+			return nil, fmt.Errorf("error")
+		}
+
+		return &terraform.InstanceState{ID: "foo"}, nil
+	}
+	p.DiffFn = func(
+		*terraform.InstanceInfo,
+		*terraform.InstanceState,
+		*terraform.ResourceConfig) (*terraform.InstanceDiff, error) {
+		return &terraform.InstanceDiff{
+			Attributes: map[string]*terraform.ResourceAttrDiff{
+				"ami": &terraform.ResourceAttrDiff{
+					New: "bar",
+				},
+			},
+		}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply-error")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err == nil {
+		t.Fatal("should error")
+	}
+
+	// The partial state must still be persisted
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = foo
+	`)
+}
+
+func TestBackend_applyPersistFailed(t *testing.T) {
+	// Reads work, but the state can't be written
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == "PUT" {
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		fakeAtlas.handler(resp, req)
+	}))
+	defer srv.Close()
+
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"backup_dir":   dir,
+	})
+	b.ContextOpts = &terraform.ContextOpts{}
+	b.stateClient.Clock = new(fakeClock)
+	p := testProvider(t, b, "test")
+	p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+
+	persistErr, ok := run.Err.(*ErrStatePersistFailed)
+	if !ok {
+		t.Fatalf("expected *ErrStatePersistFailed, got: %#v", run.Err)
+	}
+	if persistErr.BackupPath == "" || !strings.HasPrefix(persistErr.BackupPath, dir) {
+		t.Fatalf("bad backup path: %q", persistErr.BackupPath)
+	}
+
+	// The backup is the state with the applied resource, and the error
+	// says how to push it.
+	backup, err := os.Open(persistErr.BackupPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer backup.Close()
+	s, err := terraform.ReadState(backup)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if s.Serial != persistErr.Serial || s.RootModule().Resources["test_instance.foo"] == nil {
+		t.Fatalf("bad backup:\n\n%s", s)
+	}
+	if !strings.Contains(run.Err.Error(), "terraform state push "+persistErr.BackupPath) {
+		t.Fatalf("expected recovery instructions, got: %s", run.Err)
+	}
+}
+
+func TestBackend_applyLock(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.ApplyFn = func(
+		info *terraform.InstanceInfo,
+		s *terraform.InstanceState,
+		d *terraform.InstanceDiff) (*terraform.InstanceState, error) {
+		if fakeAtlas.lock == nil {
+			t.Error("state should be locked during apply")
+		}
+
+		return &terraform.InstanceState{ID: "yes"}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+	op.LockState = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+	if fakeAtlas.lock != nil {
+		t.Fatal("state should be unlocked after apply")
+	}
+}
+
+func TestBackend_applyStop(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	startedCh := make(chan struct{})
+	stopCh := make(chan struct{})
+	p.StopFn = func() error {
+		close(stopCh)
+		return nil
+	}
+	p.ApplyFn = func(
+		info *terraform.InstanceInfo,
+		s *terraform.InstanceState,
+		d *terraform.InstanceDiff) (*terraform.InstanceState, error) {
+		switch info.Id {
+		case "test_instance.bar":
+			close(startedCh)
+			<-stopCh
+		case "test_instance.baz":
+			t.Error("baz should not be applied after the interrupt")
+		}
+
+		return &terraform.InstanceState{ID: info.Id}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply-stop")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run, err := b.Operation(ctx, op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// Interrupt while bar is being created. It's allowed to complete, but
+	// nothing after it is started.
+	<-startedCh
+	cancel()
+	<-run.Done()
+
+	if !p.StopCalled {
+		t.Fatal("provider should be stopped")
+	}
+
+	checkState(t, fakeAtlas, `
+test_instance.bar:
+  ID = test_instance.bar
+
+  Dependencies:
+    test_instance.foo
+test_instance.foo:
+  ID = test_instance.foo
+	`)
+}
+
+func TestBackend_applyStopDuringPlan(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	ui := &interruptUi{MockUi: new(cli.MockUi), interrupted: make(chan struct{})}
+	b.CLI = ui
+	p := testProvider(t, b, "test")
+
+	startedCh := make(chan struct{})
+	releaseCh := make(chan struct{})
+	p.DiffFn = func(
+		info *terraform.InstanceInfo,
+		s *terraform.InstanceState,
+		c *terraform.ResourceConfig) (*terraform.InstanceDiff, error) {
+		close(startedCh)
+		<-releaseCh
+
+		return &terraform.InstanceDiff{
+			Attributes: map[string]*terraform.ResourceAttrDiff{
+				"ami": &terraform.ResourceAttrDiff{New: "bar"},
+			},
+		}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run, err := b.Operation(ctx, op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// Interrupt while planning. The plan is stopped, and nothing is applied.
+	<-startedCh
+	cancel()
+	select {
+	case <-ui.interrupted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("apply didn't notice its context was cancelled during the plan")
+	}
+	close(releaseCh)
+	<-run.Done()
+
+	if run.Err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", run.Err)
+	}
+	if p.ApplyCalled {
+		t.Fatal("nothing should be applied after an interrupted plan")
+	}
+}
+
+func TestBackend_applyForceStop(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	// bar never completes on its own
+	startedCh := make(chan struct{})
+	releaseCh := make(chan struct{})
+	defer close(releaseCh)
+	p.ApplyFn = func(
+		info *terraform.InstanceInfo,
+		s *terraform.InstanceState,
+		d *terraform.InstanceDiff) (*terraform.InstanceState, error) {
+		if info.Id == "test_instance.bar" {
+			close(startedCh)
+			<-releaseCh
+		}
+
+		return &terraform.InstanceState{ID: info.Id}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply-stop")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run, err := b.Operation(ctx, op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	<-startedCh
+	cancel()
+	run.Cancel()
+	<-run.Done()
+
+	if run.Err != errApplyAborted {
+		t.Fatalf("expected the apply to be aborted, got: %v", run.Err)
+	}
+
+	// What completed before the abort must be persisted
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = test_instance.foo
+	`)
+}
+
+func TestBackend_applyOperationProviders(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	// The backend has no providers of its own
+	b := testBackend(t, srv)
+	p := new(terraform.MockResourceProvider)
+	p.DiffReturn = &terraform.InstanceDiff{}
+	p.ResourcesReturn = []terraform.ResourceType{{Name: "test_instance"}}
+	p.ApplyReturn = &terraform.InstanceState{ID: "mock"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+	op.Providers = map[string]terraform.ResourceProviderFactory{
+		"test": terraform.ResourceProviderFactoryFixed(p),
+	}
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = mock
+	`)
+}
+
+func TestBackend_applyApproval(t *testing.T) {
+	cases := map[string]struct {
+		Input   string
+		Applied bool
+	}{
+		"approved": {"yes", true},
+		"rejected": {"no", false},
+		"y":        {"y", false},
+	}
+
+	for name, tc := range cases {
+		fakeAtlas := newFakeAtlas(t, nil)
+		srv := fakeAtlas.Server()
+
+		b := testBackend(t, srv)
+		b.OpInput = true
+		p := testProvider(t, b, "test")
+		p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+		mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+
+		input := &terraform.MockUIInput{
+			InputReturnMap: map[string]string{"approve": tc.Input},
+		}
+		op := testOperationApply()
+		op.Module = mod
+		op.AutoApprove = false
+		op.UIIn = input
+
+		run, err := b.Operation(context.Background(), op)
+		if err != nil {
+			t.Fatalf("%s: bad: %s", name, err)
+		}
+		<-run.Done()
+		modCleanup()
+		srv.Close()
+
+		if !input.InputCalled {
+			t.Fatalf("%s: approval should be asked for", name)
+		}
+		if p.ApplyCalled != tc.Applied {
+			t.Fatalf("%s: expected applied to be %t", name, tc.Applied)
+		}
+		if tc.Applied && run.Err != nil {
+			t.Fatalf("%s: err: %s", name, run.Err)
+		}
+		if !tc.Applied && (run.Err == nil || !strings.Contains(run.Err.Error(), "cancelled")) {
+			t.Fatalf("%s: expected cancelled error, got: %v", name, run.Err)
+		}
+		if !strings.Contains(input.InputOpts.Description, "1 to add, 0 to change, 0 to destroy") {
+			t.Fatalf("%s: bad description: %s", name, input.InputOpts.Description)
+		}
+	}
+}
+
+func TestBackend_applyApprovalNoInput(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+	op.AutoApprove = false
+	op.UIIn = new(terraform.MockUIInput)
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err == nil || !strings.Contains(run.Err.Error(), "-auto-approve") {
+		t.Fatalf("expected approval error, got: %v", run.Err)
+	}
+	if p.ApplyCalled {
+		t.Fatal("apply should not be called")
+	}
+}
+
+func testOperationApply() *backend.Operation {
+	return &backend.Operation{
+		Type:        backend.OperationTypeApply,
+		Environment: backend.DefaultStateName,
+		AutoApprove: true,
+	}
+}
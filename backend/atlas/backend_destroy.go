@@ -0,0 +1,101 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/terraform/backend"
+)
+
+// opDestroy destroys all the resources tracked in the Atlas state. This is
+// a full plan and apply cycle with destroy enabled, but an environment that
+// has nothing to destroy is treated as a successful no-op.
+func (b *Backend) opDestroy(
+	ctx context.Context,
+	op *backend.Operation,
+	runningOp *backend.RunningOperation) {
+	log.Printf("[INFO] backend/atlas: starting Destroy operation")
+
+	if err := b.checkDestroyProtection(); err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	op.Destroy = true
+
+	name := op.Environment
+	if name == "" {
+		name = backend.DefaultStateName
+	}
+	s, err := b.State(name)
+	if err != nil {
+		runningOp.Err = errwrap.Wrapf("Error loading state: {{err}}", err)
+		return
+	}
+	if err := s.RefreshState(); err != nil {
+		runningOp.Err = errwrap.Wrapf("Error loading state: {{err}}", err)
+		return
+	}
+
+	if current := s.State(); current.Empty() || !current.HasResources() {
+		runningOp.State = current
+		if b.CLI != nil {
+			b.CLI.Output(b.Colorize().Color(strings.TrimSpace(destroyNoState)))
+		}
+		return
+	}
+
+	if b.CLI != nil {
+		b.CLI.Output(b.Colorize().Color(strings.TrimSpace(destroyHeader) + "\n"))
+	}
+
+	b.opApply(ctx, op, runningOp)
+}
+
+// ErrDestroyLocked is returned when destroying an environment that Atlas
+// protects from destroys, unless confirm_destroy is set to its name.
+type ErrDestroyLocked struct {
+	Environment string
+}
+
+func (e *ErrDestroyLocked) Error() string {
+	return fmt.Sprintf(
+		"The Atlas environment %s is protected from destroys. To destroy it\n"+
+			"anyway, set confirm_destroy to %q.",
+		e.Environment, e.Environment)
+}
+
+// checkDestroyProtection refuses to destroy an environment that's protected
+// from destroys, unless confirm_destroy is set to the environment's name.
+// If whether it's protected can't be read, the destroy is refused too.
+func (b *Backend) checkDestroyProtection() error {
+	if b.confirmDestroy == b.name {
+		return nil
+	}
+
+	env, err := b.stateClient.getEnvironment()
+	if err != nil {
+		return fmt.Errorf(
+			"Error checking whether the environment is protected from destroys: %s\n\n"+
+				"Set confirm_destroy to %q to destroy it without checking.", err, b.name)
+	}
+	if env.DestroyLocked {
+		return &ErrDestroyLocked{Environment: b.name}
+	}
+
+	return nil
+}
+
+const destroyHeader = `
+[reset][bold][red]Destroying all resources tracked in the Atlas environment...[reset]
+`
+
+const destroyNoState = `
+[reset][bold][green]Nothing to destroy.[reset][green]
+
+The state in Atlas doesn't track any resources, so there is nothing for
+Terraform to destroy.
+`
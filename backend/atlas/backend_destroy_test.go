@@ -0,0 +1,166 @@
+package atlas
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_destroy(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testPlanState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	ui := new(cli.MockUi)
+	b.CLI = ui
+	p := testProvider(t, b, "test")
+	p.ApplyReturn = nil
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+	op.Destroy = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Destroy complete! Resources: 1 destroyed.") {
+		t.Fatalf("bad output: %s", ui.OutputWriter.String())
+	}
+
+	checkState(t, fakeAtlas, `<no state>`)
+}
+
+func TestBackend_destroyEmpty(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	ui := new(cli.MockUi)
+	b.CLI = ui
+	p := testProvider(t, b, "test")
+
+	op := testOperationApply()
+	op.Module = nil
+	op.Destroy = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if p.ApplyCalled {
+		t.Fatal("apply should not be called")
+	}
+	if fakeAtlas.puts != 0 {
+		t.Fatal("state should not be written")
+	}
+	if !strings.Contains(ui.OutputWriter.String(), "Nothing to destroy") {
+		t.Fatalf("bad output: %s", ui.OutputWriter.String())
+	}
+}
+
+func TestBackend_destroyLocked(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testPlanState()))
+	fakeAtlas.destroyLocked = true
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.CLI = new(cli.MockUi)
+	p := testProvider(t, b, "test")
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+	op.Destroy = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	locked, ok := run.Err.(*ErrDestroyLocked)
+	if !ok {
+		t.Fatalf("expected *ErrDestroyLocked, got: %#v", run.Err)
+	}
+	if locked.Environment != "someuser/some-test-remote-state" {
+		t.Fatalf("bad: %s", locked.Environment)
+	}
+
+	if p.ApplyCalled {
+		t.Fatal("apply should not be called")
+	}
+	if fakeAtlas.puts != 0 {
+		t.Fatalf("the state shouldn't be written, got %d puts", fakeAtlas.puts)
+	}
+}
+
+func TestBackend_destroyLockedConfirmed(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testPlanState()))
+	fakeAtlas.destroyLocked = true
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.CLI = new(cli.MockUi)
+	b.confirmDestroy = "someuser/some-test-remote-state"
+	p := testProvider(t, b, "test")
+	p.ApplyReturn = nil
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+	op.Destroy = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+	checkState(t, fakeAtlas, `<no state>`)
+}
+
+func TestBackend_destroyLockedWrongConfirm(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testPlanState()))
+	fakeAtlas.destroyLocked = true
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.confirmDestroy = "someuser/other"
+	if _, ok := b.checkDestroyProtection().(*ErrDestroyLocked); !ok {
+		t.Fatal("a confirm_destroy for another environment should be refused")
+	}
+}
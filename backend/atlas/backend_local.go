@@ -0,0 +1,179 @@
+package atlas
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// backend.Local implementation.
+func (b *Backend) Context(op *backend.Operation) (*terraform.Context, state.State, error) {
+	// Make sure the type is invalid. We use this as a way to know not
+	// to ask for input/validate.
+	op.Type = backend.OperationTypeInvalid
+
+	return b.context(op)
+}
+
+func (b *Backend) context(op *backend.Operation) (*terraform.Context, state.State, error) {
+	// Get the state.
+	name := op.Environment
+	if name == "" {
+		name = backend.DefaultStateName
+	}
+	s, err := b.State(name)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("Error loading state: {{err}}", err)
+	}
+
+	if err := s.RefreshState(); err != nil {
+		return nil, nil, errwrap.Wrapf("Error loading state: {{err}}", err)
+	}
+
+	// Initialize our context options, with the state we just loaded
+	opts := b.contextOpts(op)
+	opts.State = s.State()
+
+	// Build the context
+	var tfCtx *terraform.Context
+	if op.Plan != nil {
+		tfCtx, err = op.Plan.Context(opts)
+	} else {
+		tfCtx, err = terraform.NewContext(opts)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// If we have an operation, then we automatically do the input/validate
+	// here since every option requires this.
+	if op.Type != backend.OperationTypeInvalid {
+		// If input asking is enabled, then do that
+		if op.Plan == nil && b.OpInput {
+			mode := terraform.InputModeProvider
+			mode |= terraform.InputModeVar
+			mode |= terraform.InputModeVarUnset
+
+			if err := tfCtx.Input(mode); err != nil {
+				return nil, nil, errwrap.Wrapf("Error asking for user input: {{err}}", err)
+			}
+		}
+
+		// If validation is enabled, validate
+		if b.OpValidation {
+			ws, es := tfCtx.Validate()
+			if len(ws) > 0 {
+				// Log just in case the CLI isn't enabled
+				log.Printf("[WARN] backend/atlas: %d warnings: %v", len(ws), ws)
+
+				// If we have a CLI, output the warnings
+				if b.CLI != nil {
+					b.CLI.Warn(strings.TrimSpace(validateWarnHeader) + "\n")
+					for _, w := range ws {
+						b.CLI.Warn(fmt.Sprintf("  * %s", w))
+					}
+
+					// Make a newline before continuing
+					b.CLI.Output("")
+				}
+			}
+
+			if len(es) > 0 {
+				return nil, nil, multierror.Append(nil, es...)
+			}
+		}
+	}
+
+	return tfCtx, s, nil
+}
+
+// contextOpts returns the options for the context of an operation, with
+// the settings of the backend applied.
+func (b *Backend) contextOpts(op *backend.Operation) *terraform.ContextOpts {
+	opts := mergeContextOpts(b.ContextOpts, op)
+	if b.parallelism > 0 {
+		opts.Parallelism = b.parallelism
+	}
+
+	return opts
+}
+
+// mergeContextOpts returns the options for the context of an operation: the
+// base options of the backend with the values of the operation merged in.
+// Neither base nor op is modified. The rules are:
+//
+//   * Module, Destroy and Targets are always taken from the operation,
+//     since they describe what the operation does.
+//   * Variables are merged, with the operation's value winning for any
+//     variable set in both.
+//   * UIInput is the operation's UIIn if it's set, and the base's if not.
+//   * Providers are the base's, with the operation's Providers added.
+//     A provider of the operation overrides a base provider of the same
+//     name.
+//   * Provisioners and Hooks are copied from the base so that the
+//     operation can't modify the backend's.
+//   * State is copied from the base. The operation functions replace it
+//     with the state of the operation's environment.
+//   * Everything else is copied from the base as it is.
+func mergeContextOpts(base *terraform.ContextOpts, op *backend.Operation) *terraform.ContextOpts {
+	var opts terraform.ContextOpts
+	if base != nil {
+		opts = *base
+	}
+
+	opts.Module = op.Module
+	opts.Destroy = op.Destroy
+	opts.Targets = op.Targets
+
+	if len(opts.Variables) > 0 || len(op.Variables) > 0 {
+		vars := make(map[string]interface{}, len(opts.Variables)+len(op.Variables))
+		for k, v := range opts.Variables {
+			vars[k] = v
+		}
+		for k, v := range op.Variables {
+			vars[k] = v
+		}
+		opts.Variables = vars
+	}
+
+	if op.UIIn != nil {
+		opts.UIInput = op.UIIn
+	}
+
+	if opts.Providers != nil || op.Providers != nil {
+		providers := make(map[string]terraform.ResourceProviderFactory, len(opts.Providers)+len(op.Providers))
+		for k, v := range opts.Providers {
+			providers[k] = v
+		}
+		for k, v := range op.Providers {
+			providers[k] = v
+		}
+		opts.Providers = providers
+	}
+	if opts.Provisioners != nil {
+		provisioners := make(map[string]terraform.ResourceProvisionerFactory, len(opts.Provisioners))
+		for k, v := range opts.Provisioners {
+			provisioners[k] = v
+		}
+		opts.Provisioners = provisioners
+	}
+	if opts.Hooks != nil {
+		opts.Hooks = append([]terraform.Hook(nil), opts.Hooks...)
+	}
+
+	return &opts
+}
+
+const validateWarnHeader = `
+There are warnings related to your configuration. If no errors occurred,
+Terraform will continue despite these warnings. It is a good idea to resolve
+these warnings in the near future.
+
+Warnings:
+`
@@ -0,0 +1,190 @@
+package atlas
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestMergeContextOpts(t *testing.T) {
+	baseInput := new(terraform.MockUIInput)
+	opInput := new(terraform.MockUIInput)
+	baseState := terraform.NewState()
+	provider := func() (terraform.ResourceProvider, error) {
+		return new(terraform.MockResourceProvider), nil
+	}
+	hook := new(terraform.MockHook)
+
+	base := &terraform.ContextOpts{
+		Destroy:     true,
+		Parallelism: 5,
+		State:       baseState,
+		Targets:     []string{"test_instance.base"},
+		Variables: map[string]interface{}{
+			"region": "us-east-1",
+			"size":   "small",
+		},
+		Providers: map[string]terraform.ResourceProviderFactory{"test": provider},
+		Hooks:     []terraform.Hook{hook},
+		UIInput:   baseInput,
+	}
+	mod := module.NewEmptyTree()
+	op := &backend.Operation{
+		Module:    mod,
+		Targets:   []string{"test_instance.op"},
+		Variables: map[string]interface{}{"size": "large"},
+		UIIn:      opInput,
+	}
+
+	opts := mergeContextOpts(base, op)
+
+	if opts.Module != mod {
+		t.Fatal("module should come from the operation")
+	}
+	if opts.Destroy {
+		t.Fatal("destroy should come from the operation")
+	}
+	if !reflect.DeepEqual(opts.Targets, []string{"test_instance.op"}) {
+		t.Fatalf("bad targets: %#v", opts.Targets)
+	}
+	expectedVars := map[string]interface{}{"region": "us-east-1", "size": "large"}
+	if !reflect.DeepEqual(opts.Variables, expectedVars) {
+		t.Fatalf("bad variables: %#v", opts.Variables)
+	}
+	if opts.UIInput != opInput {
+		t.Fatal("UIInput should come from the operation")
+	}
+	if opts.State != baseState || opts.Parallelism != 5 {
+		t.Fatalf("bad: %#v", opts)
+	}
+	if len(opts.Providers) != 1 || opts.Providers["test"] == nil {
+		t.Fatalf("bad providers: %#v", opts.Providers)
+	}
+	if len(opts.Hooks) != 1 || opts.Hooks[0] != hook {
+		t.Fatalf("bad hooks: %#v", opts.Hooks)
+	}
+
+	// The base must be untouched by changes to the merged options
+	opts.Variables["region"] = "eu-west-1"
+	opts.Providers["other"] = provider
+	opts.Hooks = append(opts.Hooks, new(terraform.MockHook))
+	if base.Variables["region"] != "us-east-1" || base.Variables["size"] != "small" {
+		t.Fatalf("base variables modified: %#v", base.Variables)
+	}
+	if len(base.Providers) != 1 || len(base.Hooks) != 1 {
+		t.Fatal("base modified")
+	}
+	if !base.Destroy || base.UIInput != baseInput {
+		t.Fatal("base modified")
+	}
+}
+
+func TestMergeContextOpts_providers(t *testing.T) {
+	baseProvider := new(terraform.MockResourceProvider)
+	opProvider := new(terraform.MockResourceProvider)
+	base := &terraform.ContextOpts{
+		Providers: map[string]terraform.ResourceProviderFactory{
+			"aws":  terraform.ResourceProviderFactoryFixed(baseProvider),
+			"test": terraform.ResourceProviderFactoryFixed(baseProvider),
+		},
+	}
+	op := &backend.Operation{
+		Providers: map[string]terraform.ResourceProviderFactory{
+			"test": terraform.ResourceProviderFactoryFixed(opProvider),
+		},
+	}
+
+	opts := mergeContextOpts(base, op)
+	expected := map[string]terraform.ResourceProvider{
+		"aws":  baseProvider,
+		"test": opProvider,
+	}
+	for name, e := range expected {
+		p, err := opts.Providers[name]()
+		if err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+		if p != e {
+			t.Fatalf("%s: wrong provider", name)
+		}
+	}
+	if len(base.Providers) != 2 {
+		t.Fatal("base modified")
+	}
+}
+
+func TestMergeContextOpts_nil(t *testing.T) {
+	input := new(terraform.MockUIInput)
+	op := &backend.Operation{Destroy: true}
+
+	opts := mergeContextOpts(nil, op)
+	if !opts.Destroy || opts.Variables != nil || opts.UIInput != nil {
+		t.Fatalf("bad: %#v", opts)
+	}
+
+	// The base UIInput is kept if the operation has none
+	opts = mergeContextOpts(&terraform.ContextOpts{UIInput: input}, op)
+	if opts.UIInput != input {
+		t.Fatal("UIInput should come from the base")
+	}
+}
+
+func TestBackend_contextOptsParallelism(t *testing.T) {
+	cases := map[string]struct {
+		Config   map[string]interface{}
+		Base     int
+		Expected int
+	}{
+		"configured": {map[string]interface{}{"parallelism": 3}, 10, 3},
+		"default":    {map[string]interface{}{}, 7, 7},
+	}
+
+	for name, tc := range cases {
+		conf := map[string]interface{}{
+			"access_token": "sometoken",
+			"name":         "someuser/some-test-remote-state",
+		}
+		for k, v := range tc.Config {
+			conf[k] = v
+		}
+		b := &Backend{}
+		backend.TestBackendConfig(t, b, conf)
+		b.ContextOpts = &terraform.ContextOpts{Parallelism: tc.Base}
+
+		op := testOperationPlan()
+		if actual := b.contextOpts(op).Parallelism; actual != tc.Expected {
+			t.Fatalf("%s: expected %d, got %d", name, tc.Expected, actual)
+		}
+		if actual := b.runRequest(op).Parallelism; actual != tc.Expected {
+			t.Fatalf("%s: expected %d sent to Atlas, got %d", name, tc.Expected, actual)
+		}
+	}
+}
+
+func TestValidate_parallelism(t *testing.T) {
+	cases := []struct {
+		Value int
+		Err   bool
+	}{
+		{1, false},
+		{10, false},
+		{0, true},
+		{-1, true},
+	}
+
+	for _, tc := range cases {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         "foo/bar",
+			"parallelism":  tc.Value,
+		})))
+		if (len(errs) > 0) != tc.Err {
+			t.Fatalf("%d: bad: %v", tc.Value, errs)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// outputOperation starts an output operation. It only reads the state, so
+// unlike the other operations it doesn't take the operation lock or lock
+// the state, and it can run alongside them.
+func (b *Backend) outputOperation(ctx context.Context, op *backend.Operation) *backend.RunningOperation {
+	runningCtx, runningCtxCancel := context.WithCancel(context.Background())
+	runningOp := &backend.RunningOperation{Context: runningCtx}
+
+	go func() {
+		defer runningCtxCancel()
+		b.observer().OperationStarted(op.Type)
+		b.opOutput(ctx, op, runningOp)
+		b.observer().OperationFinished(op.Type, runningOp.Err)
+	}()
+
+	return runningOp
+}
+
+func (b *Backend) opOutput(
+	ctx context.Context,
+	op *backend.Operation,
+	runningOp *backend.RunningOperation) {
+	log.Printf("[INFO] backend/atlas: starting Output operation")
+
+	name := op.Environment
+	if name == "" {
+		name = backend.DefaultStateName
+	}
+	s, err := b.State(name)
+	if err != nil {
+		runningOp.Err = errwrap.Wrapf("Error loading state: {{err}}", err)
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		runningOp.Err = ctx.Err()
+		return
+	default:
+	}
+
+	if err := s.RefreshState(); err != nil {
+		runningOp.Err = errwrap.Wrapf("Error loading state: {{err}}", err)
+		return
+	}
+
+	// With no state there are no outputs, which isn't an error
+	runningOp.State = s.State()
+	runningOp.Outputs = make(map[string]*terraform.OutputState)
+	if runningOp.State == nil {
+		return
+	}
+	if root := runningOp.State.ModuleByPath(terraform.RootModulePath); root != nil {
+		for k, v := range root.Outputs {
+			runningOp.Outputs[k] = v
+		}
+	}
+}
+
+// batchFetchWorkers is how many environments BatchFetchOutputs reads at
+// once.
+const batchFetchWorkers = 4
+
+// ErrBatchFetchOutputs is returned by BatchFetchOutputs when the outputs of
+// some of the environments couldn't be read.
+type ErrBatchFetchOutputs struct {
+	// Errors are the reasons each environment's outputs couldn't be read,
+	// by environment name.
+	Errors map[string]error
+}
+
+func (e *ErrBatchFetchOutputs) Error() string {
+	envs := make([]string, 0, len(e.Errors))
+	for env := range e.Errors {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Failed to read the outputs of %d environments:", len(envs))
+	for _, env := range envs {
+		fmt.Fprintf(&buf, "\n\n  %s: %s", env, e.Errors[env])
+	}
+
+	return buf.String()
+}
+
+// BatchFetchOutputs reads the root module outputs of several environments
+// in the configured organization, a few at a time, returning them by
+// environment name. Like FetchState, it doesn't take any locks. An
+// environment without a state has no outputs.
+//
+// If some environments can't be read, the outputs of the others are still
+// returned, along with an *ErrBatchFetchOutputs for the ones that failed.
+func (b *Backend) BatchFetchOutputs(
+	ctx context.Context, envs []string) (map[string]map[string]*terraform.OutputState, error) {
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	var (
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		result  = make(map[string]map[string]*terraform.OutputState)
+		errs    = make(map[string]error)
+		queue   = make(chan string)
+		workers = batchFetchWorkers
+	)
+	if len(envs) < workers {
+		workers = len(envs)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for env := range queue {
+				outputs, err := b.fetchOutputs(ctx, env)
+
+				lock.Lock()
+				if err != nil {
+					errs[env] = err
+				} else {
+					result[env] = outputs
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool)
+	for _, env := range envs {
+		if !seen[env] {
+			seen[env] = true
+			queue <- env
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return result, &ErrBatchFetchOutputs{Errors: errs}
+	}
+
+	return result, nil
+}
+
+// fetchOutputs reads the root module outputs of an environment in the
+// configured organization.
+func (b *Backend) fetchOutputs(ctx context.Context, env string) (map[string]*terraform.OutputState, error) {
+	if _, _, err := parseName(b.stateClient.User + "/" + env); err != nil {
+		return nil, fmt.Errorf("invalid environment name %q", env)
+	}
+
+	s, err := b.stateClient.forEnvironment(env).fetchState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make(map[string]*terraform.OutputState)
+	if s == nil {
+		return outputs, nil
+	}
+	if root := s.ModuleByPath(terraform.RootModulePath); root != nil {
+		for k, v := range root.Outputs {
+			outputs[k] = v
+		}
+	}
+
+	return outputs, nil
+}
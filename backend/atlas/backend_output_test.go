@@ -0,0 +1,131 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestBackend_output(t *testing.T) {
+	s := terraform.NewState()
+	s.Lineage = "lineage"
+	s.RootModule().Outputs = map[string]*terraform.OutputState{
+		"address": &terraform.OutputState{Type: "string", Value: "10.0.0.1"},
+		"password": &terraform.OutputState{
+			Type:      "string",
+			Value:     "hunter2",
+			Sensitive: true,
+		},
+	}
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, s))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+
+	// Outputs are read even while another operation is running, and
+	// without locking the state
+	b.opLock.Lock()
+	b.opRunning = true
+	b.opLock.Unlock()
+
+	run, err := b.Operation(context.Background(), testOperationOutput())
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+	if fakeAtlas.lock != nil {
+		t.Fatal("the state shouldn't be locked")
+	}
+
+	if len(run.Outputs) != 2 {
+		t.Fatalf("bad: %#v", run.Outputs)
+	}
+	if v := run.Outputs["address"]; v.Value != "10.0.0.1" || v.Sensitive {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v := run.Outputs["password"]; v.Value != "hunter2" || !v.Sensitive {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestBackend_outputNoState(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	run, err := b.Operation(context.Background(), testOperationOutput())
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+	if run.Outputs == nil || len(run.Outputs) != 0 {
+		t.Fatalf("bad: %#v", run.Outputs)
+	}
+}
+
+func testOperationOutput() *backend.Operation {
+	return &backend.Operation{
+		Type:        backend.OperationTypeOutput,
+		Environment: backend.DefaultStateName,
+	}
+}
+
+func TestBackend_BatchFetchOutputs(t *testing.T) {
+	s := terraform.NewState()
+	s.RootModule().Outputs = map[string]*terraform.OutputState{
+		"address": &terraform.OutputState{Type: "string", Value: "10.0.0.1"},
+	}
+	state := testStateBytes(t, s)
+
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v1/terraform/state/someuser/network":
+			resp.Write(state)
+		case "/api/v1/terraform/state/someuser/empty":
+			resp.WriteHeader(http.StatusNotFound)
+		default:
+			resp.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	outputs, err := b.BatchFetchOutputs(context.Background(), []string{"network", "secret", "empty"})
+	batchErr, ok := err.(*ErrBatchFetchOutputs)
+	if !ok {
+		t.Fatalf("expected *ErrBatchFetchOutputs, got: %#v", err)
+	}
+	if len(batchErr.Errors) != 1 || batchErr.Errors["secret"] != ErrForbidden {
+		t.Fatalf("bad: %#v", batchErr.Errors)
+	}
+
+	// The environments that could be read are still returned
+	if len(outputs) != 2 {
+		t.Fatalf("bad: %#v", outputs)
+	}
+	if v := outputs["network"]["address"]; v == nil || v.Value != "10.0.0.1" {
+		t.Fatalf("bad: %#v", outputs["network"])
+	}
+	if len(outputs["empty"]) != 0 {
+		t.Fatalf("bad: %#v", outputs["empty"])
+	}
+}
+
+func TestBackend_BatchFetchOutputsNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if _, err := b.BatchFetchOutputs(context.Background(), []string{"foo"}); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package atlas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform/backend"
+	backendlocal "github.com/hashicorp/terraform/backend/local"
+	"github.com/hashicorp/terraform/command/clistate"
+	"github.com/hashicorp/terraform/command/format"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func (b *Backend) opPlan(
+	ctx context.Context,
+	op *backend.Operation,
+	runningOp *backend.RunningOperation) {
+	log.Printf("[INFO] backend/atlas: starting Plan operation")
+
+	if b.CLI != nil && op.Plan != nil {
+		b.CLI.Output(b.Colorize().Color(
+			"[reset][bold][yellow]" +
+				"The plan command received a saved plan file as input. This command\n" +
+				"will output the saved plan. This will not modify the already-existing\n" +
+				"plan. If you wish to generate a new plan, please pass in a configuration\n" +
+				"directory as an argument.\n\n"))
+	}
+
+	// In the local-apply execution mode, Atlas makes the plan
+	if b.executionMode == executionModeLocalApply && op.Plan == nil {
+		b.opPlanRemote(ctx, op, runningOp)
+		return
+	}
+
+	// A plan requires either a plan or a module
+	if op.Plan == nil && op.Module == nil && !op.Destroy {
+		runningOp.Err = errors.New(strings.TrimSpace(planErrNoConfig))
+		return
+	}
+
+	// If we have a nil module at this point, then set it to an empty tree
+	// to avoid any potential crashes.
+	if op.Module == nil {
+		op.Module = module.NewEmptyTree()
+	}
+
+	// Setup our count hook that keeps track of resource changes
+	countHook := new(backendlocal.CountHook)
+	if b.ContextOpts == nil {
+		b.ContextOpts = new(terraform.ContextOpts)
+	}
+	old := b.ContextOpts.Hooks
+	defer func() { b.ContextOpts.Hooks = old }()
+	b.ContextOpts.Hooks = append(b.ContextOpts.Hooks, countHook)
+
+	// Get our context
+	tfCtx, opState, err := b.context(op)
+	if err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	if op.LockState {
+		lockCtx, cancel := context.WithTimeout(ctx, op.StateLockTimeout)
+		defer cancel()
+
+		lockInfo := state.NewLockInfo()
+		lockInfo.Operation = op.Type.String()
+		lockID, err := clistate.Lock(lockCtx, opState, lockInfo, b.CLI, b.Colorize())
+		b.warnClockSkew()
+		if err != nil {
+			runningOp.Err = errwrap.Wrapf("Error locking state: {{err}}", err)
+			return
+		}
+
+		defer func() {
+			if err := clistate.Unlock(opState, lockID, b.CLI, b.Colorize()); err != nil {
+				runningOp.Err = multierror.Append(runningOp.Err, err)
+			}
+		}()
+	}
+
+	// Configure the run in Atlas
+	if _, err := b.prepareRun(ctx, op); err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	b.warnTargeted(op)
+
+	// Setup the state
+	runningOp.State = tfCtx.State()
+
+	// Start the refresh and plan in a goroutine so that we can be
+	// interrupted.
+	var plan *terraform.Plan
+	var planErr error
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+
+		// If we're refreshing before plan, perform that
+		if op.PlanRefresh {
+			log.Printf("[INFO] backend/atlas: plan calling Refresh")
+
+			if b.CLI != nil {
+				b.CLI.Output(b.Colorize().Color(strings.TrimSpace(planRefreshing) + "\n"))
+			}
+
+			if _, err := tfCtx.Refresh(); err != nil {
+				planErr = errwrap.Wrapf("Error refreshing state: {{err}}", err)
+				return
+			}
+		}
+
+		// Perform the plan
+		log.Printf("[INFO] backend/atlas: plan calling Plan")
+		var err error
+		plan, err = tfCtx.Plan()
+		if err != nil {
+			planErr = errwrap.Wrapf("Error running plan: {{err}}", err)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		if b.CLI != nil {
+			b.CLI.Output("Interrupt received. Gracefully shutting down...")
+		}
+
+		// Stop execution and wait for the plan to wind down
+		go tfCtx.Stop()
+		<-doneCh
+	case <-doneCh:
+	}
+
+	if planErr != nil {
+		runningOp.Err = planErr
+		return
+	}
+
+	// Record state
+	runningOp.PlanEmpty = plan.Diff.Empty()
+
+	if err := writePlanOut(op, plan); err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	if b.savePlan {
+		id, err := b.stateClient.putPlan(ctx, plan)
+		if err != nil {
+			runningOp.Err = errwrap.Wrapf("Error saving plan to Atlas: {{err}}", err)
+			return
+		}
+		runningOp.PlanId = id
+
+		if b.CLI != nil && id != "" {
+			b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+				"[reset][bold]Saved the plan to Atlas as %s.[reset]\n", id)))
+		}
+	}
+
+	b.outputPlan(op, plan,
+		countHook.ToAdd+countHook.ToRemoveAndAdd,
+		countHook.ToChange,
+		countHook.ToRemove+countHook.ToRemoveAndAdd)
+}
+
+// opPlanRemote outputs the plan of the latest Atlas run, rather than
+// making one, for the local-apply execution mode.
+func (b *Backend) opPlanRemote(
+	ctx context.Context,
+	op *backend.Operation,
+	runningOp *backend.RunningOperation) {
+	plan, run, err := b.remotePlan(ctx)
+	if err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	runningOp.State = plan.State
+	runningOp.PlanEmpty = plan.Diff.Empty()
+
+	if err := writePlanOut(op, plan); err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	if b.CLI != nil {
+		b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+			"[reset][bold]Showing the plan of Atlas run %s.[reset]\n", run.ID)))
+	}
+
+	add, change, destroy := countChanges(plan.Diff)
+	b.outputPlan(op, plan, add, change, destroy)
+}
+
+// writePlanOut saves the plan to the operation's PlanOutPath, if any.
+func writePlanOut(op *backend.Operation, plan *terraform.Plan) error {
+	path := op.PlanOutPath
+	if path == "" {
+		return nil
+	}
+
+	// Write the backend if we have one
+	plan.Backend = op.PlanOutBackend
+
+	log.Printf("[INFO] backend/atlas: writing plan output to: %s", path)
+	f, err := os.Create(path)
+	if err == nil {
+		err = terraform.WritePlan(plan, f)
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("Error writing plan file: %s", err)
+	}
+
+	return nil
+}
+
+// outputPlan outputs the plan and a summary of its changes, if we have a
+// CLI to output to.
+func (b *Backend) outputPlan(
+	op *backend.Operation, plan *terraform.Plan, add, change, destroy int) {
+	if b.CLI == nil {
+		return
+	}
+
+	if plan.Diff.Empty() {
+		b.CLI.Output(b.Colorize().Color(strings.TrimSpace(planNoChanges)))
+		return
+	}
+
+	if path := op.PlanOutPath; path == "" {
+		b.CLI.Output(strings.TrimSpace(planHeaderNoOutput) + "\n")
+	} else {
+		b.CLI.Output(fmt.Sprintf(
+			strings.TrimSpace(planHeaderYesOutput)+"\n",
+			path))
+	}
+
+	b.CLI.Output(format.Plan(&format.PlanOpts{
+		Plan:        plan,
+		Color:       b.Colorize(),
+		ModuleDepth: -1,
+	}))
+
+	b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+		"[reset][bold]Plan:[reset] "+
+			"%d to add, %d to change, %d to destroy.",
+		add, change, destroy)))
+}
+
+// warnTargeted warns that the operation is partial if it's limited to
+// -target addresses.
+func (b *Backend) warnTargeted(op *backend.Operation) {
+	if len(op.Targets) == 0 || b.CLI == nil {
+		return
+	}
+
+	b.CLI.Output(b.Colorize().Color(
+		"[reset][bold][yellow]" + strings.TrimSpace(targetedWarning) + "[reset]\n"))
+}
+
+const targetedWarning = `
+Warning: Resource targeting is in effect
+
+The -target option limits this operation to the given resources and their
+dependencies, so the result may not represent all of the changes the
+configuration requires. Avoid using -target routinely; it's intended for
+exceptional situations such as recovering from errors or mistakes.
+`
+
+const planErrNoConfig = `
+No configuration files found!
+
+Plan requires configuration to be present. Planning without a configuration
+would mark everything for destruction, which is normally not what is desired.
+If you would like to destroy everything, please run plan with the "-destroy"
+flag or create a single empty configuration file. Otherwise, please create
+a Terraform configuration file in the path being executed and try again.
+`
+
+const planHeaderNoOutput = `
+The Terraform execution plan has been generated and is shown below.
+Resources are shown in alphabetical order for quick scanning. Green resources
+will be created (or destroyed and then created if an existing resource
+exists), yellow resources are being changed in-place, and red resources
+will be destroyed. Cyan entries are data sources to be read.
+
+Note: You didn't specify an "-out" parameter to save this plan, so when
+"apply" is called, Terraform can't guarantee this is what will execute.
+`
+
+const planHeaderYesOutput = `
+The Terraform execution plan has been generated and is shown below.
+Resources are shown in alphabetical order for quick scanning. Green resources
+will be created (or destroyed and then created if an existing resource
+exists), yellow resources are being changed in-place, and red resources
+will be destroyed. Cyan entries are data sources to be read.
+
+Your plan was also saved to the path below. Call the "apply" subcommand
+with this plan file and Terraform will exactly execute this execution
+plan.
+
+Path: %s
+`
+
+const planNoChanges = `
+[reset][bold][green]No changes. Infrastructure is up-to-date.[reset][green]
+
+This means that Terraform did not detect any differences between your
+configuration and real physical resources that exist. As a result, Terraform
+doesn't need to do anything.
+`
+
+const planRefreshing = `
+[reset][bold]Refreshing Terraform state in-memory prior to plan...[reset]
+The refreshed state will be used to calculate this plan, but will not be
+persisted to Atlas.
+`
@@ -0,0 +1,310 @@
+package atlas
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_planBasic(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan")
+	defer modCleanup()
+
+	op := testOperationPlan()
+	op.Module = mod
+	op.PlanRefresh = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.DiffCalled {
+		t.Fatal("diff should be called")
+	}
+	if run.PlanEmpty {
+		t.Fatal("plan should not be empty")
+	}
+}
+
+func TestBackend_planOperationProviders(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	def := testProvider(t, b, "test")
+
+	// The operation's provider overrides the backend's
+	p := new(terraform.MockResourceProvider)
+	p.DiffReturn = &terraform.InstanceDiff{}
+	p.ResourcesReturn = []terraform.ResourceType{{Name: "test_instance"}}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan")
+	defer modCleanup()
+
+	op := testOperationPlan()
+	op.Module = mod
+	op.Providers = map[string]terraform.ResourceProviderFactory{
+		"test": terraform.ResourceProviderFactoryFixed(p),
+	}
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.DiffCalled {
+		t.Fatal("diff should be called on the operation's provider")
+	}
+	if def.DiffCalled {
+		t.Fatal("diff should not be called on the backend's provider")
+	}
+	if len(b.ContextOpts.Providers) != 1 {
+		t.Fatalf("backend providers modified: %#v", b.ContextOpts.Providers)
+	}
+}
+
+func TestBackend_planTargeted(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.DiffReturn = &terraform.InstanceDiff{
+		Attributes: map[string]*terraform.ResourceAttrDiff{
+			"ami": &terraform.ResourceAttrDiff{New: "bar", RequiresNew: true},
+		},
+	}
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan-targeted")
+	defer modCleanup()
+
+	planPath := filepath.Join(testTempDir(t), "plan.tfplan")
+	defer os.RemoveAll(filepath.Dir(planPath))
+
+	op := testOperationPlan()
+	op.Module = mod
+	op.Targets = []string{"test_instance.foo"}
+	op.PlanOutPath = planPath
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	// Only the targeted resource is planned
+	plan := testReadPlan(t, planPath)
+	resources := plan.Diff.RootModule().Resources
+	if len(resources) != 1 || resources["test_instance.foo"] == nil {
+		t.Fatalf("bad: %#v", resources)
+	}
+
+	// The targets are sent to Atlas with the run
+	if len(fakeAtlas.runs) != 1 {
+		t.Fatalf("expected one run, got %d", len(fakeAtlas.runs))
+	}
+	var payload struct {
+		Run runRequest `json:"run"`
+	}
+	if err := json.Unmarshal(fakeAtlas.runs[0], &payload); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(payload.Run.Targets, []string{"test_instance.foo"}) {
+		t.Fatalf("bad targets: %#v", payload.Run.Targets)
+	}
+
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Resource targeting is in effect") {
+		t.Fatalf("expected targeting warning:\n\n%s", output)
+	}
+}
+
+func TestBackend_planNoConfig(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	testProvider(t, b, "test")
+
+	op := testOperationPlan()
+	op.Module = nil
+	op.PlanRefresh = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+
+	err = run.Err
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), "configuration") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestBackend_planDestroy(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testPlanState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan")
+	defer modCleanup()
+
+	outDir := testTempDir(t)
+	defer os.RemoveAll(outDir)
+	planPath := filepath.Join(outDir, "plan.tfplan")
+
+	op := testOperationPlan()
+	op.Destroy = true
+	op.PlanRefresh = true
+	op.Module = mod
+	op.PlanOutPath = planPath
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.RefreshCalled {
+		t.Fatal("refresh should be called")
+	}
+
+	if run.PlanEmpty {
+		t.Fatal("plan should not be empty")
+	}
+
+	plan := testReadPlan(t, planPath)
+	for _, m := range plan.Diff.Modules {
+		for _, r := range m.Resources {
+			if !r.Destroy {
+				t.Fatalf("bad: %#v", r)
+			}
+		}
+	}
+}
+
+func TestBackend_planVariables(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan-var")
+	defer modCleanup()
+
+	op := testOperationPlan()
+	op.Module = mod
+	op.Variables = map[string]interface{}{"ami": "baz"}
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.DiffCalled {
+		t.Fatal("diff should be called")
+	}
+	if v, _ := p.DiffDesired.Get("ami"); v != "baz" {
+		t.Fatalf("variable not passed to the context: %#v", v)
+	}
+}
+
+func testOperationPlan() *backend.Operation {
+	return &backend.Operation{
+		Type:        backend.OperationTypePlan,
+		Environment: backend.DefaultStateName,
+	}
+}
+
+// testPlanState is just a common state that we use for testing plan.
+func testPlanState() *terraform.State {
+	return &terraform.State{
+		Version: 2,
+		Modules: []*terraform.ModuleState{
+			&terraform.ModuleState{
+				Path: []string{"root"},
+				Resources: map[string]*terraform.ResourceState{
+					"test_instance.foo": &terraform.ResourceState{
+						Type: "test_instance",
+						Primary: &terraform.InstanceState{
+							ID: "bar",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func testReadPlan(t *testing.T, path string) *terraform.Plan {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+
+	p, err := terraform.ReadPlan(f)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return p
+}
+
+func testTempDir(t *testing.T) string {
+	d, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return d
+}
@@ -0,0 +1,114 @@
+package atlas
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/command/clistate"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func (b *Backend) opRefresh(
+	ctx context.Context,
+	op *backend.Operation,
+	runningOp *backend.RunningOperation) {
+	log.Printf("[INFO] backend/atlas: starting Refresh operation")
+
+	// If we have no config module given to use, create an empty tree to
+	// avoid crashes when Terraform.Context is initialized.
+	if op.Module == nil {
+		op.Module = module.NewEmptyTree()
+	}
+
+	// Get our context
+	tfCtx, opState, err := b.context(op)
+	if err != nil {
+		runningOp.Err = err
+		return
+	}
+
+	if op.LockState {
+		lockCtx, cancel := context.WithTimeout(ctx, op.StateLockTimeout)
+		defer cancel()
+
+		lockInfo := state.NewLockInfo()
+		lockInfo.Operation = op.Type.String()
+		lockID, err := clistate.Lock(lockCtx, opState, lockInfo, b.CLI, b.Colorize())
+		b.warnClockSkew()
+		if err != nil {
+			runningOp.Err = errwrap.Wrapf("Error locking state: {{err}}", err)
+			return
+		}
+
+		defer func() {
+			if err := clistate.Unlock(opState, lockID, b.CLI, b.Colorize()); err != nil {
+				runningOp.Err = multierror.Append(runningOp.Err, err)
+			}
+		}()
+	}
+
+	// Set our state
+	runningOp.State = opState.State()
+	if runningOp.State.Empty() || !runningOp.State.HasResources() {
+		if b.CLI != nil {
+			b.CLI.Output(b.Colorize().Color(
+				strings.TrimSpace(refreshNoState) + "\n"))
+		}
+	}
+
+	// Start the refresh in a goroutine so that we can be interrupted.
+	var newState *terraform.State
+	var refreshErr error
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		newState, refreshErr = tfCtx.Refresh()
+	}()
+
+	select {
+	case <-ctx.Done():
+		if b.CLI != nil {
+			b.CLI.Output("Interrupt received. Gracefully shutting down...")
+		}
+
+		// Stop execution and wait for the refresh to wind down
+		go tfCtx.Stop()
+		<-doneCh
+	case <-doneCh:
+	}
+
+	// Even if the refresh failed, write back whatever was refreshed so
+	// that the updated attributes aren't lost.
+	if newState == nil {
+		newState = tfCtx.State()
+	}
+	runningOp.State = newState
+
+	if err := opState.WriteState(newState); err != nil {
+		runningOp.Err = errwrap.Wrapf("Error writing state: {{err}}", err)
+		return
+	}
+	if err := opState.PersistState(); err != nil {
+		runningOp.Err = errwrap.Wrapf("Error saving state: {{err}}", err)
+		return
+	}
+
+	if refreshErr != nil {
+		runningOp.Err = errwrap.Wrapf("Error refreshing state: {{err}}", refreshErr)
+		return
+	}
+}
+
+const refreshNoState = `
+[reset][bold][yellow]Empty or non-existent state in Atlas.[reset][yellow]
+
+Refresh will do nothing. Refresh does not error or return an erroneous
+exit status because many automation scripts use refresh, plan, then apply
+and may not have a state file yet for the first run.
+`
@@ -0,0 +1,301 @@
+package atlas
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_refresh(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testRefreshState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.RefreshFn = nil
+	p.RefreshReturn = &terraform.InstanceState{ID: "yes"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/refresh")
+	defer modCleanup()
+
+	op := testOperationRefresh()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.RefreshCalled {
+		t.Fatal("refresh should be called")
+	}
+
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = yes
+	`)
+}
+
+func TestBackend_refreshInProgress(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testRefreshState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	release := make(chan struct{})
+	p.RefreshFn = func(*terraform.InstanceInfo, *terraform.InstanceState) (*terraform.InstanceState, error) {
+		<-release
+		return &terraform.InstanceState{ID: "yes"}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/refresh")
+	defer modCleanup()
+
+	op := testOperationRefresh()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// A second operation fails rather than waiting for the first
+	if _, err := b.Operation(context.Background(), op); err != errOperationInProgress {
+		t.Fatalf("expected errOperationInProgress, got: %v", err)
+	}
+
+	close(release)
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	// Once the first is done, another can run
+	run, err = b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+}
+
+func TestBackend_refreshCancel(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testRefreshState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	ui := &interruptUi{MockUi: new(cli.MockUi), interrupted: make(chan struct{})}
+	b.CLI = ui
+	p := testProvider(t, b, "test")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p.RefreshFn = func(*terraform.InstanceInfo, *terraform.InstanceState) (*terraform.InstanceState, error) {
+		close(started)
+		<-release
+		return &terraform.InstanceState{ID: "yes"}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/refresh")
+	defer modCleanup()
+
+	op := testOperationRefresh()
+	op.Module = mod
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run, err := b.Operation(ctx, op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	<-started
+	cancel()
+
+	// The operation should notice the cancellation and start winding down,
+	// but it isn't done until the refresh in progress finishes.
+	select {
+	case <-ui.interrupted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("operation didn't notice its context was cancelled")
+	}
+	select {
+	case <-run.Done():
+		t.Fatal("operation done before the refresh finished")
+	default:
+	}
+
+	close(release)
+	select {
+	case <-run.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("operation not done after cancelling its context")
+	}
+}
+
+// interruptUi is a cli.Ui that closes interrupted once the operation
+// outputs that it received an interrupt, so that tests can wait for it
+// without reading the output while it's being written.
+type interruptUi struct {
+	*cli.MockUi
+
+	once        sync.Once
+	interrupted chan struct{}
+}
+
+func (u *interruptUi) Output(msg string) {
+	u.MockUi.Output(msg)
+	if strings.Contains(msg, "Interrupt received") {
+		u.once.Do(func() { close(u.interrupted) })
+	}
+}
+
+func TestBackend_refreshCancelBeforeStart(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testRefreshState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	run, err := b.Operation(ctx, testOperationRefresh())
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", run.Err)
+	}
+	if p.RefreshCalled {
+		t.Fatal("refresh should not be called")
+	}
+}
+
+func TestBackend_refreshNilModule(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testRefreshState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.RefreshFn = nil
+	p.RefreshReturn = &terraform.InstanceState{ID: "yes"}
+
+	op := testOperationRefresh()
+	op.Module = nil
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.RefreshCalled {
+		t.Fatal("refresh should be called")
+	}
+
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = yes
+	`)
+}
+
+func TestBackend_refreshClose(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testRefreshState()))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p.RefreshFn = func(*terraform.InstanceInfo, *terraform.InstanceState) (*terraform.InstanceState, error) {
+		close(started)
+		<-release
+		return &terraform.InstanceState{ID: "yes"}, nil
+	}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/refresh")
+	defer modCleanup()
+
+	op := testOperationRefresh()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-started
+
+	// Closing interrupts the operation, but waits for the refresh in
+	// progress to finish
+	closeErr := make(chan error, 1)
+	go func() { closeErr <- b.Close() }()
+	select {
+	case err := <-closeErr:
+		t.Fatalf("closed before the operation finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-closeErr; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	select {
+	case <-run.Done():
+	default:
+		t.Fatal("operation should be done once the backend is closed")
+	}
+	if fakeAtlas.puts == 0 {
+		t.Fatal("the state should be saved")
+	}
+}
+
+func testOperationRefresh() *backend.Operation {
+	return &backend.Operation{
+		Type:        backend.OperationTypeRefresh,
+		Environment: backend.DefaultStateName,
+	}
+}
+
+// testRefreshState is just a common state that we use for testing refresh.
+func testRefreshState() *terraform.State {
+	return &terraform.State{
+		Version: 2,
+		Modules: []*terraform.ModuleState{
+			&terraform.ModuleState{
+				Path: []string{"root"},
+				Resources: map[string]*terraform.ResourceState{
+					"test_instance.foo": &terraform.ResourceState{
+						Type: "test_instance",
+						Primary: &terraform.InstanceState{
+							ID: "bar",
+						},
+					},
+				},
+				Outputs: map[string]*terraform.OutputState{},
+			},
+		},
+	}
+}
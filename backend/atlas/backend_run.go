@@ -0,0 +1,306 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+const (
+	// defaultPollInterval is how often the status and logs of a run are
+	// polled if poll_interval isn't set.
+	defaultPollInterval = 3 * time.Second
+
+	// minPollInterval is the shortest allowed poll_interval.
+	minPollInterval = 1 * time.Second
+
+	// maxPollInterval is the longest that polling backs off to while a run
+	// makes no progress.
+	maxPollInterval = 30 * time.Second
+
+	// defaultRunTimeout is how long to wait for a run to finish if
+	// run_timeout isn't set.
+	defaultRunTimeout = 1 * time.Hour
+
+	// waitingInterval is how often to report that a run is still being
+	// waited on while it produces no output.
+	waitingInterval = 30 * time.Second
+
+	// cancelRunTimeout is how long to wait for a run to be canceled when
+	// the operation following it is interrupted.
+	cancelRunTimeout = 10 * time.Second
+
+	// defaultRunMessage describes the runs recorded in Atlas if
+	// run_message isn't set.
+	defaultRunMessage = "Triggered via Terraform CLI"
+)
+
+// CancelRun cancels a remote run and waits for Atlas to report it as
+// canceled, until ctx is done. Canceling a run that has already finished
+// does nothing, so it's safe to call more than once.
+func (b *Backend) CancelRun(ctx context.Context, runID string) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	run, err := b.stateClient.getRun(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if run.Done() {
+		return nil
+	}
+
+	accepted, err := b.stateClient.cancelRun(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return nil
+	}
+
+	for {
+		run, err := b.stateClient.getRun(ctx, runID)
+		if err != nil {
+			return err
+		}
+		if run.Done() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"Run %s was still %s after asking Atlas to cancel it", runID, run.Status)
+		case <-b.stateClient.clock().After(b.runPollInterval()):
+		}
+	}
+}
+
+// prepareRun configures the Atlas environment for the run of an operation,
+// setting the Terraform version to use and uploading the variables, and
+// then records the run in Atlas. The run recorded is returned, or nil if
+// Atlas doesn't record runs.
+func (b *Backend) prepareRun(ctx context.Context, op *backend.Operation) (*Run, error) {
+	b.checkTerraformVersion(terraform.VersionString())
+
+	if err := b.uploadVariables(op); err != nil {
+		return nil, errwrap.Wrapf("Error uploading variables: {{err}}", err)
+	}
+
+	run, err := b.stateClient.createRun(ctx, b.runRequest(op))
+	if _, ok := err.(*ErrTerraformVersionUnavailable); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errwrap.Wrapf("Error recording run in Atlas: {{err}}", err)
+	}
+	if run != nil {
+		log.Printf("[INFO] backend/atlas: recorded run %s", run.ID)
+		b.observer().RunQueued(run.ID)
+	}
+
+	return run, nil
+}
+
+// runRequest returns the run to record in Atlas for an operation.
+func (b *Backend) runRequest(op *backend.Operation) *runRequest {
+	r := &runRequest{
+		Type:    "plan",
+		Message: b.runMessage,
+		Destroy: op.Destroy,
+		Targets: op.Targets,
+
+		TerraformVersion: b.terraformVersion,
+	}
+	if b.parallelism > 0 {
+		r.Parallelism = b.parallelism
+	} else if b.ContextOpts != nil {
+		r.Parallelism = b.ContextOpts.Parallelism
+	}
+	if op.Type == backend.OperationTypeApply {
+		r.Type = "apply"
+	}
+	if r.Message == "" {
+		r.Message = defaultRunMessage
+	}
+
+	return r
+}
+
+// streamRunLogs follows the log of a remote run, outputting it to the CLI
+// as it arrives, until the run reaches a terminal status or the context is
+// cancelled. If the context is cancelled, the run is canceled too so that
+// it doesn't carry on in Atlas after Terraform exits.
+//
+// Polling starts at the poll interval and backs off while the run produces
+// no output. If the run doesn't finish within the run timeout, an error is
+// returned.
+func (b *Backend) streamRunLogs(ctx context.Context, runID string) (*Run, error) {
+	clock := b.stateClient.clock()
+	deadline := clock.Now().Add(b.runTimeoutOrDefault())
+	interval := b.runPollInterval()
+	lastOutput := clock.Now()
+
+	var offset int
+	var partial []byte
+	for {
+		run, err := b.stateClient.getRun(ctx, runID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return b.cancelInterruptedRun(&Run{ID: runID}), ctx.Err()
+			}
+			return nil, err
+		}
+
+		chunk, err := b.stateClient.getRunLog(ctx, runID, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return b.cancelInterruptedRun(run), ctx.Err()
+			}
+			return nil, err
+		}
+		offset += len(chunk)
+
+		// Only output complete lines, holding back any partial line until
+		// the rest of it arrives.
+		partial = append(partial, chunk...)
+		if i := bytes.LastIndexByte(partial, '\n'); i >= 0 {
+			b.outputRunLog(string(partial[:i]))
+			partial = append([]byte(nil), partial[i+1:]...)
+		}
+
+		if run.Done() {
+			if len(partial) > 0 {
+				b.outputRunLog(string(partial))
+			}
+
+			// The policy checks explain why a run stopped, if it did, so
+			// failing to read them is only logged. Servers without
+			// Sentinel have none to read.
+			if b.serverFeatures(ctx).Sentinel {
+				checks, err := b.stateClient.policyChecks(ctx, runID)
+				if err != nil {
+					log.Printf("[WARN] backend/atlas: failed to read policy checks: %s", err)
+				}
+				b.outputPolicyChecks(checks)
+			} else {
+				log.Printf("[DEBUG] backend/atlas: Sentinel isn't enabled on the server")
+			}
+
+			b.observer().RunFinished(run.ID, run.Status)
+			return run, nil
+		}
+
+		// Poll quickly while the run is making progress, and back off
+		// while it isn't.
+		now := clock.Now()
+		if len(chunk) > 0 {
+			interval = b.runPollInterval()
+			lastOutput = now
+		}
+
+		if !now.Before(deadline) {
+			return run, fmt.Errorf(
+				"Timed out after %s waiting for run %s, which is still %s",
+				b.runTimeoutOrDefault(), runID, run.Status)
+		}
+
+		if now.Sub(lastOutput) >= waitingInterval {
+			if b.CLI != nil {
+				b.CLI.Output(fmt.Sprintf(
+					"Still waiting on run %s (status %s)...", runID, run.Status))
+			}
+			lastOutput = now
+		}
+
+		wait := interval
+		if remaining := deadline.Sub(now); wait > remaining {
+			wait = remaining
+		}
+
+		select {
+		case <-ctx.Done():
+			return b.cancelInterruptedRun(run), ctx.Err()
+		case <-clock.After(wait):
+		}
+
+		interval *= 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// cancelInterruptedRun cancels a run whose operation was interrupted,
+// returning the run's latest status. Failing to cancel it is reported but
+// otherwise ignored, since the operation is ending anyway.
+func (b *Backend) cancelInterruptedRun(run *Run) *Run {
+	if b.CLI != nil {
+		b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+			"[reset][yellow]Canceling run %s in Atlas...", run.ID)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cancelRunTimeout)
+	defer cancel()
+	if err := b.CancelRun(ctx, run.ID); err != nil {
+		if b.CLI != nil {
+			b.CLI.Error(fmt.Sprintf("Failed to cancel run %s: %s", run.ID, err))
+		}
+
+		return run
+	}
+
+	latest, err := b.stateClient.getRun(ctx, run.ID)
+	if err != nil {
+		return run
+	}
+
+	return latest
+}
+
+// outputRunLog outputs lines of a run log to the CLI, highlighting errors
+// and warnings.
+func (b *Backend) outputRunLog(log string) {
+	if b.CLI == nil {
+		return
+	}
+
+	for _, line := range strings.Split(log, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Error"):
+			line = "[reset][red]" + line
+		case strings.HasPrefix(line, "Warning"):
+			line = "[reset][yellow]" + line
+		default:
+			line = "[reset]" + line
+		}
+
+		b.CLI.Output(b.Colorize().Color(line))
+	}
+}
+
+// runTimeoutOrDefault returns how long to wait for a run to finish.
+func (b *Backend) runTimeoutOrDefault() time.Duration {
+	if b.runTimeout == 0 {
+		return defaultRunTimeout
+	}
+
+	return b.runTimeout
+}
+
+// runPollInterval returns how often to poll the status of a run.
+func (b *Backend) runPollInterval() time.Duration {
+	if b.pollInterval == 0 {
+		return defaultPollInterval
+	}
+
+	return b.pollInterval
+}
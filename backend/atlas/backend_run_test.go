@@ -0,0 +1,191 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_streamRunLogs(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"pending", "planning", "planning", "planned"},
+		log:      "Refreshing state...\nWarning: deprecated\nError: failed\nno newline",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.pollInterval = 10 * time.Millisecond
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	run, err := b.streamRunLogs(context.Background(), "run-abc123")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if run.Status != "planned" {
+		t.Fatalf("bad: %#v", run)
+	}
+
+	output := ui.OutputWriter.String()
+	for _, expected := range []string{
+		"Refreshing state...\n",
+		"Warning: deprecated\n",
+		"Error: failed\n",
+		"no newline\n",
+	} {
+		if strings.Count(output, expected) != 1 {
+			t.Fatalf("expected %q once in output:\n\n%s", expected, output)
+		}
+	}
+}
+
+func TestBackend_streamRunLogsCancel(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"applying"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.pollInterval = 10 * time.Millisecond
+	b.CLI = new(cli.MockUi)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	run, err := b.streamRunLogs(ctx, "run-abc123")
+	if err != context.Canceled {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// The run must have been canceled in Atlas too
+	if run.Status != "canceled" {
+		t.Fatalf("bad: %#v", run)
+	}
+	if fake.cancels != 1 {
+		t.Fatalf("expected 1 cancel request, got %d", fake.cancels)
+	}
+}
+
+func TestBackend_CancelRun(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"applying"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.pollInterval = 10 * time.Millisecond
+
+	if err := b.CancelRun(context.Background(), "run-abc123"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !fake.canceled || fake.cancels != 1 {
+		t.Fatalf("run wasn't canceled: %d cancel requests", fake.cancels)
+	}
+
+	// Canceling again does nothing
+	if err := b.CancelRun(context.Background(), "run-abc123"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fake.cancels != 1 {
+		t.Fatalf("expected 1 cancel request, got %d", fake.cancels)
+	}
+}
+
+func TestBackend_CancelRunFinished(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"applied"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.CancelRun(context.Background(), "run-abc123"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fake.cancels != 0 || fake.canceled {
+		t.Fatalf("finished run shouldn't be canceled: %d cancel requests", fake.cancels)
+	}
+}
+
+func TestBackend_streamRunLogsTimeout(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"pending"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.pollInterval = 10 * time.Second
+	b.runTimeout = 2 * time.Minute
+	clock := new(fakeClock)
+	b.stateClient.Clock = clock
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	run, err := b.streamRunLogs(context.Background(), "run-abc123")
+	if err == nil {
+		t.Fatal("should time out")
+	}
+	if !strings.Contains(err.Error(), "run-abc123") || !strings.Contains(err.Error(), "pending") {
+		t.Fatalf("bad error: %s", err)
+	}
+	if run.Status != "pending" {
+		t.Fatalf("bad: %#v", run)
+	}
+
+	// Polling backs off up to the maximum, and stops at the deadline
+	expected := []time.Duration{
+		10 * time.Second,
+		20 * time.Second,
+		30 * time.Second,
+		30 * time.Second,
+		30 * time.Second,
+	}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Fatalf("expected sleeps %v, got %v", expected, clock.sleeps)
+	}
+
+	output := ui.OutputWriter.String()
+	if n := strings.Count(output, "Still waiting on run run-abc123 (status pending)"); n != 3 {
+		t.Fatalf("expected 3 waiting messages, got %d:\n\n%s", n, output)
+	}
+}
+
+func TestBackend_streamRunLogsDeadline(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"pending"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.pollInterval = 10 * time.Second
+	b.runTimeout = 25 * time.Second
+	clock := new(fakeClock)
+	b.stateClient.Clock = clock
+
+	if _, err := b.streamRunLogs(context.Background(), "run-abc123"); err == nil {
+		t.Fatal("should time out")
+	}
+
+	// The last wait is cut short by the deadline
+	expected := []time.Duration{10 * time.Second, 15 * time.Second}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Fatalf("expected sleeps %v, got %v", expected, clock.sleeps)
+	}
+}
@@ -1,16 +1,35 @@
 package atlas
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/terraform/backend"
 	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/state/remote"
 	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/cli"
 )
 
 func TestImpl(t *testing.T) {
 	var _ backend.Backend = new(Backend)
+	var _ backend.Enhanced = new(Backend)
+	var _ backend.Local = new(Backend)
 	var _ backend.CLI = new(Backend)
 }
 
@@ -47,3 +66,1330 @@ func TestConfigure_envToken(t *testing.T) {
 		t.Fatalf("bad: %#v", b.stateClient)
 	}
 }
+
+func TestConfigure_accessTokenFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("foo\n"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	f.Close()
+
+	b := &Backend{}
+	err = b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token_file": f.Name(),
+		"name":              "foo/bar",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if b.stateClient.AccessToken != "foo" {
+		t.Fatalf("bad: %q", b.stateClient.AccessToken)
+	}
+}
+
+func TestConfigure_accessTokenFileMissing(t *testing.T) {
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token_file": "/nonexistent/token",
+		"name":              "foo/bar",
+	})))
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/token") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestConfigure_credentialsFile(t *testing.T) {
+	defer os.Setenv("ATLAS_TOKEN", os.Getenv("ATLAS_TOKEN"))
+	os.Unsetenv("ATLAS_TOKEN")
+
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{
+  "credentials": {
+    "atlas.example.com": {"token": "foo"},
+    "other.example.com": {"token": "bar"}
+  }
+}`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	f.Close()
+
+	defer os.Setenv("TF_CLI_CONFIG_FILE", os.Getenv("TF_CLI_CONFIG_FILE"))
+	os.Setenv("TF_CLI_CONFIG_FILE", f.Name())
+
+	c := terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"address": "https://Atlas.example.com",
+		"name":    "foo/bar",
+	}))
+
+	b := &Backend{}
+	if _, errs := b.Validate(c); len(errs) != 0 {
+		t.Fatalf("bad: %v", errs)
+	}
+	if err := b.Configure(c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if b.stateClient.AccessToken != "foo" {
+		t.Fatalf("bad: %q", b.stateClient.AccessToken)
+	}
+
+	// A token that's given takes precedence
+	os.Setenv("ATLAS_TOKEN", "baz")
+	b = &Backend{}
+	if err := b.Configure(c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if b.stateClient.AccessToken != "baz" {
+		t.Fatalf("bad: %q", b.stateClient.AccessToken)
+	}
+
+	// Hosts without credentials still need a token
+	os.Unsetenv("ATLAS_TOKEN")
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"address": "https://unknown.example.com",
+		"name":    "foo/bar",
+	})))
+	if len(errs) != 1 || errs[0] != errAccessTokenRequired {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestValidate_noAccessToken(t *testing.T) {
+	defer os.Setenv("ATLAS_TOKEN", os.Getenv("ATLAS_TOKEN"))
+	os.Unsetenv("ATLAS_TOKEN")
+	defer os.Setenv("TF_CLI_CONFIG_FILE", os.Getenv("TF_CLI_CONFIG_FILE"))
+	os.Setenv("TF_CLI_CONFIG_FILE", "/nonexistent/credentials.tfrc.json")
+
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"name": "foo/bar",
+	})))
+	if len(errs) != 1 || errs[0] != errAccessTokenRequired {
+		t.Fatalf("bad: %v", errs)
+	}
+
+	os.Setenv("ATLAS_TOKEN", "foo")
+	_, errs = b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"name": "foo/bar",
+	})))
+	if len(errs) != 0 {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestInput_accessToken(t *testing.T) {
+	defer os.Setenv("ATLAS_TOKEN", os.Getenv("ATLAS_TOKEN"))
+	os.Unsetenv("ATLAS_TOKEN")
+
+	input := new(terraform.MockUIInput)
+	input.InputReturnMap = map[string]string{
+		"access_token": "foo",
+	}
+
+	b := &Backend{}
+	c, err := b.Input(input, terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"name": "foo/bar",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !input.InputCalled {
+		t.Fatal("should ask for input")
+	}
+	if !input.InputOpts.Secret {
+		t.Fatal("access token input should be secret")
+	}
+
+	if _, errs := b.Validate(c); len(errs) != 0 {
+		t.Fatalf("bad: %v", errs)
+	}
+	if err := b.Configure(c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if b.stateClient.AccessToken != "foo" {
+		t.Fatalf("bad: %q", b.stateClient.AccessToken)
+	}
+}
+
+func TestInput_accessTokenSet(t *testing.T) {
+	input := new(terraform.MockUIInput)
+
+	b := &Backend{}
+	_, err := b.Input(input, terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if input.InputCalled {
+		t.Fatal("should not ask for input")
+	}
+}
+
+func TestValidate_accessTokenConflict(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":      "foo",
+		"access_token_file": "/tmp/token",
+		"name":              "foo/bar",
+	})))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestConfigure_pollInterval(t *testing.T) {
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if b.pollInterval != defaultPollInterval {
+		t.Fatalf("bad: %s", b.pollInterval)
+	}
+}
+
+func TestConfigure_envPollInterval(t *testing.T) {
+	defer os.Setenv("ATLAS_POLL_INTERVAL", os.Getenv("ATLAS_POLL_INTERVAL"))
+	os.Setenv("ATLAS_POLL_INTERVAL", "10s")
+
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if b.pollInterval != 10*time.Second {
+		t.Fatalf("bad: %s", b.pollInterval)
+	}
+}
+
+func TestConfigure_env(t *testing.T) {
+	env := map[string]string{
+		"ATLAS_NAME":      "foo/bar",
+		"ATLAS_TIMEOUT":   "45s",
+		"ATLAS_RETRY_MAX": "7",
+		"ATLAS_GZIP":      "false",
+	}
+	for k, v := range env {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	b := &Backend{}
+	c := terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+	}))
+	if _, errs := b.Validate(c); len(errs) > 0 {
+		t.Fatalf("err: %v", errs)
+	}
+	if err := b.Configure(c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	sc := b.stateClient
+	if sc.User != "foo" || sc.Name != "bar" {
+		t.Fatalf("bad: %s/%s", sc.User, sc.Name)
+	}
+	if sc.Timeout != 45*time.Second {
+		t.Fatalf("bad: %s", sc.Timeout)
+	}
+	if sc.RetryMax != 7 {
+		t.Fatalf("bad: %d", sc.RetryMax)
+	}
+	if sc.GZip {
+		t.Fatal("gzip should be disabled")
+	}
+}
+
+func TestConfigure_envOverridden(t *testing.T) {
+	env := map[string]string{
+		"ATLAS_NAME":      "foo/bar",
+		"ATLAS_TIMEOUT":   "45s",
+		"ATLAS_RETRY_MAX": "7",
+	}
+	for k, v := range env {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	// Values in the configuration take precedence over the environment
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "baz/qux",
+		"timeout":      "10s",
+		"retry_max":    2,
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	sc := b.stateClient
+	if sc.User != "baz" || sc.Name != "qux" {
+		t.Fatalf("bad: %s/%s", sc.User, sc.Name)
+	}
+	if sc.Timeout != 10*time.Second {
+		t.Fatalf("bad: %s", sc.Timeout)
+	}
+	if sc.RetryMax != 2 {
+		t.Fatalf("bad: %d", sc.RetryMax)
+	}
+}
+
+func TestValidate_envInvalid(t *testing.T) {
+	defer os.Setenv("ATLAS_TIMEOUT", os.Getenv("ATLAS_TIMEOUT"))
+	os.Setenv("ATLAS_TIMEOUT", "soon")
+
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+	})))
+	if len(errs) == 0 {
+		t.Fatal("expected an error for an invalid ATLAS_TIMEOUT")
+	}
+}
+
+func TestConfigure_caCert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}))
+
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(caCert); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	f.Close()
+
+	for _, v := range []string{caCert, f.Name()} {
+		b := &Backend{}
+		err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         "foo/bar",
+			"address":      srv.URL,
+			"ca_cert":      v,
+		})))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if _, err := b.stateClient.Get(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+}
+
+func TestConfigure_caCertInvalid(t *testing.T) {
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+		"ca_cert":      "-----BEGIN CERTIFICATE-----\nnope\n-----END CERTIFICATE-----\n",
+	})))
+	if err == nil || !strings.Contains(err.Error(), "ca_cert") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestConfigure_skipCertVerification(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":           "foo",
+		"name":                   "foo/bar",
+		"address":                srv.URL,
+		"skip_cert_verification": true,
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := new(cli.MockUi)
+	if err := b.CLIInit(&backend.CLIOpts{CLI: ui}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "skip_cert_verification") {
+		t.Fatalf("expected warning, got: %s", ui.ErrorWriter.String())
+	}
+
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestConfigure_proxyURL(t *testing.T) {
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+		"proxy_url":    "http://proxy.example.com:3128",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	client, err := b.stateClient.http()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	transport := testTransport(t, client.HTTPClient.Transport)
+
+	req, _ := http.NewRequest("GET", "https://atlas.hashicorp.com/", nil)
+	proxy, err := transport.Proxy(req)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if proxy == nil || proxy.String() != "http://proxy.example.com:3128" {
+		t.Fatalf("bad: %v", proxy)
+	}
+}
+
+func TestConfigure_transport(t *testing.T) {
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":        "foo",
+		"name":                "foo/bar",
+		"max_idle_conns":      7,
+		"disable_keep_alives": true,
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	transport := testTransport(t, b.httpClient.HTTPClient.Transport)
+	if transport.MaxIdleConns != 7 || transport.MaxIdleConnsPerHost != 7 {
+		t.Fatalf("bad: %d, %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+	if !transport.DisableKeepAlives {
+		t.Fatal("keep-alives should be disabled")
+	}
+}
+
+func TestConfigure_transportDefaults(t *testing.T) {
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	transport := testTransport(t, b.httpClient.HTTPClient.Transport)
+	if transport.MaxIdleConns != defaultMaxIdleConns {
+		t.Fatalf("bad: %d", transport.MaxIdleConns)
+	}
+	if transport.DisableKeepAlives {
+		t.Fatal("keep-alives should be enabled")
+	}
+	if _, ok := transport.TLSNextProto["h2"]; !ok {
+		t.Fatal("HTTP/2 should be enabled")
+	}
+	if v := transport.TLSClientConfig.MinVersion; v != tls.VersionTLS12 {
+		t.Fatalf("bad TLS min version: %x", v)
+	}
+}
+
+func TestConfigure_http2(t *testing.T) {
+	protos := make(chan string, 10)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		protos <- req.Proto
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":           "foo",
+		"name":                   "foo/bar",
+		"address":                srv.URL,
+		"skip_cert_verification": true,
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	close(protos)
+	if len(protos) == 0 {
+		t.Fatal("no requests were made")
+	}
+	for proto := range protos {
+		if proto != "HTTP/2.0" {
+			t.Fatalf("expected HTTP/2, got: %s", proto)
+		}
+	}
+}
+
+func TestValidate_readAddress(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+		"read_address": "replica.example.com",
+	})))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "read_address") {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestConfigure_tlsMinVersion(t *testing.T) {
+	cases := map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	for value, expected := range cases {
+		b := &Backend{}
+		err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token":    "foo",
+			"name":            "foo/bar",
+			"tls_min_version": value,
+		})))
+		if err != nil {
+			t.Fatalf("%s: err: %s", value, err)
+		}
+
+		transport := testTransport(t, b.httpClient.HTTPClient.Transport)
+		if actual := transport.TLSClientConfig.MinVersion; actual != expected {
+			t.Fatalf("%s: expected %x, got %x", value, expected, actual)
+		}
+	}
+}
+
+func TestValidate_tlsMinVersion(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":    "foo",
+		"name":            "foo/bar",
+		"tls_min_version": "1.4",
+	})))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "1.0, 1.1, 1.2, 1.3") {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestValidate_maxStateSize(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":   "foo",
+		"name":           "foo/bar",
+		"max_state_size": 0,
+	})))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "max_state_size") {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestValidate_retryWait(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":   "foo",
+		"name":           "foo/bar",
+		"retry_wait_min": "1m",
+		"retry_wait_max": "10s",
+	})))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "retry_wait_min (1m0s)") {
+		t.Fatalf("bad: %v", errs)
+	}
+
+	_, errs = b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":   "foo",
+		"name":           "foo/bar",
+		"retry_wait_min": "0s",
+	})))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "retry_wait_min must be positive") {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestValidate_maxIdleConns(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":   "foo",
+		"name":           "foo/bar",
+		"max_idle_conns": 0,
+	})))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "max_idle_conns") {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestValidate_proxyURL(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+		"proxy_url":    "proxy.example.com:3128",
+	})))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %v", errs)
+	}
+}
+
+func TestConfigure_httpBasicAuth(t *testing.T) {
+	var user, password, token string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		user, password, _ = req.BasicAuth()
+		token = req.Header.Get(atlasTokenHeader)
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":        "foo",
+		"name":                "foo/bar",
+		"address":             srv.URL,
+		"http_basic_user":     "gateway",
+		"http_basic_password": "secret",
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if user != "gateway" || password != "secret" {
+		t.Fatalf("bad basic auth: %q, %q", user, password)
+	}
+	if token != "foo" {
+		t.Fatalf("bad token: %q", token)
+	}
+}
+
+func TestConfigure_userAgent(t *testing.T) {
+	var ua string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ua = req.Header.Get("User-Agent")
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "Terraform/" + terraform.VersionString() + " (atlas-backend)"
+	if ua != expected {
+		t.Fatalf("expected %q, got %q", expected, ua)
+	}
+
+	b = &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"user_agent":   "ci/1.0",
+	})
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ua != expected+" ci/1.0" {
+		t.Fatalf("bad: %q", ua)
+	}
+}
+
+func TestConfigure_headers(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"headers": map[string]interface{}{
+			"X-Env": "prod",
+		},
+	})
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if v := header.Get("X-Env"); v != "prod" {
+		t.Fatalf("bad: %q", v)
+	}
+	if v := header.Get(atlasTokenHeader); v != "sometoken" {
+		t.Fatalf("bad: %q", v)
+	}
+}
+
+func TestValidate_headersReserved(t *testing.T) {
+	for _, k := range []string{"Authorization", "content-md5", "X-Atlas-Token"} {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         "foo/bar",
+			"headers": map[string]interface{}{
+				k: "foo",
+			},
+		})))
+		if len(errs) != 1 {
+			t.Fatalf("%s: bad: %v", k, errs)
+		}
+	}
+}
+
+func TestValidate_httpBasicAuth(t *testing.T) {
+	for _, k := range []string{"http_basic_user", "http_basic_password"} {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         "foo/bar",
+			k:              "foo",
+		})))
+		if len(errs) != 1 {
+			t.Fatalf("%s: bad: %v", k, errs)
+		}
+	}
+}
+
+func TestValidate_combined(t *testing.T) {
+	defer os.Setenv("ATLAS_TOKEN", os.Getenv("ATLAS_TOKEN"))
+	os.Unsetenv("ATLAS_TOKEN")
+	defer os.Setenv("TF_CLI_CONFIG_FILE", os.Getenv("TF_CLI_CONFIG_FILE"))
+	os.Setenv("TF_CLI_CONFIG_FILE", "/nonexistent/credentials.tfrc.json")
+
+	cases := map[string]struct {
+		Config map[string]interface{}
+		Errs   []string
+	}{
+		"valid": {
+			map[string]interface{}{
+				"access_token":        "foo",
+				"http_basic_user":     "user",
+				"http_basic_password": "password",
+				"execution_mode":      "local-apply",
+			},
+			nil,
+		},
+		"no access token": {
+			map[string]interface{}{},
+			[]string{"access_token"},
+		},
+		"basic auth user only": {
+			map[string]interface{}{
+				"access_token":    "foo",
+				"http_basic_user": "user",
+			},
+			[]string{"http_basic_user and http_basic_password"},
+		},
+		"basic auth password only": {
+			map[string]interface{}{
+				"access_token":        "foo",
+				"http_basic_password": "password",
+			},
+			[]string{"http_basic_user and http_basic_password"},
+		},
+		"local-apply with environment variables": {
+			map[string]interface{}{
+				"access_token":          "foo",
+				"execution_mode":        "local-apply",
+				"environment_variables": map[string]interface{}{"FOO": "bar"},
+			},
+			[]string{"environment_variables can't be set"},
+		},
+		"local-apply with run message": {
+			map[string]interface{}{
+				"access_token":   "foo",
+				"execution_mode": "local-apply",
+				"run_message":    "hello",
+			},
+			[]string{"run_message can't be set"},
+		},
+		"remote with environment variables": {
+			map[string]interface{}{
+				"access_token":          "foo",
+				"environment_variables": map[string]interface{}{"FOO": "bar"},
+				"run_message":           "hello",
+			},
+			nil,
+		},
+		"everything": {
+			map[string]interface{}{
+				"http_basic_user":       "user",
+				"execution_mode":        "local-apply",
+				"environment_variables": map[string]interface{}{"FOO": "bar"},
+				"run_message":           "hello",
+			},
+			[]string{
+				"access_token",
+				"http_basic_user and http_basic_password",
+				"environment_variables can't be set",
+				"run_message can't be set",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		raw := map[string]interface{}{"name": "foo/bar"}
+		for k, v := range tc.Config {
+			raw[k] = v
+		}
+
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, raw)))
+		if len(errs) != len(tc.Errs) {
+			t.Fatalf("%s: expected %d errors, got: %v", name, len(tc.Errs), errs)
+		}
+		for i, e := range tc.Errs {
+			if !strings.Contains(errs[i].Error(), e) {
+				t.Fatalf("%s: expected %q in error %d, got: %v", name, e, i, errs)
+			}
+		}
+	}
+}
+
+func TestValidate_address(t *testing.T) {
+	cases := []struct {
+		Value string
+		Err   bool
+	}{
+		{"https://atlas.hashicorp.com", false},
+		{"https://atlas.hashicorp.com/", false},
+		{"http://127.0.0.1:8080", false},
+		{"atlas.example.com", true},
+		{"ftp://atlas.example.com", true},
+		{"https://", true},
+		{"https://atlas.example.com/foo", false},
+		{"https://atlas.example.com/foo/", false},
+		{"https://atlas.example.com?foo=bar", true},
+		{"https://atlas.example.com/foo#bar", true},
+		{"://atlas.example.com", true},
+	}
+
+	for _, tc := range cases {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         "foo/bar",
+			"address":      tc.Value,
+		})))
+		if (len(errs) > 0) != tc.Err {
+			t.Fatalf("%s: bad: %v", tc.Value, errs)
+		}
+	}
+}
+
+func TestBackend_addressPath(t *testing.T) {
+	cases := []string{"/terraform", "/terraform/"}
+
+	for _, p := range cases {
+		var requested string
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			requested = req.URL.Path
+			resp.WriteHeader(http.StatusNotFound)
+		}))
+
+		b := &Backend{}
+		backend.TestBackendConfig(t, b, map[string]interface{}{
+			"access_token": "sometoken",
+			"name":         "someuser/some-test-remote-state",
+			"address":      srv.URL + p,
+		})
+
+		expected := "/terraform/api/v1/terraform/state/someuser/some-test-remote-state"
+		if actual := b.stateClient.url().Path; actual != expected {
+			t.Fatalf("%s: expected %q, got %q", p, expected, actual)
+		}
+
+		if _, err := b.stateClient.Get(); err != nil {
+			t.Fatalf("%s: err: %s", p, err)
+		}
+		srv.Close()
+
+		if requested != expected {
+			t.Fatalf("%s: expected request to %q, got %q", p, expected, requested)
+		}
+	}
+}
+
+func TestValidate_addressNoScheme(t *testing.T) {
+	b := &Backend{}
+	_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "foo",
+		"name":         "foo/bar",
+		"address":      "atlas.example.com",
+	})))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "https://atlas.example.com") {
+		t.Fatalf("bad: %s", errs[0])
+	}
+}
+
+func TestValidate_name(t *testing.T) {
+	cases := []struct {
+		Value string
+		Err   bool
+	}{
+		{"hashicorp/myenv", false},
+		{"my-org/my_env.prod", false},
+		{"hashicorp", true},
+		{"hashicorp/", true},
+		{"/myenv", true},
+		{"hashicorp/my/env", true},
+		{"hashicorp/my env", true},
+		{"hashicorp/myenv?foo", true},
+	}
+
+	for _, tc := range cases {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         tc.Value,
+		})))
+		if (len(errs) > 0) != tc.Err {
+			t.Fatalf("%s: bad: %v", tc.Value, errs)
+		}
+	}
+}
+
+func TestBackend_parsedName(t *testing.T) {
+	b := &Backend{name: "hashicorp/myenv"}
+	org, env, err := b.parsedName()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if org != "hashicorp" || env != "myenv" {
+		t.Fatalf("bad: %s, %s", org, env)
+	}
+
+	b = &Backend{name: "hashicorp"}
+	if _, _, err := b.parsedName(); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestValidate_timeout(t *testing.T) {
+	cases := map[string]bool{
+		"30s": false,
+		"1m":  false,
+		"0s":  true,
+		"-1s": true,
+		"foo": true,
+	}
+
+	for value, shouldErr := range cases {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         "foo/bar",
+			"timeout":      value,
+		})))
+		if (len(errs) > 0) != shouldErr {
+			t.Fatalf("%s: bad: %v", value, errs)
+		}
+	}
+}
+
+func TestValidate_lockTimeout(t *testing.T) {
+	cases := map[string]bool{
+		"0s":  false,
+		"5m":  false,
+		"-1s": true,
+		"foo": true,
+	}
+
+	for value, shouldErr := range cases {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "foo",
+			"name":         "foo/bar",
+			"lock_timeout": value,
+		})))
+		if (len(errs) > 0) != shouldErr {
+			t.Fatalf("%s: bad: %v", value, errs)
+		}
+	}
+}
+
+func TestValidate_outputFormat(t *testing.T) {
+	cases := map[string]bool{
+		"human": false,
+		"json":  false,
+		"xml":   true,
+	}
+
+	for value, shouldErr := range cases {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token":  "foo",
+			"name":          "foo/bar",
+			"output_format": value,
+		})))
+		if (len(errs) > 0) != shouldErr {
+			t.Fatalf("%s: bad: %v", value, errs)
+		}
+	}
+}
+
+func TestValidate_pollInterval(t *testing.T) {
+	cases := map[string]bool{
+		"1s":    false,
+		"500ms": true,
+		"0s":    true,
+		"foo":   true,
+	}
+
+	for value, shouldErr := range cases {
+		b := &Backend{}
+		_, errs := b.Validate(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token":  "foo",
+			"name":          "foo/bar",
+			"poll_interval": value,
+		})))
+		if (len(errs) > 0) != shouldErr {
+			t.Fatalf("%s: bad: %v", value, errs)
+		}
+	}
+}
+
+func TestState_notConfigured(t *testing.T) {
+	b := &Backend{}
+	if _, err := b.State(backend.DefaultStateName); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
+
+func TestBackend_FetchState(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+
+	// Fetching must not wait for the operation lock
+	b.opLock.Lock()
+	b.opRunning = true
+	b.opLock.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s, err := b.FetchState(context.Background())
+			if err != nil {
+				errs <- err
+				return
+			}
+			if s == nil || s.Serial != 2 || s.RootModule().Outputs["foo"] == nil {
+				errs <- fmt.Errorf("bad state: %s", s)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestBackend_sharedHTTPClient(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(fakeAtlas.handler))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+
+	// Every state uses the client built by Configure
+	s, err := b.State(backend.DefaultStateName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if client := s.(*remote.State).Client.(*stateClient); client.HTTPClient != b.httpClient {
+		t.Fatal("the state should share the backend's HTTP client")
+	}
+
+	// Run with -race: fetching and refreshing concurrently must be safe
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := b.FetchState(context.Background()); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s, err := b.State(backend.DefaultStateName)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := s.RefreshState(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Once the connections are pooled, further requests reuse them
+	before := atomic.LoadInt32(&conns)
+	for i := 0; i < 5; i++ {
+		if _, err := b.FetchState(context.Background()); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if after := atomic.LoadInt32(&conns); after != before {
+		t.Fatalf("expected connections to be reused, %d new ones were made", after-before)
+	}
+}
+
+func TestBackend_Close(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	var open int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(fakeAtlas.handler))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&open, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt32(&open, -1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if _, err := b.FetchState(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if atomic.LoadInt32(&open) == 0 {
+		t.Fatal("expected an idle connection to be kept open")
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&open) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections still open", atomic.LoadInt32(&open))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Closing again is fine
+	if err := b.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBackend_CloseNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if err := b.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBackend_ForceUnlock(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	existing := state.NewLockInfo()
+	existing.Operation = "apply"
+	fakeAtlas.lock = existing
+
+	var unlocked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == "DELETE" && strings.HasSuffix(req.URL.Path, "/lock") {
+			unlocked = append(unlocked, req.URL.Path)
+		}
+		fakeAtlas.handler(resp, req)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	// The lock is removed from the named environment
+	if err := b.ForceUnlock("prod", existing.ID); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{"/api/v1/terraform/state/someuser/prod/lock"}
+	if !reflect.DeepEqual(unlocked, expected) {
+		t.Fatalf("expected %v, got %v", expected, unlocked)
+	}
+	if fakeAtlas.lock != nil {
+		t.Fatal("lock not released")
+	}
+	if out := ui.OutputWriter.String(); !strings.Contains(out, existing.ID) {
+		t.Fatalf("the discarded lock should be output: %s", out)
+	}
+
+	if err := b.ForceUnlock("invalid/name", ""); err == nil {
+		t.Fatal("expected an error for an invalid environment name")
+	}
+}
+
+func TestBackend_ForceUnlockNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if err := b.ForceUnlock(backend.DefaultStateName, ""); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
+
+func TestBackend_FetchStateNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if _, err := b.FetchState(context.Background()); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
+
+// testBackend returns a Backend configured against the given fake Atlas
+// server, with in-memory ContextOpts.
+func testBackend(t *testing.T, srv *httptest.Server) *Backend {
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+	b.ContextOpts = &terraform.ContextOpts{}
+
+	return b
+}
+
+// withOrganizationAccess answers the organization access check made by
+// Configure, passing every other request to h, for tests that count or
+// check every request they get.
+func withOrganizationAccess(h http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/api/v1/organizations/") {
+			resp.WriteHeader(http.StatusOK)
+			return
+		}
+
+		h(resp, req)
+	}
+}
+
+// testTransport returns the *http.Transport underneath any RoundTrippers
+// wrapping it.
+func testTransport(t *testing.T, rt http.RoundTripper) *http.Transport {
+	for {
+		switch v := rt.(type) {
+		case *http.Transport:
+			return v
+		case *userAgentTransport:
+			rt = v.Transport
+		case *basicAuthTransport:
+			rt = v.Transport
+		case *headersTransport:
+			rt = v.Transport
+		case *countingTransport:
+			rt = v.Transport
+		default:
+			t.Fatalf("unknown transport: %T", rt)
+		}
+	}
+}
+
+// testProvider modifies the ContextOpts of the backend to have a mock
+// provider with the given name.
+func testProvider(t *testing.T, b *Backend, name string) *terraform.MockResourceProvider {
+	p := new(terraform.MockResourceProvider)
+	p.DiffReturn = &terraform.InstanceDiff{}
+	p.RefreshFn = func(
+		info *terraform.InstanceInfo,
+		s *terraform.InstanceState) (*terraform.InstanceState, error) {
+		return s, nil
+	}
+	p.ResourcesReturn = []terraform.ResourceType{
+		terraform.ResourceType{
+			Name: "test_instance",
+		},
+	}
+
+	if b.ContextOpts == nil {
+		b.ContextOpts = &terraform.ContextOpts{}
+	}
+	if b.ContextOpts.Providers == nil {
+		b.ContextOpts.Providers = make(map[string]terraform.ResourceProviderFactory)
+	}
+	b.ContextOpts.Providers[name] = func() (terraform.ResourceProvider, error) {
+		return p, nil
+	}
+
+	return p
+}
+
+// testStateBytes returns the JSON encoding of the given state.
+func testStateBytes(t *testing.T, s *terraform.State) []byte {
+	var buf bytes.Buffer
+	if err := terraform.WriteState(s, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+// checkState verifies the state stored in the fake Atlas server.
+func checkState(t *testing.T, f *fakeAtlas, expected string) {
+	actual := strings.TrimSpace(f.CurrentState().String())
+	expected = strings.TrimSpace(expected)
+	if actual != expected {
+		t.Fatalf("state does not match! actual:\n%s\n\nexpected:\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,78 @@
+package atlas
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultBackupCount is how many backups of each environment's state are
+// kept if backup_count isn't set.
+const defaultBackupCount = 10
+
+// defaultBackupDir returns the directory backups are written to if
+// backup_dir isn't set.
+func defaultBackupDir() string {
+	return filepath.Join(os.TempDir(), "terraform-atlas-backups")
+}
+
+// writeBackup writes a timestamped local backup of the given state before
+// it's written to Atlas, so that it can be recovered manually if the write
+// fails or Atlas loses it. Old backups beyond BackupCount are pruned.
+//
+// The path of the backup is returned.
+func (c *stateClient) writeBackup(state []byte, now time.Time) (string, error) {
+	if err := os.MkdirAll(c.BackupDir, 0700); err != nil {
+		return "", fmt.Errorf("Failed to create backup directory: %v", err)
+	}
+
+	prefix := c.backupPrefix()
+	path := filepath.Join(c.BackupDir, fmt.Sprintf(
+		"%s%s.tfstate", prefix, now.UTC().Format("20060102T150405.000000000Z")))
+	if err := ioutil.WriteFile(path, state, 0600); err != nil {
+		return "", fmt.Errorf("Failed to write state backup: %v", err)
+	}
+
+	if err := c.pruneBackups(); err != nil {
+		// Failing to prune isn't worth failing the write over
+		log.Printf("[WARN] Failed to prune Atlas state backups: %s", err)
+	}
+
+	return path, nil
+}
+
+// pruneBackups removes all but the newest BackupCount backups of the
+// environment's state.
+func (c *stateClient) pruneBackups() error {
+	if c.BackupCount <= 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(c.BackupDir, c.backupPrefix()+"*.tfstate"))
+	if err != nil {
+		return err
+	}
+	if len(matches) <= c.BackupCount {
+		return nil
+	}
+
+	// The timestamps in the names sort chronologically
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-c.BackupCount] {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupPrefix is the prefix of the names of the environment's backups.
+func (c *stateClient) backupPrefix() string {
+	return strings.Replace(c.User+"-"+c.Name, string(filepath.Separator), "-", -1) + "-"
+}
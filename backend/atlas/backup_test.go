@@ -0,0 +1,140 @@
+package atlas
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestStateClient_backup(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"backup_dir":   dir,
+		"backup_count": 2,
+	})
+
+	s := terraform.NewState()
+	for i := 1; i <= 3; i++ {
+		s.Serial = int64(i)
+		if err := b.stateClient.Put(testStateBytes(t, s)); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tfstate"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 backups, got: %v", matches)
+	}
+	for _, path := range matches {
+		if !strings.HasPrefix(filepath.Base(path), "someuser-some-test-remote-state-") {
+			t.Fatalf("bad backup name: %s", path)
+		}
+	}
+
+	// The newest backup is the last state written
+	data, err := ioutil.ReadFile(matches[1])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != string(testStateBytes(t, s)) {
+		t.Fatalf("bad backup:\n\n%s", data)
+	}
+}
+
+func TestBackend_disableBackup(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	// The default backup directory is under the temporary directory
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", dir)
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":   "sometoken",
+		"name":           "someuser/some-test-remote-state",
+		"address":        srv.URL,
+		"disable_backup": true,
+	})
+
+	s, err := b.State(backend.DefaultStateName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.WriteState(terraform.NewState()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if fakeAtlas.puts == 0 {
+		t.Fatal("state should be written to Atlas")
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(infos) != 0 {
+		t.Fatalf("expected no local files, got: %v", infos)
+	}
+}
+
+func TestStateClient_backupFailedWrite(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"backup_dir":   dir,
+	})
+	b.stateClient.Clock = new(fakeClock)
+
+	err := b.stateClient.Put(testStateBytes(t, terraform.NewState()))
+	if err == nil {
+		t.Fatal("should error")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tfstate"))
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 backup, got: %v", matches)
+	}
+	if !strings.Contains(err.Error(), matches[0]) {
+		t.Fatalf("expected the backup path in the error, got: %s", err)
+	}
+}
@@ -0,0 +1,150 @@
+package atlas
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const (
+	// defaultChunkSize is the size of each part of a chunked upload if
+	// chunk_size isn't set.
+	defaultChunkSize = 4 * 1024 * 1024
+
+	// minChunkSize is the smallest allowed chunk_size.
+	minChunkSize = 64 * 1024
+)
+
+// putChunked uploads a state that Atlas rejected as too large for a single
+// request. An upload is started, the body is sent in parts of at most
+// ChunkSize bytes, each with its own checksum, and the upload is completed
+// with the checksum of the whole body so that Atlas verifies the
+// reassembled state. The response to completing the upload is returned for
+// the caller to handle as it would the response to a single upload.
+//
+// Completing the upload is what writes the state, so it's sent with the
+// idempotency key of the write, if there is one.
+func (c *stateClient) putChunked(target *url.URL, body []byte, md5b64, key string) (*http.Response, error) {
+	id, err := c.startUpload()
+	if err != nil {
+		return nil, err
+	}
+
+	size := c.ChunkSize
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+
+	for part, offset := 1, 0; offset < len(body); part, offset = part+1, offset+size {
+		end := offset + size
+		if end > len(body) {
+			end = len(body)
+		}
+
+		if err := c.putPart(id, part, body[offset:end]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Completing the upload writes the state, so it is sent with the
+	// query of the original request, including the serial.
+	u := c.uploadURL(id)
+	u.Path = path.Join(u.Path, "complete")
+	u.RawQuery = target.RawQuery
+
+	req, err := retryablehttp.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Header.Set("Content-MD5", md5b64)
+	if c.GZip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+
+	return c.do(req)
+}
+
+// startUpload starts a chunked upload of the state, returning its ID.
+func (c *stateClient) startUpload() (string, error) {
+	req, err := retryablehttp.NewRequest("POST", c.uploadURL("").String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", c.httpError(resp)
+	}
+
+	var result struct {
+		Upload struct {
+			ID string `json:"id"`
+		} `json:"upload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("Failed to decode response: %v", err)
+	}
+	if result.Upload.ID == "" {
+		return "", fmt.Errorf("Atlas didn't return an ID for the upload")
+	}
+
+	return result.Upload.ID, nil
+}
+
+// putPart uploads a single part of a chunked upload. Parts are numbered
+// from 1.
+func (c *stateClient) putPart(id string, part int, data []byte) error {
+	u := c.uploadURL(id)
+	u.Path = path.Join(u.Path, "parts", strconv.Itoa(part))
+
+	req, err := retryablehttp.NewRequest("PUT", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+
+	hash := md5.Sum(data)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(hash[:]))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.ContentLength = int64(len(data))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	default:
+		return fmt.Errorf("Failed to upload part %d: %s", part, c.httpError(resp))
+	}
+}
+
+// uploadURL returns the URL of the chunked upload with the given ID, or of
+// the collection of uploads if the ID is empty.
+func (c *stateClient) uploadURL(id string) *url.URL {
+	u := c.url()
+	u.Path = path.Join(u.Path, "uploads", id)
+	u.RawQuery = ""
+	return u
+}
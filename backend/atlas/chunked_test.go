@@ -0,0 +1,146 @@
+package atlas
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestStateClient_putChunked(t *testing.T) {
+	fake := &fakeUploads{t: t, maxSize: 200, parts: make(map[int][]byte)}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.stateClient.ChunkSize = 100
+	b.stateClient.GZip = false
+
+	s := terraform.NewState()
+	s.Serial = 5
+	s.RootModule().Outputs["big"] = &terraform.OutputState{
+		Type:  "string",
+		Value: strings.Repeat("x", 500),
+	}
+	data := testStateBytes(t, s)
+
+	if err := b.stateClient.Put(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !fake.rejected {
+		t.Fatal("the single upload should have been rejected")
+	}
+	if len(fake.parts) < 2 {
+		t.Fatalf("expected several parts, got %d", len(fake.parts))
+	}
+	for n, part := range fake.parts {
+		if len(part) > 100 {
+			t.Fatalf("part %d is %d bytes", n, len(part))
+		}
+	}
+	if fake.serial != "5" {
+		t.Fatalf("expected serial 5, got %q", fake.serial)
+	}
+
+	if !bytes.Equal(fake.state, data) {
+		t.Fatalf("bad state:\n\n%s", fake.state)
+	}
+}
+
+func TestValidate_chunkSize(t *testing.T) {
+	cases := map[int]bool{
+		minChunkSize:     false,
+		defaultChunkSize: false,
+		minChunkSize - 1: true,
+		0:                true,
+	}
+
+	for value, shouldErr := range cases {
+		_, errs := validateChunkSize(value, "chunk_size")
+		if (len(errs) > 0) != shouldErr {
+			t.Fatalf("%d: bad: %v", value, errs)
+		}
+	}
+}
+
+// fakeUploads is a fake Atlas server that rejects states larger than
+// maxSize, but accepts them uploaded in parts. Parts are checked against
+// their checksums, and the reassembled state against the checksum given
+// when completing the upload.
+type fakeUploads struct {
+	t       *testing.T
+	maxSize int
+
+	sync.Mutex
+	rejected bool
+	parts    map[int][]byte
+	state    []byte
+	serial   string
+}
+
+func (f *fakeUploads) handler(resp http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	const base = "/api/v1/terraform/state/someuser/some-test-remote-state"
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		f.t.Fatalf("err: %s", err)
+	}
+
+	switch {
+	case req.Method == "PUT" && req.URL.Path == base:
+		if len(body) > f.maxSize {
+			f.rejected = true
+			resp.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		f.state = body
+
+	case req.Method == "POST" && req.URL.Path == base+"/uploads":
+		resp.WriteHeader(http.StatusCreated)
+		resp.Write([]byte(`{"upload": {"id": "upload-1"}}`))
+
+	case req.Method == "PUT" && strings.HasPrefix(req.URL.Path, base+"/uploads/upload-1/parts/"):
+		n, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, base+"/uploads/upload-1/parts/"))
+		if err != nil {
+			http.Error(resp, "bad part", http.StatusBadRequest)
+			return
+		}
+		if !f.checkMD5(req, body) {
+			http.Error(resp, "bad part checksum", http.StatusBadRequest)
+			return
+		}
+		f.parts[n] = body
+
+	case req.Method == "POST" && req.URL.Path == base+"/uploads/upload-1/complete":
+		var state []byte
+		for n := 1; n <= len(f.parts); n++ {
+			state = append(state, f.parts[n]...)
+		}
+		if !f.checkMD5(req, state) {
+			http.Error(resp, "bad checksum", http.StatusBadRequest)
+			return
+		}
+		f.state = state
+		f.serial = req.URL.Query().Get("serial")
+
+	default:
+		// The lineage check reads the state; there is none yet.
+		resp.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeUploads) checkMD5(req *http.Request, data []byte) bool {
+	sum := md5.Sum(data)
+	return req.Header.Get("Content-MD5") == base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -1,13 +1,87 @@
 package atlas
 
 import (
+	"io"
+	"os"
+	"strings"
+
 	"github.com/hashicorp/terraform/backend"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/cli"
 )
 
 // backend.CLI impl.
 func (b *Backend) CLIInit(opts *backend.CLIOpts) error {
 	b.CLI = opts.CLI
+	if b.CLI != nil && b.outputFormat == outputFormatJSON {
+		b.CLI = &jsonUi{Ui: b.CLI}
+	}
+	if b.CLI != nil {
+		// Secrets are redacted before the output is encoded, since encoding
+		// can escape them so that they no longer match.
+		b.CLI = &redactUi{Ui: b.CLI, redactor: b.secrets}
+	}
 	b.CLIColor = opts.CLIColor
 	b.ContextOpts = opts.ContextOpts
+	b.OpInput = opts.Input
+	b.OpValidation = opts.Validation
+
+	if b.CLI != nil && b.stateClient != nil && b.stateClient.SkipCertVerification {
+		b.CLI.Warn(b.Colorize().Color(strings.TrimSpace(skipCertVerificationWarning)))
+	}
+
 	return nil
 }
+
+// colorDisabled returns true if the CLI output mustn't be colored, whatever
+// CLIColor says.
+func (b *Backend) colorDisabled() bool {
+	if b.outputFormat == outputFormatJSON {
+		return true
+	}
+
+	// See https://no-color.org/
+	if os.Getenv("NO_COLOR") != "" {
+		return true
+	}
+
+	// Only when the CLI writes straight to a file can we tell whether
+	// it's a terminal.
+	if f, ok := uiWriter(b.CLI).(*os.File); ok && !isatty.IsTerminal(f.Fd()) {
+		return true
+	}
+
+	return false
+}
+
+// uiWriter returns the writer that a Ui outputs to, looking through the
+// Uis that wrap others. If it can't be determined, nil is returned.
+func uiWriter(ui cli.Ui) io.Writer {
+	for {
+		switch u := ui.(type) {
+		case *cli.BasicUi:
+			return u.Writer
+		case *cli.ColoredUi:
+			ui = u.Ui
+		case *cli.PrefixedUi:
+			ui = u.Ui
+		case *cli.ConcurrentUi:
+			ui = u.Ui
+		case *jsonUi:
+			ui = u.Ui
+		case *redactUi:
+			ui = u.Ui
+		default:
+			return nil
+		}
+	}
+}
+
+const skipCertVerificationWarning = `
+[reset][bold][yellow]Warning: TLS certificate verification is disabled for Atlas[reset][yellow]
+
+The Atlas backend is configured with skip_cert_verification, so the identity
+of the Atlas server is not being verified. Anyone able to intercept the
+connection can read or modify your state and access token. This should only
+be used to test against servers with self-signed certificates.
+`
@@ -0,0 +1,49 @@
+package atlas
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/mitchellh/colorstring"
+)
+
+func TestBackend_ColorizeNoColor(t *testing.T) {
+	defer os.Setenv("NO_COLOR", os.Getenv("NO_COLOR"))
+	os.Unsetenv("NO_COLOR")
+
+	b := &Backend{
+		CLI:      new(cli.MockUi),
+		CLIColor: &colorstring.Colorize{Colors: colorstring.DefaultColors},
+	}
+	if b.Colorize().Disable {
+		t.Fatal("color should be enabled")
+	}
+
+	os.Setenv("NO_COLOR", "1")
+
+	c := b.Colorize()
+	if c == nil || !c.Disable {
+		t.Fatal("color should be disabled")
+	}
+}
+
+func TestBackend_ColorizeNotTerminal(t *testing.T) {
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	b := &Backend{
+		CLI: &cli.ColoredUi{
+			Ui: &cli.BasicUi{Writer: f, ErrorWriter: f},
+		},
+		CLIColor: &colorstring.Colorize{Colors: colorstring.DefaultColors},
+	}
+	if !b.Colorize().Disable {
+		t.Fatal("color should be disabled when not writing to a terminal")
+	}
+}
@@ -0,0 +1,70 @@
+package atlas
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clockSkewThreshold is how far the local clock may be from the clock of
+// Atlas before a warning is given. The Date header only has a resolution
+// of a second, so small differences can't be measured anyway.
+const clockSkewThreshold = 30 * time.Second
+
+// clockSkew is how far ahead of the local clock the clock of Atlas was
+// when a lock was last acquired or inspected.
+type clockSkew struct {
+	sync.Mutex
+	skew  time.Duration
+	known bool
+}
+
+// recordClockSkew records how far the Date of a response from Atlas is from
+// the local clock. Responses without a valid Date are ignored.
+func (c *stateClient) recordClockSkew(resp *http.Response) {
+	if c.skew == nil {
+		return
+	}
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	c.skew.Lock()
+	defer c.skew.Unlock()
+	c.skew.skew = date.Sub(c.clock().Now())
+	c.skew.known = true
+}
+
+// warnClockSkew warns if the local clock was found to be skewed from the
+// clock of Atlas when the state's lock was last acquired or inspected. The
+// times in lock info are from the clock of whoever took the lock, so a
+// skewed clock makes locks look older or newer than they are.
+func (b *Backend) warnClockSkew() {
+	if b.CLI == nil || b.stateClient.skew == nil {
+		return
+	}
+
+	b.stateClient.skew.Lock()
+	skew, known := b.stateClient.skew.skew, b.stateClient.skew.known
+	b.stateClient.skew.Unlock()
+	if !known {
+		return
+	}
+
+	direction := "behind"
+	if skew < 0 {
+		skew = -skew
+		direction = "ahead of"
+	}
+	if skew <= clockSkewThreshold {
+		return
+	}
+
+	b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+		"[reset][yellow]Warning: the local clock is %s %s the clock of Atlas. The\n"+
+			"times shown for state locks may be off by as much.[reset]\n",
+		skew.Round(time.Second), direction)))
+}
@@ -0,0 +1,70 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_clockSkewWarning(t *testing.T) {
+	cases := map[string]struct {
+		Skew     time.Duration
+		Expected string
+	}{
+		"behind": {10 * time.Minute, "the local clock is 10m0s behind"},
+		"ahead":  {-10 * time.Minute, "the local clock is 10m0s ahead of"},
+		"small":  {5 * time.Second, ""},
+	}
+
+	for name, tc := range cases {
+		now := time.Now().Truncate(time.Second)
+		fakeAtlas := newFakeAtlas(t, nil)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Date", now.Add(tc.Skew).UTC().Format(http.TimeFormat))
+			fakeAtlas.handler(w, r)
+		}))
+
+		b := testBackend(t, srv)
+		testProvider(t, b, "test")
+		ui := new(cli.MockUi)
+		b.CLI = ui
+
+		// The local time is fixed so that the skew measured is exactly the
+		// one sent
+		b.stateClient.Clock = &fakeClock{now: now}
+
+		mod, modCleanup := module.TestTree(t, "./test-fixtures/plan")
+
+		op := testOperationPlan()
+		op.Module = mod
+		op.LockState = true
+
+		run, err := b.Operation(context.Background(), op)
+		if err != nil {
+			t.Fatalf("%s: bad: %s", name, err)
+		}
+		<-run.Done()
+		modCleanup()
+		srv.Close()
+		if run.Err != nil {
+			t.Fatalf("%s: err: %s", name, run.Err)
+		}
+
+		output := ui.OutputWriter.String()
+		if tc.Expected == "" {
+			if strings.Contains(output, "Warning: the local clock") {
+				t.Fatalf("%s: unexpected warning: %s", name, output)
+			}
+			continue
+		}
+		if !strings.Contains(output, tc.Expected) {
+			t.Fatalf("%s: expected %q in output: %s", name, tc.Expected, output)
+		}
+	}
+}
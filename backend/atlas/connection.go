@@ -0,0 +1,115 @@
+package atlas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// requiredScopes are the scopes the access token needs for the backend to
+// work.
+var requiredScopes = []string{"state:read", "state:write"}
+
+// CheckConnection verifies that Atlas is reachable, that it accepts the
+// access token, that the token has the scopes the backend needs, and that
+// the configured environment exists. It never changes anything in Atlas,
+// so it's safe to use to check a new configuration.
+func (b *Backend) CheckConnection(ctx context.Context) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	return b.stateClient.checkConnection(ctx)
+}
+
+func (c *stateClient) checkConnection(ctx context.Context) error {
+	// Authenticate, which also tells us the scopes of the token
+	var auth struct {
+		Scopes []string `json:"scopes"`
+	}
+	status, err := c.getJSONContext(ctx, c.apiURL("api/v1/authenticate"), &auth)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	default:
+		return fmt.Errorf("Unexpected response authenticating with Atlas: HTTP %d", status)
+	}
+
+	for _, required := range requiredScopes {
+		found := false
+		for _, scope := range auth.Scopes {
+			if scope == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf(
+				"The access token is missing the %q scope, which the Atlas backend\n"+
+					"needs. Generate a token with the %q scopes.",
+				required, requiredScopes)
+		}
+	}
+
+	// Check the environment exists
+	status, err = c.getJSONContext(ctx, c.environmentURL(), nil)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return fmt.Errorf(
+			"The environment %s/%s does not exist in Atlas. Create it in the\n"+
+				"Environments section of Atlas first.", c.User, c.Name)
+	default:
+		return fmt.Errorf(
+			"Unexpected response checking the environment %s/%s: HTTP %d",
+			c.User, c.Name, status)
+	}
+}
+
+// getJSONContext makes a GET request with the given context, decoding the
+// response into v if it's successful and v isn't nil. The status of the
+// response is returned; an error is only returned if no response was
+// received.
+func (c *stateClient) getJSONContext(ctx context.Context, u *url.URL, v interface{}) (int, error) {
+	req, err := retryablehttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to connect to Atlas at %s: %v", c.Server, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return 0, fmt.Errorf("Failed to decode response: %v", err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// apiURL returns the URL of the given API path on the server.
+func (c *stateClient) apiURL(p string) *url.URL {
+	return c.endpoints().URL(p)
+}
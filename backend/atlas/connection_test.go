@@ -0,0 +1,120 @@
+package atlas
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackend_CheckConnection(t *testing.T) {
+	fake := &fakeConnection{
+		t:      t,
+		scopes: []string{"state:read", "state:write"},
+		env:    "someuser/some-test-remote-state",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.CheckConnection(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBackend_CheckConnectionUnauthorized(t *testing.T) {
+	fake := &fakeConnection{t: t, token: "othertoken"}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.CheckConnection(context.Background()); err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized, got: %v", err)
+	}
+}
+
+func TestBackend_CheckConnectionMissingScope(t *testing.T) {
+	fake := &fakeConnection{
+		t:      t,
+		scopes: []string{"state:read"},
+		env:    "someuser/some-test-remote-state",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	err := b.CheckConnection(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `"state:write"`) {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestBackend_CheckConnectionMissingEnvironment(t *testing.T) {
+	fake := &fakeConnection{
+		t:      t,
+		scopes: []string{"state:read", "state:write"},
+		env:    "someuser/other",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	err := b.CheckConnection(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestBackend_CheckConnectionNetwork(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	b := testBackend(t, srv)
+	b.stateClient.RetryMax = 0
+	srv.Close()
+
+	err := b.CheckConnection(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Failed to connect") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+// fakeConnection is a fake Atlas server for the authentication and
+// environment APIs. It fails the test if anything but a GET is made.
+type fakeConnection struct {
+	t *testing.T
+
+	// token is the accepted access token, defaulting to the one used by
+	// testBackend
+	token  string
+	scopes []string
+	env    string
+}
+
+func (f *fakeConnection) handler(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		f.t.Fatalf("unexpected %s %s", req.Method, req.URL)
+	}
+
+	token := f.token
+	if token == "" {
+		token = "sometoken"
+	}
+	if req.Header.Get(atlasTokenHeader) != token {
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case req.URL.Path == "/api/v1/authenticate":
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"scopes": f.scopes,
+		})
+	case req.URL.Path == "/api/v1/environments/"+f.env:
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"name": f.env,
+		})
+	default:
+		resp.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,111 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+)
+
+// CostEstimate is Atlas's estimate of how a run changes the monthly cost
+// of the infrastructure.
+type CostEstimate struct {
+	// MonthlyDelta is the change in monthly cost, in Currency.
+	MonthlyDelta float64 `json:"monthly_delta"`
+	Currency     string  `json:"currency"`
+
+	// Resources is the change in monthly cost of each resource.
+	Resources []ResourceCost `json:"resources"`
+}
+
+// ResourceCost is the estimated change in monthly cost of a resource.
+type ResourceCost struct {
+	Address      string  `json:"address"`
+	MonthlyDelta float64 `json:"monthly_delta"`
+}
+
+// CostEstimate returns the cost estimate of a run. If Atlas has no cost
+// estimate for the run, nil is returned.
+func (b *Backend) CostEstimate(ctx context.Context, runID string) (*CostEstimate, error) {
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	return b.stateClient.costEstimate(ctx, runID)
+}
+
+func (c *stateClient) costEstimate(ctx context.Context, runID string) (*CostEstimate, error) {
+	u := c.runURL(runID)
+	u.Path = path.Join(u.Path, "cost-estimate")
+
+	var result struct {
+		CostEstimate *CostEstimate `json:"cost_estimate"`
+	}
+	status, err := c.getJSONContext(ctx, u, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return result.CostEstimate, nil
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	case http.StatusForbidden:
+		return nil, ErrForbidden
+	default:
+		return nil, fmt.Errorf(
+			"Unexpected response reading the cost estimate of run %s: HTTP %d",
+			runID, status)
+	}
+}
+
+// outputCostEstimate outputs the cost estimate of the given Atlas run, such
+// as the one recorded for the operation, or if runID is empty, of the Atlas
+// run that Terraform is running in, if there is one. Cost estimates are
+// only informational, so if one isn't available, or the server doesn't
+// estimate costs, it's skipped.
+func (b *Backend) outputCostEstimate(ctx context.Context, runID string) {
+	if runID == "" {
+		runID = b.stateClient.RunId
+	}
+	if !b.costEstimate || runID == "" || b.CLI == nil {
+		return
+	}
+	if !b.serverFeatures(ctx).CostEstimation {
+		log.Printf("[DEBUG] backend/atlas: cost estimation isn't enabled on the server")
+		return
+	}
+
+	estimate, err := b.stateClient.costEstimate(ctx, runID)
+	if err != nil {
+		log.Printf("[WARN] backend/atlas: failed to read cost estimate: %s", err)
+		return
+	}
+	if estimate == nil {
+		log.Printf("[DEBUG] backend/atlas: no cost estimate for run %s", runID)
+		return
+	}
+
+	b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+		"\n[reset][bold]Estimated monthly cost change:[reset] %s",
+		formatCost(estimate.MonthlyDelta, estimate.Currency))))
+	for _, r := range estimate.Resources {
+		b.CLI.Output(fmt.Sprintf(
+			"  %s: %s", r.Address, formatCost(r.MonthlyDelta, estimate.Currency)))
+	}
+	b.CLI.Output("")
+}
+
+// formatCost formats a change in cost with its sign, such as "+12.50 USD".
+func formatCost(delta float64, currency string) string {
+	s := fmt.Sprintf("%+.2f", delta)
+	if currency != "" {
+		s += " " + currency
+	}
+
+	return s
+}
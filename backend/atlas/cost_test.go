@@ -0,0 +1,172 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_CostEstimate(t *testing.T) {
+	estimate := &CostEstimate{
+		MonthlyDelta: 12.5,
+		Currency:     "USD",
+		Resources: []ResourceCost{
+			{Address: "aws_instance.web", MonthlyDelta: 15},
+			{Address: "aws_eip.old", MonthlyDelta: -2.5},
+		},
+	}
+	fake := &fakeRuns{
+		t:            t,
+		statuses:     []string{"planned"},
+		costEstimate: estimate,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	actual, err := b.CostEstimate(context.Background(), "run-abc123")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(actual, estimate) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestBackend_CostEstimateNone(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"planned"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	actual, err := b.CostEstimate(context.Background(), "run-abc123")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual != nil {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestBackend_outputCostEstimate(t *testing.T) {
+	defer os.Setenv("NO_COLOR", os.Getenv("NO_COLOR"))
+	os.Setenv("NO_COLOR", "1")
+
+	// The run recorded for the operation is used, falling back to the run
+	// Terraform is running in.
+	cases := map[string]struct {
+		RunID      string
+		AtlasRunID string
+	}{
+		"recorded run": {"run-abc123", ""},
+		"ATLAS_RUN_ID": {"", "run-abc123"},
+	}
+
+	for name, tc := range cases {
+		fake := &fakeRuns{
+			t:        t,
+			statuses: []string{"planned"},
+			costEstimate: &CostEstimate{
+				MonthlyDelta: 12.5,
+				Currency:     "USD",
+				Resources: []ResourceCost{
+					{Address: "aws_instance.web", MonthlyDelta: 15},
+					{Address: "aws_eip.old", MonthlyDelta: -2.5},
+				},
+			},
+			serverInfo: &ServerInfo{
+				Features: ServerFeatures{CostEstimation: true},
+			},
+		}
+		srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+
+		b := testBackend(t, srv)
+		b.stateClient.RunId = tc.AtlasRunID
+		ui := new(cli.MockUi)
+		b.CLI = ui
+
+		b.outputCostEstimate(context.Background(), tc.RunID)
+		srv.Close()
+
+		expected := []string{
+			"Estimated monthly cost change: +12.50 USD",
+			"  aws_instance.web: +15.00 USD",
+			"  aws_eip.old: -2.50 USD",
+		}
+		output := ui.OutputWriter.String()
+		for _, e := range expected {
+			if !strings.Contains(output, e) {
+				t.Fatalf("%s: output doesn't contain %q:\n\n%s", name, e, output)
+			}
+		}
+	}
+}
+
+func TestBackend_outputCostEstimateSkipped(t *testing.T) {
+	cases := map[string]struct {
+		RunID       string
+		Disabled    bool
+		Unsupported bool
+	}{
+		"no run":      {"", false, false},
+		"disabled":    {"run-abc123", true, false},
+		"none":        {"run-none", false, false},
+		"unsupported": {"run-abc123", false, true},
+	}
+
+	for name, tc := range cases {
+		fake := &fakeRuns{
+			t:        t,
+			statuses: []string{"planned"},
+		}
+		if !tc.Unsupported {
+			fake.serverInfo = &ServerInfo{
+				Features: ServerFeatures{CostEstimation: true},
+			}
+		}
+		if tc.RunID != "run-none" {
+			fake.costEstimate = &CostEstimate{MonthlyDelta: 1}
+		}
+		srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+
+		b := testBackend(t, srv)
+		b.stateClient.RunId = tc.RunID
+		b.costEstimate = !tc.Disabled
+		ui := new(cli.MockUi)
+		b.CLI = ui
+
+		b.outputCostEstimate(context.Background(), "")
+		srv.Close()
+
+		if ui.OutputWriter != nil && ui.OutputWriter.Len() > 0 {
+			t.Fatalf("%s: expected no output, got:\n\n%s", name, ui.OutputWriter.String())
+		}
+	}
+}
+
+func TestFormatCost(t *testing.T) {
+	cases := []struct {
+		Delta    float64
+		Currency string
+		Expected string
+	}{
+		{12.5, "USD", "+12.50 USD"},
+		{-3, "EUR", "-3.00 EUR"},
+		{0, "", "+0.00"},
+	}
+
+	for _, tc := range cases {
+		if actual := formatCost(tc.Delta, tc.Currency); actual != tc.Expected {
+			t.Fatalf("%v %s: expected %q, got %q", tc.Delta, tc.Currency, tc.Expected, actual)
+		}
+	}
+}
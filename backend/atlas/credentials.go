@@ -0,0 +1,74 @@
+package atlas
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/mitchellh/go-homedir"
+)
+
+// credentialsConfig is the part of the CLI credentials file, as written by
+// terraform login, that holds the tokens for each host. The file may be
+// JSON or HCL.
+type credentialsConfig struct {
+	Credentials map[string]struct {
+		Token string `hcl:"token"`
+	} `hcl:"credentials"`
+}
+
+// credentialsFile returns the path of the CLI credentials file. It can be
+// overridden with TF_CLI_CONFIG_FILE.
+func credentialsFile() (string, error) {
+	if path := os.Getenv("TF_CLI_CONFIG_FILE"); path != "" {
+		return path, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".terraform.d", "credentials.tfrc.json"), nil
+}
+
+// credentialsToken returns the token stored for the given host in the CLI
+// credentials file. If there's no file, or no token for the host, an empty
+// string is returned.
+func credentialsToken(host string) (string, error) {
+	if host == "" {
+		return "", nil
+	}
+
+	path, err := credentialsFile()
+	if err != nil {
+		return "", fmt.Errorf("Error finding the credentials file: %s", err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error reading the credentials file %s: %s", path, err)
+	}
+
+	var config credentialsConfig
+	if err := hcl.Decode(&config, string(raw)); err != nil {
+		return "", fmt.Errorf("Error parsing the credentials file %s: %s", path, err)
+	}
+
+	// Hosts are case insensitive
+	for k, v := range config.Credentials {
+		if strings.EqualFold(k, host) {
+			log.Printf("[DEBUG] backend/atlas: using the token for %s from %s", host, path)
+			return v.Token, nil
+		}
+	}
+
+	return "", nil
+}
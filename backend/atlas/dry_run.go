@@ -0,0 +1,23 @@
+package atlas
+
+import (
+	"fmt"
+	"log"
+)
+
+// dryRun returns true if dry_run is set, in which case the call that would
+// change something in Atlas is skipped, and what it would have done is
+// output instead. The actions are described as "would <action>".
+func (c *stateClient) dryRun(format string, args ...interface{}) bool {
+	if !c.DryRun {
+		return false
+	}
+
+	msg := "Dry run: would " + fmt.Sprintf(format, args...)
+	log.Printf("[INFO] backend/atlas: %s", msg)
+	if c.dryRunOutput != nil {
+		c.dryRunOutput(msg)
+	}
+
+	return true
+}
@@ -0,0 +1,145 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_dryRun(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	var lock sync.Mutex
+	var mutating []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			lock.Lock()
+			mutating = append(mutating, req.Method+" "+req.URL.Path)
+			lock.Unlock()
+		}
+
+		fakeAtlas.handler(resp, req)
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"dry_run":      true,
+	})
+	b.ContextOpts = &terraform.ContextOpts{}
+	ui := new(cli.MockUi)
+	b.CLI = ui
+	p := testProvider(t, b, "test")
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+	op.LockState = true
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	// The apply runs, but nothing is changed in Atlas
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+	if len(mutating) != 0 {
+		t.Fatalf("expected no mutating requests, got: %v", mutating)
+	}
+	if fakeAtlas.puts != 0 || fakeAtlas.lock != nil || len(fakeAtlas.runs) != 0 {
+		t.Fatal("nothing should be written to Atlas")
+	}
+
+	output := ui.OutputWriter.String()
+	for _, expected := range []string{
+		"Dry run: would lock the state of someuser/some-test-remote-state",
+		"Dry run: would record the apply run of someuser/some-test-remote-state",
+		"Dry run: would write the state of someuser/some-test-remote-state",
+		"Dry run: would unlock the state of someuser/some-test-remote-state",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in output: %s", expected, output)
+		}
+	}
+}
+
+func TestBackend_dryRunForceUnlock(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	existing := state.NewLockInfo()
+	fakeAtlas.lock = existing
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"dry_run":      true,
+	})
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	if err := b.ForceUnlock(backend.DefaultStateName, existing.ID); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fakeAtlas.lock == nil {
+		t.Fatal("the lock should be kept")
+	}
+
+	output := ui.OutputWriter.String()
+	if strings.Contains(output, "Discarded") {
+		t.Fatalf("the lock shouldn't be reported as discarded: %s", output)
+	}
+	if !strings.Contains(output, "Dry run: would discard the following lock") ||
+		!strings.Contains(output, existing.ID) {
+		t.Fatalf("expected the lock that would be discarded in output: %s", output)
+	}
+}
+
+func TestStateClient_dryRunRollback(t *testing.T) {
+	var mutating int
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			mutating++
+		}
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"dry_run":      true,
+	}).(*stateClient)
+
+	if err := client.rollbackState(context.Background(), 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Delete(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if mutating != 0 {
+		t.Fatalf("expected no mutating requests, got %d", mutating)
+	}
+}
@@ -0,0 +1,206 @@
+package atlas
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// encryptionVersion is the version of the format of encrypted states,
+	// which is the first byte of the encrypted data. Version 1 is followed
+	// by the salt, the nonce and the AES-256-GCM sealed state.
+	encryptionVersion = 1
+
+	// The sizes of the parts of a version 1 encrypted state.
+	encryptionSaltSize  = 16
+	encryptionNonceSize = 12
+
+	// encryptionIterations is the number of PBKDF2 iterations used to
+	// derive the key from the passphrase.
+	encryptionIterations = 100000
+)
+
+var (
+	// ErrStateNotEncrypted is returned when reading a state that isn't
+	// encrypted while encryption_passphrase is set.
+	ErrStateNotEncrypted = errors.New(
+		"The state in Atlas is not encrypted, but encryption_passphrase is set.\n" +
+			"Unset it to read the state, and the state will be encrypted the next\n" +
+			"time it's written with it set.")
+
+	// ErrStateEncrypted is returned when reading an encrypted state while
+	// encryption_passphrase isn't set.
+	ErrStateEncrypted = errors.New(
+		"The state in Atlas is encrypted. Set encryption_passphrase or\n" +
+			"ATLAS_STATE_KEY to the passphrase it was encrypted with to read it.")
+)
+
+// encryptedState is how an encrypted state is stored in Atlas. The serial
+// and lineage are left in the clear so that Atlas can still detect
+// conflicting writes.
+type encryptedState struct {
+	Serial         int64  `json:"serial"`
+	Lineage        string `json:"lineage,omitempty"`
+	EncryptedState string `json:"encrypted_state"`
+}
+
+// stateCipher encrypts and decrypts states with a key derived from a
+// passphrase. The key derived for the last salt is kept, since deriving it
+// is deliberately slow.
+type stateCipher struct {
+	passphrase string
+
+	sync.Mutex
+	salt []byte
+	key  []byte
+}
+
+// Encrypt returns the given state encrypted, with the serial and lineage
+// of the state left in the clear.
+func (c *stateCipher) Encrypt(state []byte, serial int64, lineage string) ([]byte, error) {
+	// The salt is only chosen once, and every write gets its own nonce
+	salt, key, err := c.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, encryptionNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("Failed to generate nonce: %v", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(encryptionVersion)
+	buf.Write(salt)
+	buf.Write(nonce)
+	buf.Write(gcm.Seal(nil, nonce, state, nil))
+
+	return json.MarshalIndent(&encryptedState{
+		Serial:         serial,
+		Lineage:        lineage,
+		EncryptedState: base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, "", "    ")
+}
+
+// Decrypt returns the given encrypted state decrypted.
+func (c *stateCipher) Decrypt(data []byte) ([]byte, error) {
+	var s encryptedState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("Failed to read state: %v", err)
+	}
+	if s.EncryptedState == "" {
+		return nil, ErrStateNotEncrypted
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(s.EncryptedState)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode encrypted state: %v", err)
+	}
+	if len(raw) == 0 || raw[0] != encryptionVersion {
+		return nil, fmt.Errorf("The state is encrypted in an unknown format")
+	}
+	raw = raw[1:]
+	if len(raw) < encryptionSaltSize+encryptionNonceSize {
+		return nil, fmt.Errorf("The encrypted state is truncated")
+	}
+	salt, raw := raw[:encryptionSaltSize], raw[encryptionSaltSize:]
+	nonce, sealed := raw[:encryptionNonceSize], raw[encryptionNonceSize:]
+
+	gcm, err := newGCM(c.decryptionKey(salt))
+	if err != nil {
+		return nil, err
+	}
+	state, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Failed to decrypt the state. Check that encryption_passphrase is the\n" +
+				"passphrase it was encrypted with.")
+	}
+
+	return state, nil
+}
+
+// encryptionKey returns the salt and key to encrypt with, choosing a salt
+// if there isn't one yet.
+func (c *stateCipher) encryptionKey() ([]byte, []byte, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.salt == nil {
+		salt := make([]byte, encryptionSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, nil, fmt.Errorf("Failed to generate salt: %v", err)
+		}
+
+		c.salt = salt
+		c.key = deriveKey(c.passphrase, salt)
+	}
+
+	return c.salt, c.key, nil
+}
+
+// decryptionKey returns the key for the given salt.
+func (c *stateCipher) decryptionKey(salt []byte) []byte {
+	c.Lock()
+	defer c.Unlock()
+
+	if !bytes.Equal(salt, c.salt) {
+		c.salt = append([]byte(nil), salt...)
+		c.key = deriveKey(c.passphrase, c.salt)
+	}
+
+	return c.key
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create cipher: %v", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// isEncryptedState returns true if the state is an encrypted one.
+func isEncryptedState(data []byte) bool {
+	var s encryptedState
+	return json.Unmarshal(data, &s) == nil && s.EncryptedState != ""
+}
+
+// deriveKey derives a 32 byte key from the passphrase with PBKDF2 using
+// HMAC-SHA256, as described in RFC 2898. The key is a single block long.
+func deriveKey(passphrase string, salt []byte) []byte {
+	prf := hmac.New(sha256.New, []byte(passphrase))
+
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], 1)
+	prf.Write(salt)
+	prf.Write(index[:])
+	u := prf.Sum(nil)
+
+	key := append([]byte(nil), u...)
+	for i := 1; i < encryptionIterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+
+	return key
+}
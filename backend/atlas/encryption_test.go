@@ -0,0 +1,114 @@
+package atlas
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/state/remote"
+)
+
+func testEncryptedClient(t *testing.T, srvURL, passphrase string) *stateClient {
+	c := map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srvURL,
+	}
+	if passphrase != "" {
+		c["encryption_passphrase"] = passphrase
+	}
+
+	return testStateClient(t, c).(*stateClient)
+}
+
+func TestStateClient_encryption(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testEncryptedClient(t, srv.URL, "correct horse battery staple")
+	if err := client.Put(testStateSimple); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Atlas only gets the serial and lineage in the clear
+	if bytes.Contains(fakeAtlas.state, []byte("modules")) {
+		t.Fatalf("state not encrypted: %s", fakeAtlas.state)
+	}
+	if fakeAtlas.CurrentSerial() != 2 {
+		t.Fatalf("bad serial: %d", fakeAtlas.CurrentSerial())
+	}
+
+	// Another client with the passphrase reads the state back
+	client = testEncryptedClient(t, srv.URL, "correct horse battery staple")
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if payload == nil || !bytes.Equal(payload.Data, testStateSimple) {
+		t.Fatalf("bad: %#v", payload)
+	}
+
+	// Encrypted states are written again as the newer state
+	s := &remote.State{Client: client}
+	if err := s.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.WriteState(testSeedState(s.State().Lineage, 3)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fakeAtlas.CurrentSerial() != 3 {
+		t.Fatalf("bad serial: %d", fakeAtlas.CurrentSerial())
+	}
+}
+
+func TestStateClient_encryptionWrongPassphrase(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testEncryptedClient(t, srv.URL, "correct horse battery staple")
+	if err := client.Put(testStateSimple); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	client = testEncryptedClient(t, srv.URL, "wrong")
+	_, err := client.Get()
+	if err == nil || !strings.Contains(err.Error(), "Failed to decrypt") {
+		t.Fatalf("expected a decryption error, got: %v", err)
+	}
+
+	// Without a passphrase, the encrypted state isn't read as a state
+	client = testEncryptedClient(t, srv.URL, "")
+	if _, err := client.Get(); err != ErrStateEncrypted {
+		t.Fatalf("expected ErrStateEncrypted, got: %v", err)
+	}
+}
+
+func TestStateClient_encryptionNotEncrypted(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testEncryptedClient(t, srv.URL, "correct horse battery staple")
+	if _, err := client.Get(); err != ErrStateNotEncrypted {
+		t.Fatalf("expected ErrStateNotEncrypted, got: %v", err)
+	}
+}
+
+func TestBackend_encryptionPassphraseRedacted(t *testing.T) {
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":          "sometoken",
+		"name":                  "someuser/some-test-remote-state",
+		"encryption_passphrase": "correct horse battery staple",
+	})
+
+	if actual := b.secrets.Redact("correct horse battery staple"); actual != redactedValue {
+		t.Fatalf("passphrase not redacted: %s", actual)
+	}
+}
@@ -0,0 +1,105 @@
+package atlas
+
+import (
+	"net/url"
+	"path"
+)
+
+// endpoints builds the URLs of the Atlas API. They're joined beneath the
+// path of the configured address, if it has one, so that Atlas can be
+// served under a subpath such as https://example.com/atlas.
+type endpoints struct {
+	base *url.URL
+}
+
+// URL returns the URL of the given API path.
+func (e endpoints) URL(elem ...string) *url.URL {
+	return &url.URL{
+		Scheme: e.base.Scheme,
+		Host:   e.base.Host,
+		Path:   path.Join(append([]string{"/", e.base.Path}, elem...)...),
+	}
+}
+
+// StateURL returns the URL of the state of an environment.
+func (e endpoints) StateURL(org, env string) *url.URL {
+	return e.URL("api/v1/terraform/state", org, env)
+}
+
+// LockURL returns the URL of the lock of the state of an environment.
+func (e endpoints) LockURL(org, env string) *url.URL {
+	return e.URL("api/v1/terraform/state", org, env, "lock")
+}
+
+// ArchiveURL returns the URL of the archive status of an environment.
+func (e endpoints) ArchiveURL(org, env string) *url.URL {
+	return e.URL("api/v1/terraform/state", org, env, "archive")
+}
+
+// EnvironmentsURL returns the URL listing the environments of an
+// organization.
+func (e endpoints) EnvironmentsURL(org string) *url.URL {
+	return e.URL("api/v1/terraform/state", org)
+}
+
+// EnvironmentURL returns the URL of an environment.
+func (e endpoints) EnvironmentURL(org, env string) *url.URL {
+	return e.URL("api/v1/environments", org, env)
+}
+
+// OrganizationEnvironmentsURL returns the URL that environments are created
+// at in an organization.
+func (e endpoints) OrganizationEnvironmentsURL(org string) *url.URL {
+	return e.URL("api/v1/environments", org)
+}
+
+// RunsURL returns the URL listing the runs of an environment.
+func (e endpoints) RunsURL(org, env string) *url.URL {
+	return e.URL("api/v1/environments", org, env, "runs")
+}
+
+// VariablesURL returns the URL of the run variables of an environment.
+func (e endpoints) VariablesURL(org, env string) *url.URL {
+	return e.URL("api/v1/environments", org, env, "variables")
+}
+
+// PlansURL returns the URL of the saved plans of an environment.
+func (e endpoints) PlansURL(org, env string) *url.URL {
+	return e.URL("api/v1/environments", org, env, "plans")
+}
+
+// PlanURL returns the URL of a saved plan of an environment.
+func (e endpoints) PlanURL(org, env, id string) *url.URL {
+	return e.URL("api/v1/environments", org, env, "plans", id)
+}
+
+// RunURL returns the URL of a run.
+func (e endpoints) RunURL(id string) *url.URL {
+	return e.URL("api/v1/terraform/runs", id)
+}
+
+// OrganizationURL returns the URL of an organization.
+func (e endpoints) OrganizationURL(org string) *url.URL {
+	return e.URL("api/v1/organizations", org)
+}
+
+// VersionURL returns the URL reporting the version and features of the
+// server.
+func (e endpoints) VersionURL() *url.URL {
+	return e.URL("api/v1/version")
+}
+
+// endpoints returns the endpoints of the configured server.
+func (c *stateClient) endpoints() endpoints {
+	return endpoints{base: c.ServerURL}
+}
+
+// readEndpoints returns the endpoints to read from, which are those of the
+// read replica if one is configured.
+func (c *stateClient) readEndpoints() endpoints {
+	if c.ReadServerURL != nil {
+		return endpoints{base: c.ReadServerURL}
+	}
+
+	return c.endpoints()
+}
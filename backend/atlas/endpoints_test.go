@@ -0,0 +1,65 @@
+package atlas
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestEndpoints(t *testing.T) {
+	cases := []struct {
+		Address  string
+		Expected string
+	}{
+		{"https://atlas.hashicorp.com", "https://atlas.hashicorp.com/api/v1/terraform/state/org/env"},
+		{"https://atlas.hashicorp.com/", "https://atlas.hashicorp.com/api/v1/terraform/state/org/env"},
+		{"https://example.com/atlas", "https://example.com/atlas/api/v1/terraform/state/org/env"},
+		{"https://example.com/atlas/", "https://example.com/atlas/api/v1/terraform/state/org/env"},
+		{"https://example.com//atlas//", "https://example.com/atlas/api/v1/terraform/state/org/env"},
+		{"http://localhost:8080/a/b", "http://localhost:8080/a/b/api/v1/terraform/state/org/env"},
+	}
+
+	for _, tc := range cases {
+		base, err := url.Parse(tc.Address)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Address, err)
+		}
+
+		actual := endpoints{base: base}.StateURL("org", "env").String()
+		if actual != tc.Expected {
+			t.Fatalf("%s: expected %q, got %q", tc.Address, tc.Expected, actual)
+		}
+	}
+}
+
+func TestEndpoints_paths(t *testing.T) {
+	base, err := url.Parse("https://example.com/atlas/")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	e := endpoints{base: base}
+
+	cases := map[string]struct {
+		URL      *url.URL
+		Expected string
+	}{
+		"lock":         {e.LockURL("org", "env"), "/atlas/api/v1/terraform/state/org/env/lock"},
+		"environments": {e.EnvironmentsURL("org"), "/atlas/api/v1/terraform/state/org"},
+		"environment":  {e.EnvironmentURL("org", "env"), "/atlas/api/v1/environments/org/env"},
+		"create":       {e.OrganizationEnvironmentsURL("org"), "/atlas/api/v1/environments/org"},
+		"runs":         {e.RunsURL("org", "env"), "/atlas/api/v1/environments/org/env/runs"},
+		"variables":    {e.VariablesURL("org", "env"), "/atlas/api/v1/environments/org/env/variables"},
+		"plans":        {e.PlansURL("org", "env"), "/atlas/api/v1/environments/org/env/plans"},
+		"plan":         {e.PlanURL("org", "env", "plan-abc123"), "/atlas/api/v1/environments/org/env/plans/plan-abc123"},
+		"run":          {e.RunURL("run-abc123"), "/atlas/api/v1/terraform/runs/run-abc123"},
+		"trailing":     {e.URL("api/v1/authenticate/"), "/atlas/api/v1/authenticate"},
+	}
+
+	for name, tc := range cases {
+		if tc.URL.Path != tc.Expected {
+			t.Fatalf("%s: expected %q, got %q", name, tc.Expected, tc.URL.Path)
+		}
+		if tc.URL.Host != "example.com" || tc.URL.Scheme != "https" {
+			t.Fatalf("%s: bad: %s", name, tc.URL)
+		}
+	}
+}
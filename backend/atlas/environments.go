@@ -0,0 +1,123 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// listEnvironments returns the names of all the environments with state
+// in the configured organization, following pagination if the results
+// span multiple pages.
+func (c *stateClient) listEnvironments() ([]string, error) {
+	var result []string
+
+	next := c.environmentsURL()
+	for next != nil {
+		var page struct {
+			States []struct {
+				Name string `json:"name"`
+			} `json:"states"`
+		}
+
+		link, err := c.getJSON(next, &page)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list environments: %v", err)
+		}
+		for _, s := range page.States {
+			result = append(result, s.Name)
+		}
+
+		next, err = nextPageURL(next, link)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// forEnvironment returns a fresh copy of the client for the given
+// environment in the same organization.
+func (c *stateClient) forEnvironment(env string) *stateClient {
+	client := *c
+	client.Name = env
+	client.conflictHandlingAttempted = false
+	client.hasSerialBase = false
+	client.invalidateCache()
+	return &client
+}
+
+// environmentsURL returns the URL listing the environments of the
+// organization.
+func (c *stateClient) environmentsURL() *url.URL {
+	return c.endpoints().EnvironmentsURL(c.User)
+}
+
+// ensureEnvironment creates the environment in Atlas if it doesn't exist
+// yet, returning true if it was created. An environment that's created by
+// someone else in the meantime is treated as found.
+func (c *stateClient) ensureEnvironment(ctx context.Context) (bool, error) {
+	status, err := c.getJSONContext(ctx, c.environmentURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK:
+		log.Printf("[INFO] backend/atlas: found environment %s", path.Join(c.User, c.Name))
+		return false, nil
+	case http.StatusNotFound:
+		// Created below
+	case http.StatusUnauthorized:
+		return false, ErrUnauthorized
+	case http.StatusForbidden:
+		return false, ErrForbidden
+	default:
+		return false, fmt.Errorf("Failed to read environment: HTTP error: %d", status)
+	}
+
+	if c.dryRun("create the environment %s", path.Join(c.User, c.Name)) {
+		return false, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"environment": map[string]string{"name": c.Name},
+	})
+	if err != nil {
+		return false, fmt.Errorf("Failed to encode environment: %v", err)
+	}
+
+	u := c.endpoints().OrganizationEnvironmentsURL(c.User)
+	req, err := retryablehttp.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = int64(len(body))
+	req.Request = req.Request.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, fmt.Errorf("Failed to create environment: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		log.Printf("[INFO] backend/atlas: created environment %s", path.Join(c.User, c.Name))
+		return true, nil
+	case http.StatusConflict, http.StatusUnprocessableEntity:
+		log.Printf("[INFO] backend/atlas: found environment %s", path.Join(c.User, c.Name))
+		return false, nil
+	default:
+		return false, c.httpError(resp)
+	}
+}
@@ -0,0 +1,194 @@
+package atlas
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/state/remote"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestBackend_States(t *testing.T) {
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/terraform/state/someuser" {
+			t.Fatalf("bad path: %s", req.URL.Path)
+		}
+
+		names := []string{"some-test-remote-state", "staging"}
+		if req.URL.Query().Get("page") == "2" {
+			names = []string{"prod"}
+		} else {
+			resp.Header().Set("Link", `</api/v1/terraform/state/someuser?page=2>; rel="next"`)
+		}
+
+		var page struct {
+			States []map[string]string `json:"states"`
+		}
+		for _, name := range names {
+			page.States = append(page.States, map[string]string{"name": name})
+		}
+		json.NewEncoder(resp).Encode(&page)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	states, err := b.States()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{backend.DefaultStateName, "prod", "staging"}
+	if !reflect.DeepEqual(states, expected) {
+		t.Fatalf("expected %v, got %v", expected, states)
+	}
+}
+
+func TestBackend_StateNamed(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+
+	raw, err := b.State(backend.DefaultStateName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if c := raw.(*remote.State).Client.(*stateClient); c.Name != "some-test-remote-state" {
+		t.Fatalf("bad: %s", c.Name)
+	}
+
+	raw, err = b.State("staging")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c := raw.(*remote.State).Client.(*stateClient)
+	if c.User != "someuser" || c.Name != "staging" {
+		t.Fatalf("bad: %s/%s", c.User, c.Name)
+	}
+	if c.url().Path != "/api/v1/terraform/state/someuser/staging" {
+		t.Fatalf("bad: %s", c.url().Path)
+	}
+
+	// The configured environment is unchanged
+	if b.stateClient.Name != "some-test-remote-state" {
+		t.Fatalf("bad: %s", b.stateClient.Name)
+	}
+
+	if _, err := b.State("not valid"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestBackend_DeleteState(t *testing.T) {
+	var deleted string
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "DELETE" {
+			t.Fatalf("bad method: %s", req.Method)
+		}
+
+		deleted = req.URL.Path
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.DeleteState("staging"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if deleted != "/api/v1/terraform/state/someuser/staging" {
+		t.Fatalf("bad: %s", deleted)
+	}
+
+	if err := b.DeleteState(backend.DefaultStateName); err == nil {
+		t.Fatal("should not delete the default state")
+	}
+}
+
+func TestBackend_createEnvironment(t *testing.T) {
+	cases := map[string]struct {
+		GetStatus  int
+		PostStatus int
+		Created    bool
+		Err        error
+	}{
+		"create":         {http.StatusNotFound, http.StatusCreated, true, nil},
+		"already exists": {http.StatusOK, 0, false, nil},
+		"created since":  {http.StatusNotFound, http.StatusConflict, false, nil},
+		"denied":         {http.StatusNotFound, http.StatusForbidden, false, ErrForbidden},
+	}
+
+	for name, tc := range cases {
+		var created []string
+		srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+			switch {
+			case req.Method == "GET" && req.URL.Path == "/api/v1/environments/someuser/some-test-remote-state":
+				resp.WriteHeader(tc.GetStatus)
+			case req.Method == "POST" && req.URL.Path == "/api/v1/environments/someuser":
+				var body struct {
+					Environment struct {
+						Name string `json:"name"`
+					} `json:"environment"`
+				}
+				if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+					t.Fatalf("%s: err: %s", name, err)
+				}
+				created = append(created, body.Environment.Name)
+				resp.WriteHeader(tc.PostStatus)
+			default:
+				t.Fatalf("%s: unexpected request: %s %s", name, req.Method, req.URL.Path)
+			}
+		}))
+
+		b := testBackend(t, srv)
+		actual, err := b.stateClient.ensureEnvironment(context.Background())
+		srv.Close()
+
+		if err != tc.Err {
+			t.Fatalf("%s: expected error %v, got: %v", name, tc.Err, err)
+		}
+		if actual != tc.Created {
+			t.Fatalf("%s: expected created to be %t", name, tc.Created)
+		}
+		if tc.PostStatus != 0 && !reflect.DeepEqual(created, []string{"some-test-remote-state"}) {
+			t.Fatalf("%s: bad: %v", name, created)
+		}
+		if tc.PostStatus == 0 && len(created) > 0 {
+			t.Fatalf("%s: the environment shouldn't be created", name)
+		}
+	}
+}
+
+func TestBackend_createEnvironmentConfigure(t *testing.T) {
+	var created bool
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			created = true
+			resp.WriteHeader(http.StatusForbidden)
+			return
+		}
+		resp.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token":       "sometoken",
+		"name":               "someuser/some-test-remote-state",
+		"address":            srv.URL,
+		"create_environment": true,
+	})))
+	if !created {
+		t.Fatal("the environment should be created")
+	}
+	if err == nil || !strings.Contains(err.Error(), "Error creating environment someuser/some-test-remote-state") {
+		t.Fatalf("expected a creation error, got: %v", err)
+	}
+}
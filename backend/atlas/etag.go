@@ -0,0 +1,53 @@
+package atlas
+
+import (
+	"sync"
+
+	"github.com/hashicorp/terraform/state/remote"
+)
+
+// etagCache holds the ETag and state of the last read of each state URL, so
+// that reading a state that hasn't changed since can be answered with a 304
+// rather than the whole state. It's shared by the copies of the client made
+// for each environment, which read from different URLs.
+type etagCache struct {
+	sync.Mutex
+	entries map[string]etagEntry
+}
+
+type etagEntry struct {
+	etag    string
+	payload *remote.Payload
+}
+
+// Get returns the ETag and state last read from the URL, if the read had an
+// ETag.
+func (c *etagCache) Get(url string) (string, *remote.Payload) {
+	if c == nil {
+		return "", nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	e := c.entries[url]
+	return e.etag, e.payload
+}
+
+// Set records the ETag and state read from the URL. An empty ETag forgets
+// the URL, so that the next read fetches the whole state.
+func (c *etagCache) Set(url, etag string, payload *remote.Payload) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if etag == "" || payload == nil {
+		delete(c.entries, url)
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]etagEntry)
+	}
+	c.entries[url] = etagEntry{etag: etag, payload: payload}
+}
@@ -0,0 +1,69 @@
+package atlas
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/hashicorp/terraform/helper/logging"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// The logging here only goes through the standard log package at the
+// DEBUG and TRACE levels, so it's discarded unless TF_LOG is set. The
+// access token is only ever sent as a header, which isn't logged, and
+// anything else that is logged is first masked by the redactor.
+
+// logURL returns the part of a URL that is logged for a request.
+func (c *stateClient) logURL(u *url.URL) string {
+	return c.redactor.Redact(u.RequestURI())
+}
+
+// logRequest logs a request that is about to be made.
+func (c *stateClient) logRequest(method string, u *url.URL, attempt int) {
+	if attempt == 0 {
+		log.Printf("[DEBUG] backend/atlas: %s %s", method, c.logURL(u))
+		return
+	}
+
+	log.Printf("[DEBUG] backend/atlas: %s %s (attempt %d of %d)",
+		method, c.logURL(u), attempt+1, c.RetryMax+1)
+}
+
+// logState logs a state payload sent to or received from Atlas. Payloads
+// are only logged at the TRACE level, with the values of sensitive outputs
+// and any known secrets masked.
+func (c *stateClient) logState(action string, state []byte) {
+	if logging.LogLevel() != "TRACE" {
+		return
+	}
+
+	log.Printf("[TRACE] backend/atlas: %s state: %s",
+		action, c.redactor.Redact(redactState(state)))
+}
+
+// redactState returns the state with the values of sensitive outputs
+// replaced by redactedValue. If the state can't be read, only its size is
+// returned.
+func redactState(data []byte) string {
+	s, err := terraform.ReadState(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Sprintf("<unreadable state of %d bytes>", len(data))
+	}
+
+	for _, m := range s.Modules {
+		for _, o := range m.Outputs {
+			if o.Sensitive {
+				o.Value = redactedValue
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := terraform.WriteState(s, &buf); err != nil {
+		return fmt.Sprintf("<unreadable state of %d bytes>", len(data))
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,76 @@
+package atlas
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestStateClient_logging(t *testing.T) {
+	defer os.Setenv("TF_LOG", os.Getenv("TF_LOG"))
+	os.Setenv("TF_LOG", "TRACE")
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	fakeAtlas := newFakeAtlas(t, testStateOutputs(1, "lineage-a", "password", "hunter2"))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	output := buf.String()
+	expected := []string{
+		"[DEBUG] backend/atlas: GET /api/v1/terraform/state/someuser/some-test-remote-state",
+		"200 OK",
+		"[TRACE] backend/atlas: read state:",
+	}
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Fatalf("log doesn't contain %q:\n\n%s", e, output)
+		}
+	}
+	if strings.Contains(output, "sometoken") {
+		t.Fatalf("log contains the access token:\n\n%s", output)
+	}
+}
+
+func TestRedactState(t *testing.T) {
+	s := &terraform.State{
+		Version: terraform.StateVersion,
+		Lineage: "lineage-a",
+		Modules: []*terraform.ModuleState{
+			&terraform.ModuleState{
+				Path: []string{"root"},
+				Outputs: map[string]*terraform.OutputState{
+					"password": &terraform.OutputState{Type: "string", Value: "hunter2", Sensitive: true},
+					"ip":       &terraform.OutputState{Type: "string", Value: "10.0.0.1"},
+				},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := terraform.WriteState(s, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := redactState(buf.Bytes())
+	if strings.Contains(actual, "hunter2") {
+		t.Fatalf("sensitive output not redacted:\n\n%s", actual)
+	}
+	if !strings.Contains(actual, "10.0.0.1") || !strings.Contains(actual, redactedValue) {
+		t.Fatalf("bad:\n\n%s", actual)
+	}
+
+	if actual := redactState([]byte("not a state")); actual != "<unreadable state of 11 bytes>" {
+		t.Fatalf("bad: %s", actual)
+	}
+}
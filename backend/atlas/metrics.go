@@ -0,0 +1,31 @@
+package atlas
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// Metrics receives observations of how the backend uses the Atlas API, so
+// that they can be recorded by a metrics library such as Prometheus
+// without the backend depending on it.
+type Metrics interface {
+	// ObserveRequest is called once for every HTTP request made to Atlas,
+	// including each retry. status is zero if no response was received.
+	ObserveRequest(method, path string, status int, dur time.Duration)
+}
+
+// observeRequest reports a request to the configured Metrics, if any.
+func (c *stateClient) observeRequest(req *retryablehttp.Request, resp *http.Response, dur time.Duration) {
+	if c.Metrics == nil {
+		return
+	}
+
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+
+	c.Metrics.ObserveRequest(req.Method, req.URL.Path, status, dur)
+}
@@ -0,0 +1,81 @@
+package atlas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/backend"
+)
+
+// fakeMetrics records the requests it observes.
+type fakeMetrics struct {
+	sync.Mutex
+	observations []fakeObservation
+}
+
+type fakeObservation struct {
+	Method string
+	Path   string
+	Status int
+}
+
+func (m *fakeMetrics) ObserveRequest(method, path string, status int, dur time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.observations = append(m.observations, fakeObservation{method, path, status})
+}
+
+func TestBackend_Metrics(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fakeAtlas.handler(resp, req)
+	}))
+	defer srv.Close()
+
+	metrics := new(fakeMetrics)
+	b := &Backend{Metrics: metrics}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	s, err := b.State(backend.DefaultStateName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.WriteState(s.State()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	if n := int(atomic.LoadInt32(&requests)); len(metrics.observations) != n || n == 0 {
+		t.Fatalf("expected %d observations, got: %#v", n, metrics.observations)
+	}
+
+	// Configure checks access to the organization first
+	get := metrics.observations[1]
+	expected := fakeObservation{
+		Method: "GET",
+		Path:   "/api/v1/terraform/state/someuser/some-test-remote-state",
+		Status: http.StatusOK,
+	}
+	if get != expected {
+		t.Fatalf("bad: %#v", get)
+	}
+}
@@ -0,0 +1,59 @@
+package atlas
+
+import (
+	"github.com/hashicorp/terraform/backend"
+)
+
+// OperationObserver is notified of the milestones of each operation, so
+// that tools embedding the backend can report progress without parsing the
+// CLI output. The methods are called from the goroutine running the
+// operation, so they shouldn't block for long.
+type OperationObserver interface {
+	// OperationStarted is called when an operation starts running.
+	OperationStarted(op backend.OperationType)
+
+	// RunQueued is called when the run of an operation is recorded in
+	// Atlas.
+	RunQueued(runID string)
+
+	// RunFinished is called when a run in Atlas that an operation waits on
+	// reaches a terminal status.
+	RunFinished(runID, status string)
+
+	// StatePersisted is called each time the state is written to Atlas.
+	StatePersisted(serial int64)
+
+	// OperationFinished is called when an operation stops running, with the
+	// error it failed with, if any.
+	OperationFinished(op backend.OperationType, err error)
+}
+
+// NilObserver is an OperationObserver that does nothing. Embed it to only
+// implement some of the methods.
+type NilObserver struct{}
+
+func (NilObserver) OperationStarted(backend.OperationType)         {}
+func (NilObserver) RunQueued(string)                               {}
+func (NilObserver) RunFinished(string, string)                     {}
+func (NilObserver) StatePersisted(int64)                           {}
+func (NilObserver) OperationFinished(backend.OperationType, error) {}
+
+// observer returns the configured Observer, or a NilObserver if there
+// isn't one.
+func (b *Backend) observer() OperationObserver {
+	if b.Observer == nil {
+		return NilObserver{}
+	}
+
+	return b.Observer
+}
+
+// observer returns the configured Observer, or a NilObserver if there
+// isn't one.
+func (c *stateClient) observer() OperationObserver {
+	if c.Observer == nil {
+		return NilObserver{}
+	}
+
+	return c.Observer
+}
@@ -0,0 +1,130 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// recordingObserver records the milestones it's notified of.
+type recordingObserver struct {
+	sync.Mutex
+	events []string
+}
+
+func (o *recordingObserver) record(format string, args ...interface{}) {
+	o.Lock()
+	defer o.Unlock()
+	o.events = append(o.events, fmt.Sprintf(format, args...))
+}
+
+func (o *recordingObserver) OperationStarted(op backend.OperationType) {
+	o.record("started %s", op)
+}
+
+func (o *recordingObserver) RunQueued(runID string) {
+	o.record("queued %s", runID)
+}
+
+func (o *recordingObserver) RunFinished(runID, status string) {
+	o.record("finished %s %s", runID, status)
+}
+
+func (o *recordingObserver) StatePersisted(serial int64) {
+	o.record("persisted %d", serial)
+}
+
+func (o *recordingObserver) OperationFinished(op backend.OperationType, err error) {
+	o.record("done %s %v", op, err)
+}
+
+func testObservedBackend(t *testing.T, srvURL string, o OperationObserver) *Backend {
+	b := &Backend{Observer: o}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srvURL,
+	})
+	b.ContextOpts = &terraform.ContextOpts{}
+
+	return b
+}
+
+func TestBackend_observerPlan(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	observer := new(recordingObserver)
+	b := testObservedBackend(t, srv.URL, observer)
+	testProvider(t, b, "test")
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan")
+	defer modCleanup()
+
+	op := testOperationPlan()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	expected := []string{
+		"started OperationTypePlan",
+		"queued run-1",
+		"done OperationTypePlan <nil>",
+	}
+	if !reflect.DeepEqual(observer.events, expected) {
+		t.Fatalf("bad: %#v", observer.events)
+	}
+}
+
+func TestBackend_observerApply(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	observer := new(recordingObserver)
+	b := testObservedBackend(t, srv.URL, observer)
+	testProvider(t, b, "test")
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	// The state is persisted as each resource is applied and once more at
+	// the end, so only the ends of the sequence are fixed
+	events := observer.events
+	if len(events) < 4 {
+		t.Fatalf("bad: %#v", events)
+	}
+	if events[0] != "started OperationTypeApply" || events[1] != "queued run-1" {
+		t.Fatalf("bad: %#v", events)
+	}
+	last := fmt.Sprintf("persisted %d", fakeAtlas.CurrentSerial())
+	if events[len(events)-2] != last || events[len(events)-1] != "done OperationTypeApply <nil>" {
+		t.Fatalf("bad: %#v", events)
+	}
+}
@@ -0,0 +1,67 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// ErrOrganizationAccess is returned by Configure when Atlas reports that
+// the access token isn't scoped to the organization given in name.
+type ErrOrganizationAccess struct {
+	Organization string
+}
+
+func (e *ErrOrganizationAccess) Error() string {
+	return fmt.Sprintf(
+		"The access token does not have access to organization %q. Check the\n"+
+			"organization in name, or use a token that is scoped to it.",
+		e.Organization)
+}
+
+// checkOrganizationAccess asks Atlas whether the access token has access to
+// the organization, so that a mis-scoped token fails straight away rather
+// than with a 403 partway through an operation.
+//
+// Only a definite answer is an error. The check is made once, without
+// retries, and if Atlas can't be reached, rejects the token outright or
+// doesn't know the endpoint it's skipped; any real problem is then
+// reported by the first request that needs the organization, or by
+// CheckConnection.
+func (c *stateClient) checkOrganizationAccess(ctx context.Context) error {
+	u := c.endpoints().OrganizationURL(c.User)
+	req, err := retryablehttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	c.logRequest(req.Method, req.URL, 0)
+	start := c.clock().Now()
+	resp, err := c.doOnce(ctx, req)
+	c.observeRequest(req, resp, c.clock().Now().Sub(start))
+	if err != nil {
+		log.Printf("[WARN] backend/atlas: skipping the organization access check: %s",
+			c.redactor.RedactError(err))
+		return nil
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusForbidden:
+		return &ErrOrganizationAccess{Organization: c.User}
+	default:
+		log.Printf("[WARN] backend/atlas: skipping the organization access check: HTTP %d",
+			resp.StatusCode)
+		return nil
+	}
+}
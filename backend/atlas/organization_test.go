@@ -0,0 +1,97 @@
+package atlas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestConfigure_organizationAccess(t *testing.T) {
+	cases := map[string]struct {
+		Status int
+		Err    bool
+	}{
+		"accessible":   {http.StatusOK, false},
+		"inaccessible": {http.StatusForbidden, true},
+
+		// Servers without the endpoint, and tokens that are rejected
+		// outright, are left to later requests.
+		"unknown":      {http.StatusNotFound, false},
+		"unauthorized": {http.StatusUnauthorized, false},
+	}
+
+	for name, tc := range cases {
+		var path string
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			path = req.URL.Path
+			resp.WriteHeader(tc.Status)
+		}))
+
+		b := &Backend{}
+		err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+			"access_token": "sometoken",
+			"name":         "someorg/some-test-remote-state",
+			"address":      srv.URL,
+		})))
+		srv.Close()
+
+		if path != "/api/v1/organizations/someorg" {
+			t.Fatalf("%s: bad path: %s", name, path)
+		}
+		if (err != nil) != tc.Err {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if err == nil {
+			continue
+		}
+
+		accessErr, ok := err.(*ErrOrganizationAccess)
+		if !ok || accessErr.Organization != "someorg" {
+			t.Fatalf("%s: bad: %#v", name, err)
+		}
+	}
+}
+
+func TestConfigure_organizationAccessDisabled(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		requests++
+		resp.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	// With check_organization_access off, Configure makes no requests
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someorg/some-test-remote-state",
+		"address":      srv.URL,
+
+		"check_organization_access": false,
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if requests != 0 {
+		t.Fatalf("expected no requests, got %d", requests)
+	}
+}
+
+func TestConfigure_organizationAccessUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	addr := srv.URL
+	srv.Close()
+
+	b := &Backend{}
+	err := b.Configure(terraform.NewResourceConfig(config.TestRawConfig(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someorg/some-test-remote-state",
+		"address":      addr,
+	})))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,114 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// planNameFormat is the time format of the names of saved plans.
+const planNameFormat = "20060102T150405Z"
+
+// putPlan saves a plan to Atlas, returning its ID. The plan is sent with
+// its MD5, which Atlas verifies, and is named after the time it was saved.
+func (c *stateClient) putPlan(ctx context.Context, plan *terraform.Plan) (string, error) {
+	if c.dryRun("save the plan to %s", path.Join(c.User, c.Name)) {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	if err := terraform.WritePlan(plan, &buf); err != nil {
+		return "", fmt.Errorf("Failed to encode plan: %v", err)
+	}
+	data := buf.Bytes()
+	hash := md5.Sum(data)
+
+	u := c.endpoints().PlansURL(c.User, c.Name)
+	values := u.Query()
+	values.Set("name", "plan-"+c.clock().Now().UTC().Format(planNameFormat))
+	u.RawQuery = values.Encode()
+
+	req, err := retryablehttp.NewRequest("POST", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(hash[:]))
+	req.ContentLength = int64(len(data))
+	req.Request = req.Request.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to save plan: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	default:
+		return "", c.httpError(resp)
+	}
+
+	var result struct {
+		Plan struct {
+			ID string `json:"id"`
+		} `json:"plan"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("Failed to decode saved plan: %v", err)
+	}
+	if result.Plan.ID == "" {
+		return "", fmt.Errorf("Atlas didn't return the ID of the saved plan")
+	}
+
+	return result.Plan.ID, nil
+}
+
+// VerifyPlan downloads a plan saved to Atlas and checks it against the MD5
+// Atlas recorded when it was saved, returning an *ErrPlanChecksumMismatch
+// if it was altered since. Applying a saved plan makes the same check.
+func (b *Backend) VerifyPlan(ctx context.Context, id string) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	_, err := b.stateClient.getPlan(ctx, id)
+	return err
+}
+
+// getPlan downloads a plan saved by putPlan. Like the plan of a run, it's
+// only returned if its MD5 matches the one Atlas reports for it.
+func (c *stateClient) getPlan(ctx context.Context, id string) (*terraform.Plan, error) {
+	req, err := retryablehttp.NewRequest(
+		"GET", c.endpoints().PlanURL(c.User, c.Name, id).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Request = req.Request.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download saved plan %s: %v", id, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("Atlas has no saved plan %s", id)
+	default:
+		return nil, c.httpError(resp)
+	}
+
+	return readVerifiedPlan(resp, "saved plan "+id)
+}
@@ -0,0 +1,159 @@
+package atlas
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestBackend_planSavePlan(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.savePlan = true
+	p := testProvider(t, b, "test")
+	p.DiffReturn = &terraform.InstanceDiff{
+		Attributes: map[string]*terraform.ResourceAttrDiff{
+			"id": &terraform.ResourceAttrDiff{NewComputed: true, RequiresNew: true},
+		},
+	}
+	p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationPlan()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+	if run.PlanId == "" {
+		t.Fatal("the plan should be saved")
+	}
+	if _, ok := fakeAtlas.plans[run.PlanId]; !ok {
+		t.Fatalf("plan %s not saved: %v", run.PlanId, fakeAtlas.plans)
+	}
+	if fakeAtlas.puts != 0 {
+		t.Fatal("plan shouldn't write the state")
+	}
+
+	// The saved plan is applied by its ID, without the configuration
+	op = testOperationApply()
+	op.PlanId = run.PlanId
+
+	run, err = b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = yes
+	`)
+}
+
+func TestBackend_applyPlanIdNotFound(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+
+	op := testOperationApply()
+	op.PlanId = "plan-missing"
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err == nil || !strings.Contains(run.Err.Error(), "no saved plan plan-missing") {
+		t.Fatalf("expected a missing plan error, got: %v", run.Err)
+	}
+	if p.ApplyCalled {
+		t.Fatal("apply shouldn't be called")
+	}
+}
+
+func TestBackend_VerifyPlan(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	plan := &terraform.Plan{
+		Diff: &terraform.Diff{
+			Modules: []*terraform.ModuleDiff{
+				&terraform.ModuleDiff{
+					Path:      []string{"root"},
+					Resources: map[string]*terraform.InstanceDiff{},
+				},
+			},
+		},
+	}
+	id, err := b.stateClient.putPlan(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := b.VerifyPlan(context.Background(), id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Once the stored plan is altered, it no longer verifies
+	tampered := append([]byte(nil), fakeAtlas.plans[id]...)
+	tampered[len(tampered)-1] ^= 0xff
+	fakeAtlas.plans[id] = tampered
+
+	err = b.VerifyPlan(context.Background(), id)
+	mismatch, ok := err.(*ErrPlanChecksumMismatch)
+	if !ok {
+		t.Fatalf("expected *ErrPlanChecksumMismatch, got: %#v", err)
+	}
+	if mismatch.Plan != "saved plan "+id {
+		t.Fatalf("bad: %s", mismatch.Plan)
+	}
+
+	// Nor is it applied
+	p := testProvider(t, b, "test")
+	op := testOperationApply()
+	op.PlanId = id
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if _, ok := run.Err.(*ErrPlanChecksumMismatch); !ok {
+		t.Fatalf("expected *ErrPlanChecksumMismatch, got: %#v", run.Err)
+	}
+	if p.ApplyCalled {
+		t.Fatal("apply shouldn't be called")
+	}
+}
+
+func TestBackend_VerifyPlanNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if err := b.VerifyPlan(context.Background(), "plan-1"); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+)
+
+const (
+	// Statuses of a policy check.
+	policyPassed     = "passed"
+	policyFailed     = "failed"
+	policySoftFailed = "soft_failed"
+)
+
+// PolicyCheck is the result of checking a run against a single Sentinel
+// policy.
+type PolicyCheck struct {
+	// Name is the name of the policy.
+	Name string `json:"name"`
+
+	// Status is "passed", "failed" or "soft_failed". A soft failure stops
+	// the run unless the policy is overridden.
+	Status string `json:"status"`
+
+	// Message is any explanation given by the policy.
+	Message string `json:"message,omitempty"`
+}
+
+// PolicyChecks returns the results of the policy checks of a run. If Atlas
+// has no policy checks for the run, none are returned.
+func (b *Backend) PolicyChecks(ctx context.Context, runID string) ([]PolicyCheck, error) {
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	return b.stateClient.policyChecks(ctx, runID)
+}
+
+func (c *stateClient) policyChecks(ctx context.Context, runID string) ([]PolicyCheck, error) {
+	u := c.runURL(runID)
+	u.Path = path.Join(u.Path, "policy-checks")
+
+	var result struct {
+		PolicyChecks []PolicyCheck `json:"policy_checks"`
+	}
+	status, err := c.getJSONContext(ctx, u, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return result.PolicyChecks, nil
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	case http.StatusForbidden:
+		return nil, ErrForbidden
+	default:
+		return nil, fmt.Errorf(
+			"Unexpected response reading the policy checks of run %s: HTTP %d",
+			runID, status)
+	}
+}
+
+// outputPolicyChecks outputs the results of the policy checks of a run to
+// the CLI, with failures in red.
+func (b *Backend) outputPolicyChecks(checks []PolicyCheck) {
+	if b.CLI == nil || len(checks) == 0 {
+		return
+	}
+
+	b.CLI.Output(b.Colorize().Color("\n[reset][bold]Policy checks:"))
+	softFailed := false
+	for _, check := range checks {
+		var line string
+		switch check.Status {
+		case policyPassed:
+			line = fmt.Sprintf("[reset][green]  %s: passed", check.Name)
+		case policyFailed:
+			line = fmt.Sprintf("[reset][red]  %s: failed", check.Name)
+		case policySoftFailed:
+			line = fmt.Sprintf("[reset][yellow]  %s: soft failed", check.Name)
+			softFailed = true
+		default:
+			line = fmt.Sprintf("[reset]  %s: %s", check.Name, check.Status)
+		}
+		if check.Message != "" {
+			line += ": " + check.Message
+		}
+
+		b.CLI.Output(b.Colorize().Color(line))
+	}
+
+	if softFailed {
+		b.CLI.Output(b.Colorize().Color(
+			"\n[reset][yellow]A soft failed policy stops the run until it's overridden. An owner\n" +
+				"of the organization can override it from the run's page in Atlas, after\n" +
+				"which the run continues."))
+	}
+}
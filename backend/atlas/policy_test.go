@@ -0,0 +1,159 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/mitchellh/colorstring"
+)
+
+func TestBackend_PolicyChecks(t *testing.T) {
+	checks := []PolicyCheck{
+		{Name: "require-tags", Status: "passed"},
+		{Name: "no-public-buckets", Status: "failed", Message: "bucket logs is public"},
+		{Name: "instance-size", Status: "soft_failed"},
+	}
+	fake := &fakeRuns{
+		t:            t,
+		statuses:     []string{"errored"},
+		policyChecks: checks,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	actual, err := b.PolicyChecks(context.Background(), "run-abc123")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(actual, checks) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestBackend_PolicyChecksNone(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"applied"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	actual, err := b.PolicyChecks(context.Background(), "run-abc123")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(actual) != 0 {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestBackend_outputPolicyChecks(t *testing.T) {
+	defer os.Setenv("NO_COLOR", os.Getenv("NO_COLOR"))
+	os.Unsetenv("NO_COLOR")
+
+	cases := map[string]struct {
+		Check    PolicyCheck
+		Expected string
+		Override bool
+	}{
+		"passed": {
+			PolicyCheck{Name: "require-tags", Status: "passed"},
+			"\x1b[0m\x1b[32m  require-tags: passed",
+			false,
+		},
+		"failed": {
+			PolicyCheck{Name: "no-public-buckets", Status: "failed", Message: "bucket logs is public"},
+			"\x1b[0m\x1b[31m  no-public-buckets: failed: bucket logs is public",
+			false,
+		},
+		"soft failed": {
+			PolicyCheck{Name: "instance-size", Status: "soft_failed"},
+			"\x1b[0m\x1b[33m  instance-size: soft failed",
+			true,
+		},
+	}
+
+	for name, tc := range cases {
+		ui := new(cli.MockUi)
+		b := &Backend{
+			CLI:      ui,
+			CLIColor: &colorstring.Colorize{Colors: colorstring.DefaultColors},
+		}
+
+		b.outputPolicyChecks([]PolicyCheck{tc.Check})
+
+		output := ui.OutputWriter.String()
+		if !strings.Contains(output, tc.Expected) {
+			t.Fatalf("%s: expected %q in output:\n\n%q", name, tc.Expected, output)
+		}
+		if strings.Contains(output, "overridden") != tc.Override {
+			t.Fatalf("%s: bad override message in output:\n\n%s", name, output)
+		}
+	}
+}
+
+func TestBackend_streamRunLogsPolicyChecks(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"planning", "errored"},
+		policyChecks: []PolicyCheck{
+			{Name: "no-public-buckets", Status: "failed"},
+		},
+		serverInfo: &ServerInfo{
+			Features: ServerFeatures{Sentinel: true},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.pollInterval = 10 * time.Millisecond
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	if _, err := b.streamRunLogs(context.Background(), "run-abc123"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "no-public-buckets: failed") {
+		t.Fatalf("expected the policy checks in output:\n\n%s", output)
+	}
+}
+
+func TestBackend_streamRunLogsPolicyChecksUnsupported(t *testing.T) {
+	// An older server without the version endpoint, and so without Sentinel
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"planning", "errored"},
+		policyChecks: []PolicyCheck{
+			{Name: "no-public-buckets", Status: "failed"},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.pollInterval = 10 * time.Millisecond
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	if _, err := b.streamRunLogs(context.Background(), "run-abc123"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if fake.policyReads != 0 {
+		t.Fatalf("expected no policy check reads, got %d", fake.policyReads)
+	}
+	if output := ui.OutputWriter.String(); strings.Contains(output, "no-public-buckets") {
+		t.Fatalf("expected no policy checks in output:\n\n%s", output)
+	}
+}
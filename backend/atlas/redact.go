@@ -0,0 +1,83 @@
+package atlas
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/cli"
+)
+
+// redactedValue is shown in place of sensitive values.
+const redactedValue = "***"
+
+// redactor masks known secrets, such as the access token and the values
+// of sensitive variables, in text that is about to be output.
+type redactor struct {
+	sync.Mutex
+	secrets []string
+}
+
+// Add adds secrets to be masked. Empty secrets are ignored.
+func (r *redactor) Add(secrets ...string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, s := range secrets {
+		if s != "" {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+}
+
+// Redact returns s with every secret replaced by redactedValue.
+func (r *redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, redactedValue, -1)
+	}
+
+	return s
+}
+
+// RedactError returns err with any secrets masked in its message. Errors
+// without secrets, and timeouts, keep their type so that they can still be
+// recognized.
+func (r *redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// A timeout keeps its type, so that it can still be recognized; only
+	// its URL can hold a secret.
+	if timeoutErr, ok := err.(*ErrRequestTimeout); ok {
+		redacted := *timeoutErr
+		redacted.URL = r.Redact(timeoutErr.URL)
+		return &redacted
+	}
+
+	msg := err.Error()
+	if redacted := r.Redact(msg); redacted != msg {
+		return errors.New(redacted)
+	}
+
+	return err
+}
+
+// redactUi is a cli.Ui that masks secrets in everything it outputs.
+// Colorized output is written through the Ui too, so it's covered as well.
+type redactUi struct {
+	cli.Ui
+	redactor *redactor
+}
+
+func (u *redactUi) Output(msg string) { u.Ui.Output(u.redactor.Redact(msg)) }
+func (u *redactUi) Info(msg string)   { u.Ui.Info(u.redactor.Redact(msg)) }
+func (u *redactUi) Warn(msg string)   { u.Ui.Warn(u.redactor.Redact(msg)) }
+func (u *redactUi) Error(msg string)  { u.Ui.Error(u.redactor.Redact(msg)) }
@@ -0,0 +1,92 @@
+package atlas
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/mitchellh/cli"
+	"github.com/mitchellh/colorstring"
+)
+
+func TestRedactor(t *testing.T) {
+	r := new(redactor)
+	r.Add("s3cret", "")
+
+	if actual := r.Redact("token=s3cret, again s3cret"); actual != "token=***, again ***" {
+		t.Fatalf("bad: %q", actual)
+	}
+
+	// Errors without secrets are kept as they are
+	if err := r.RedactError(ErrUnauthorized); err != ErrUnauthorized {
+		t.Fatalf("bad: %#v", err)
+	}
+	if err := r.RedactError(errors.New("bad s3cret")); err.Error() != "bad ***" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// Timeouts keep their type, with the secret masked in their URL
+	timeoutErr := &ErrRequestTimeout{Method: "GET", URL: "http://example.com/?token=s3cret"}
+	err, ok := r.RedactError(timeoutErr).(*ErrRequestTimeout)
+	if !ok || err.URL != "http://example.com/?token=***" {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	var none *redactor
+	if actual := none.Redact("s3cret"); actual != "s3cret" {
+		t.Fatalf("bad: %q", actual)
+	}
+}
+
+func TestBackend_redactOutput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// Echo the access token back, as a misbehaving proxy might
+		resp.WriteHeader(http.StatusBadGateway)
+		resp.Write([]byte("bad request with token " + req.Header.Get(atlasTokenHeader)))
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":        "sometoken",
+		"name":                "someuser/some-test-remote-state",
+		"address":             srv.URL,
+		"http_basic_user":     "user",
+		"http_basic_password": "hunter2",
+		"retry_max":           0,
+	})
+
+	ui := new(cli.MockUi)
+	err := b.CLIInit(&backend.CLIOpts{
+		CLI:      ui,
+		CLIColor: &colorstring.Colorize{Colors: colorstring.DefaultColors},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b.CLI.Output(b.Colorize().Color("[bold]token: sometoken"))
+	b.CLI.Error("password: hunter2")
+
+	_, err = b.stateClient.Get()
+	if err == nil {
+		t.Fatal("should error")
+	}
+	b.CLI.Error(err.Error())
+
+	output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+	for _, secret := range []string{"sometoken", "hunter2"} {
+		if strings.Contains(output, secret) {
+			t.Fatalf("secret %q in output:\n\n%s", secret, output)
+		}
+		if strings.Contains(err.Error(), secret) {
+			t.Fatalf("secret %q in error: %s", secret, err)
+		}
+	}
+	if !strings.Contains(output, "token: ***") || !strings.Contains(err.Error(), "token ***") {
+		t.Fatalf("bad output:\n\n%s", output)
+	}
+}
@@ -0,0 +1,157 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+const (
+	// executionModeRemote runs operations as the backend always has.
+	executionModeRemote = "remote"
+
+	// executionModeLocalApply uses the plan computed by Atlas, with its
+	// credentials, and applies it locally so that an operator can review
+	// it first. The apply needs local provider credentials.
+	executionModeLocalApply = "local-apply"
+)
+
+// remotePlan returns the plan of the latest Atlas run of the environment,
+// waiting for the run to finish planning if it hasn't already.
+func (b *Backend) remotePlan(ctx context.Context) (*terraform.Plan, *Run, error) {
+	runs, err := b.stateClient.listRuns(ctx, 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading runs from Atlas: %s", err)
+	}
+	if len(runs) == 0 {
+		return nil, nil, fmt.Errorf(
+			"The environment %s has no runs in Atlas, so there is no plan to apply.",
+			b.name)
+	}
+
+	run := &runs[0]
+	if !run.Done() {
+		if run, err = b.streamRunLogs(ctx, run.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	if run.Status != "planned" {
+		return nil, nil, fmt.Errorf(
+			"The latest Atlas run %s is %s, so there is no plan to apply.",
+			run.ID, run.Status)
+	}
+
+	plan, err := b.stateClient.getRunPlan(ctx, run.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plan, run, nil
+}
+
+// getRunPlan downloads the plan of a run. The plan is only returned if its
+// MD5 matches the one Atlas reports for it, since a local-apply applies
+// exactly what was downloaded.
+func (c *stateClient) getRunPlan(ctx context.Context, id string) (*terraform.Plan, error) {
+	u := c.runURL(id)
+	u.Path = path.Join(u.Path, "plan")
+
+	req, err := retryablehttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Request = req.Request.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download plan of run %s: %v", id, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("Atlas has no plan for run %s", id)
+	default:
+		return nil, c.httpError(resp)
+	}
+
+	return readVerifiedPlan(resp, "the plan of run "+id)
+}
+
+// ErrPlanChecksumMismatch is returned when a plan downloaded from Atlas
+// doesn't match the MD5 Atlas has for it, such as when it was altered or
+// corrupted after it was saved.
+type ErrPlanChecksumMismatch struct {
+	// Plan describes the plan, such as "saved plan plan-1".
+	Plan string
+
+	Got, Want []byte
+}
+
+func (e *ErrPlanChecksumMismatch) Error() string {
+	return fmt.Sprintf("MD5 mismatch for %s: got %x want %x", e.Plan, e.Got, e.Want)
+}
+
+// readVerifiedPlan reads a plan from the body of a response, checking it
+// against the response's Content-MD5. what describes the plan in errors.
+func readVerifiedPlan(resp *http.Response, what string) (*terraform.Plan, error) {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download %s: %v", what, err)
+	}
+
+	raw := resp.Header.Get("Content-MD5")
+	if raw == "" {
+		return nil, fmt.Errorf("Atlas didn't report the MD5 of %s", what)
+	}
+	expected, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode Content-MD5 '%s': %v", raw, err)
+	}
+	if hash := md5.Sum(data); !bytes.Equal(expected, hash[:]) {
+		return nil, &ErrPlanChecksumMismatch{Plan: what, Got: hash[:], Want: expected}
+	}
+
+	plan, err := terraform.ReadPlan(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", what, err)
+	}
+
+	return plan, nil
+}
+
+// countChanges returns the number of resources a diff adds, changes and
+// destroys. Resources that are replaced count as both added and destroyed.
+func countChanges(diff *terraform.Diff) (add, change, destroy int) {
+	if diff == nil {
+		return
+	}
+
+	for _, m := range diff.Modules {
+		for _, r := range m.Resources {
+			switch r.ChangeType() {
+			case terraform.DiffCreate:
+				add++
+			case terraform.DiffUpdate:
+				change++
+			case terraform.DiffDestroy:
+				destroy++
+			case terraform.DiffDestroyCreate:
+				add++
+				destroy++
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,212 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/cli"
+)
+
+func TestStateClient_getRunPlan(t *testing.T) {
+	plan := &terraform.Plan{
+		Diff: &terraform.Diff{
+			Modules: []*terraform.ModuleDiff{
+				&terraform.ModuleDiff{
+					Path:      []string{"root"},
+					Resources: map[string]*terraform.InstanceDiff{},
+				},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := terraform.WritePlan(plan, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	hash := md5.Sum(buf.Bytes())
+
+	cases := map[string]struct {
+		MD5 string
+		Err string
+	}{
+		"valid":    {base64.StdEncoding.EncodeToString(hash[:]), ""},
+		"mismatch": {base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")), "MD5 mismatch"},
+		"missing":  {"", "didn't report the MD5"},
+	}
+
+	for name, tc := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/api/v1/terraform/runs/run-abc123/plan" {
+				resp.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if tc.MD5 != "" {
+				resp.Header().Set("Content-MD5", tc.MD5)
+			}
+			resp.Write(buf.Bytes())
+		}))
+
+		b := testBackend(t, srv)
+		actual, err := b.stateClient.getRunPlan(context.Background(), "run-abc123")
+		srv.Close()
+
+		if tc.Err == "" {
+			if err != nil {
+				t.Fatalf("%s: err: %s", name, err)
+			}
+			if actual == nil || actual.Diff == nil || len(actual.Diff.Modules) != 1 {
+				t.Fatalf("%s: bad: %#v", name, actual)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.Err) {
+			t.Fatalf("%s: expected error containing %q, got: %v", name, tc.Err, err)
+		}
+	}
+}
+
+func TestBackend_applyLocalApply(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", fakeAtlas.handler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.executionMode = executionModeLocalApply
+	p := testProvider(t, b, "test")
+	p.DiffReturn = &terraform.InstanceDiff{
+		Attributes: map[string]*terraform.ResourceAttrDiff{
+			"id": &terraform.ResourceAttrDiff{NewComputed: true, RequiresNew: true},
+		},
+	}
+	p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	// The plan Atlas made for the run
+	opts := *b.ContextOpts
+	opts.Module = mod
+	opts.State = terraform.NewState()
+	tfCtx, err := terraform.NewContext(&opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	plan, err := tfCtx.Plan()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := terraform.WritePlan(plan, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	hash := md5.Sum(buf.Bytes())
+
+	mux.HandleFunc("/api/v1/environments/someuser/some-test-remote-state/runs", func(resp http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"runs": []Run{{ID: "run-abc123", Status: "planned"}},
+		})
+	})
+	mux.HandleFunc("/api/v1/terraform/runs/run-abc123/plan", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(hash[:]))
+		resp.Write(buf.Bytes())
+	})
+
+	input := &terraform.MockUIInput{
+		InputReturnMap: map[string]string{"approve": "yes"},
+	}
+	op := testOperationApply()
+	op.AutoApprove = false
+	op.UIIn = input
+	b.OpInput = true
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	if output := ui.OutputWriter.String(); !strings.Contains(output, "Applying the plan of Atlas run run-abc123") {
+		t.Fatalf("bad output:\n\n%s", output)
+	}
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+	if !input.InputCalled {
+		t.Fatal("approval should be asked for")
+	}
+	if !strings.Contains(input.InputOpts.Description, "1 to add, 0 to change, 0 to destroy") {
+		t.Fatalf("bad description: %s", input.InputOpts.Description)
+	}
+	if op.Plan != nil {
+		t.Fatal("the operation should not be modified")
+	}
+
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = yes
+	`)
+}
+
+func TestBackend_remotePlanNotPlanned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"runs": []Run{{ID: "run-abc123", Status: "errored"}},
+		})
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	_, _, err := b.remotePlan(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "run-abc123 is errored") {
+		t.Fatalf("expected error, got: %v", err)
+	}
+}
+
+func TestCountChanges(t *testing.T) {
+	diff := &terraform.Diff{
+		Modules: []*terraform.ModuleDiff{
+			&terraform.ModuleDiff{
+				Path: []string{"root"},
+				Resources: map[string]*terraform.InstanceDiff{
+					"test_instance.create": &terraform.InstanceDiff{
+						Attributes: map[string]*terraform.ResourceAttrDiff{
+							"id": &terraform.ResourceAttrDiff{NewComputed: true, RequiresNew: true},
+						},
+					},
+					"test_instance.update": &terraform.InstanceDiff{
+						Attributes: map[string]*terraform.ResourceAttrDiff{
+							"name": &terraform.ResourceAttrDiff{Old: "a", New: "b"},
+						},
+					},
+					"test_instance.destroy": &terraform.InstanceDiff{Destroy: true},
+					"test_instance.replace": &terraform.InstanceDiff{
+						Destroy: true,
+						Attributes: map[string]*terraform.ResourceAttrDiff{
+							"ami": &terraform.ResourceAttrDiff{Old: "a", New: "b", RequiresNew: true},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	add, change, destroy := countChanges(diff)
+	if add != 2 || change != 1 || destroy != 2 {
+		t.Fatalf("bad: %d to add, %d to change, %d to destroy", add, change, destroy)
+	}
+}
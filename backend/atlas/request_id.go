@@ -0,0 +1,72 @@
+package atlas
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/hashicorp/go-uuid"
+)
+
+const (
+	// requestIDHeader is the header Atlas identifies each response with,
+	// which support needs to find the request.
+	requestIDHeader = "X-Request-Id"
+
+	// correlationIDHeader is the header identifying the operation that a
+	// request is made for, so that all the requests of an operation can be
+	// found together.
+	correlationIDHeader = "X-Correlation-Id"
+)
+
+// correlationID is the ID of the running operation, if any. It's shared by
+// the copies of the client made for each environment.
+type correlationID struct {
+	sync.Mutex
+	id string
+}
+
+// Start sets a new ID, for a new operation. Not being able to generate one
+// isn't fatal; the requests are then sent without one.
+func (c *correlationID) Start() {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		log.Printf("[WARN] backend/atlas: failed to generate a correlation ID: %s", err)
+	}
+
+	c.Set(id)
+}
+
+// Set sets the ID sent with each request. An empty ID stops it being sent.
+func (c *correlationID) Set(id string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.id = id
+}
+
+// Get returns the ID to send with each request, if any.
+func (c *correlationID) Get() string {
+	if c == nil {
+		return ""
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	return c.id
+}
+
+// withRequestID returns err with the request ID of the response that caused
+// it, if Atlas sent one.
+func withRequestID(resp *http.Response, err error) error {
+	id := resp.Header.Get(requestIDHeader)
+	if id == "" {
+		return err
+	}
+
+	return fmt.Errorf("Atlas request failed (request id: %s): %s", id, err)
+}
@@ -0,0 +1,90 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/config/module"
+)
+
+func TestBackend_requestIDInError(t *testing.T) {
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set(requestIDHeader, "abc123")
+		http.Error(resp, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	_, err := b.ListRuns(context.Background(), 1)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "Atlas request failed (request id: abc123): HTTP error: 400") {
+		t.Fatalf("request id missing from error: %s", err)
+	}
+}
+
+func TestBackend_correlationID(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	var lock sync.Mutex
+	var ids []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		ids = append(ids, req.Header.Get(correlationIDHeader))
+		lock.Unlock()
+		fakeAtlas.handler(resp, req)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	testProvider(t, b, "test")
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan")
+	defer modCleanup()
+
+	plan := func() []string {
+		ids = nil
+
+		op := testOperationPlan()
+		op.Module = mod
+		op.LockState = true
+		run, err := b.Operation(context.Background(), op)
+		if err != nil {
+			t.Fatalf("bad: %s", err)
+		}
+		<-run.Done()
+		if run.Err != nil {
+			t.Fatalf("err: %s", run.Err)
+		}
+
+		return ids
+	}
+
+	// Every request of an operation has the same ID
+	first := plan()
+	if len(first) < 2 {
+		t.Fatalf("expected several requests, got: %v", first)
+	}
+	for _, id := range first {
+		if id == "" || id != first[0] {
+			t.Fatalf("requests should share an ID: %v", first)
+		}
+	}
+
+	// The next operation has its own
+	if second := plan(); second[0] == "" || second[0] == first[0] {
+		t.Fatalf("operations should have their own IDs: %s, %s", first[0], second[0])
+	}
+
+	// Requests outside of an operation have none
+	ids = nil
+	if _, err := b.FetchState(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(ids) != 1 || ids[0] != "" {
+		t.Fatalf("unexpected correlation ID: %v", ids)
+	}
+}
@@ -0,0 +1,174 @@
+package atlas
+
+import (
+	"crypto/x509"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRetryMax is how many times a failed request is retried if
+	// retry_max isn't set.
+	defaultRetryMax = 3
+
+	// defaultRetryWaitMin and defaultRetryWaitMax bound the wait between
+	// retries if retry_wait_min and retry_wait_max aren't set.
+	defaultRetryWaitMin = 1 * time.Second
+	defaultRetryWaitMax = 30 * time.Second
+)
+
+// clock abstracts time so that the retry schedule can be tested.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Sleep waits for the given duration.
+	Sleep(time.Duration)
+
+	// After returns a channel that receives the time once the given
+	// duration has passed.
+	After(time.Duration) <-chan time.Time
+
+	// Jitter returns a random number in [0.0,1.0) used to spread out
+	// retries.
+	Jitter() float64
+}
+
+// realClock is a clock using real time.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Jitter() float64                        { return rand.Float64() }
+
+// shouldRetry returns true if the given request should be retried after
+// receiving the given response or error.
+//
+// Idempotent requests are retried on connection errors and on server
+// errors. All other requests are only retried on connection errors, since
+// a server error may have been returned after the request took effect.
+// Any request that was rate limited is retried, since it wasn't processed.
+// A request whose context has ended, such as by an interrupt, is never
+// retried.
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		if err, ok := err.(*url.Error); ok {
+			// don't bother retrying once the request has timed out
+			if err.Timeout() {
+				return false
+			}
+
+			// don't bother retrying if the certs don't match
+			if _, ok := err.Err.(x509.UnknownAuthorityError); ok {
+				return false
+			}
+		}
+
+		// Other errors, such as an *ErrRequestTimeout, aren't connection
+		// errors and won't succeed on retry.
+		_, ok := err.(*url.Error)
+		return ok
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if !isIdempotent(req.Method) {
+		return false
+	}
+
+	return resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+// isIdempotent returns true if requests with the given method can safely
+// be repeated.
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	default:
+		return false
+	}
+}
+
+// retryBackoff returns how long to wait before the retry following the
+// given attempt, counting from zero, with the default bounds.
+func retryBackoff(attempt int, jitter float64) time.Duration {
+	return backoff(attempt, jitter, defaultRetryWaitMin, defaultRetryWaitMax)
+}
+
+// backoff returns how long to wait before the retry following the given
+// attempt, counting from zero. The wait doubles with each attempt from min
+// up to max, and jitter in [0.0,1.0) adds up to half again on top of it.
+// The result is always within [min, max].
+func backoff(attempt int, jitter float64, min, max time.Duration) time.Duration {
+	wait := float64(min) * math.Pow(2, float64(attempt))
+	if wait > float64(max) {
+		wait = float64(max)
+	}
+
+	wait += wait / 2 * jitter
+	switch {
+	case wait > float64(max):
+		return max
+	case wait < float64(min):
+		return min
+	default:
+		return time.Duration(wait)
+	}
+}
+
+// retryWait returns how long to wait before the retry following the given
+// attempt, within the client's RetryWaitMin and RetryWaitMax.
+func (c *stateClient) retryWait(attempt int) time.Duration {
+	min, max := c.RetryWaitMin, c.RetryWaitMax
+	if min <= 0 {
+		min = defaultRetryWaitMin
+	}
+	if max <= 0 {
+		max = defaultRetryWaitMax
+	}
+
+	return backoff(attempt, c.clock().Jitter(), min, max)
+}
+
+// retryAfter returns the wait requested by the Retry-After header of a rate
+// limited response, which is either a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		wait := t.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+
+		return wait, true
+	}
+
+	return 0, false
+}
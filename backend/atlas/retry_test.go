@@ -0,0 +1,473 @@
+package atlas
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	cases := []struct {
+		Attempt  int
+		Jitter   float64
+		Expected time.Duration
+	}{
+		{0, 0, 1 * time.Second},
+		{0, 0.5, 1250 * time.Millisecond},
+		{1, 0, 2 * time.Second},
+		{2, 0, 4 * time.Second},
+		{3, 1, 12 * time.Second},
+		{4, 1, 24 * time.Second},
+		{5, 0.5, 30 * time.Second},
+		{10, 0, 30 * time.Second},
+		{10, 1, 30 * time.Second},
+	}
+
+	for _, tc := range cases {
+		actual := retryBackoff(tc.Attempt, tc.Jitter)
+		if actual != tc.Expected {
+			t.Fatalf("attempt %d, jitter %f: expected %s, got %s",
+				tc.Attempt, tc.Jitter, tc.Expected, actual)
+		}
+	}
+}
+
+func TestBackoffBounds(t *testing.T) {
+	min, max := 200*time.Millisecond, 5*time.Second
+	for attempt := 0; attempt < 20; attempt++ {
+		for _, jitter := range []float64{0, 0.5, 0.999} {
+			capped := min << uint(attempt)
+			if capped > max || capped <= 0 {
+				capped = max
+			}
+
+			wait := backoff(attempt, jitter, min, max)
+			if wait < capped || wait > capped+capped/2 || wait < min || wait > max {
+				t.Fatalf("attempt %d, jitter %f: %s is outside [%s, %s]",
+					attempt, jitter, wait, capped, capped+capped/2)
+			}
+		}
+	}
+
+	// Once capped, the wait no longer grows
+	if backoff(10, 0, min, max) != backoff(19, 0, min, max) {
+		t.Fatal("the wait should be capped")
+	}
+}
+
+func TestBackoffMin(t *testing.T) {
+	min, max := 200*time.Millisecond, 5*time.Second
+	for _, jitter := range []float64{0, 0.5, 0.999} {
+		if wait := backoff(0, jitter, min, max); wait < min {
+			t.Fatalf("jitter %f: %s is less than the minimum %s", jitter, wait, min)
+		}
+	}
+
+	// A minimum equal to the maximum leaves no room for jitter
+	if wait := backoff(0, 0.999, max, max); wait != max {
+		t.Fatalf("expected %s, got %s", max, wait)
+	}
+}
+
+func TestStateClient_retryWait(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 3 {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":   "sometoken",
+		"name":           "someuser/some-test-remote-state",
+		"address":        srv.URL,
+		"retry_wait_min": "100ms",
+		"retry_wait_max": "300ms",
+	})
+	clock := new(fakeClock)
+	b.stateClient.Clock = clock
+
+	if _, err := b.stateClient.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Without jitter, each wait doubles from retry_wait_min up to
+	// retry_wait_max
+	expected := []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Fatalf("expected sleeps %v, got %v", expected, clock.sleeps)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	connErr := &url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("connection reset")}
+
+	cases := []struct {
+		Method   string
+		Status   int
+		Err      error
+		Expected bool
+	}{
+		{"GET", 200, nil, false},
+		{"GET", 404, nil, false},
+		{"GET", 500, nil, true},
+		{"GET", 503, nil, true},
+		{"GET", 501, nil, false},
+		{"GET", 0, connErr, true},
+		{"GET", 0, &ErrRequestTimeout{}, false},
+		{"GET", 429, nil, true},
+		{"PUT", 429, nil, true},
+		{"PUT", 503, nil, false},
+		{"PUT", 0, connErr, true},
+		{"DELETE", 500, nil, false},
+	}
+
+	for _, tc := range cases {
+		var resp *http.Response
+		if tc.Err == nil {
+			resp = &http.Response{StatusCode: tc.Status}
+		}
+
+		req := &http.Request{Method: tc.Method}
+		actual := shouldRetry(req, resp, tc.Err)
+		if actual != tc.Expected {
+			t.Fatalf("%s %d %v: expected %t", tc.Method, tc.Status, tc.Err, tc.Expected)
+		}
+	}
+}
+
+func TestShouldRetry_contextDone(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	cases := map[string]struct {
+		Ctx context.Context
+		Err error
+	}{
+		"cancelled": {cancelled, context.Canceled},
+		"expired":   {expired, context.DeadlineExceeded},
+	}
+
+	for name, tc := range cases {
+		req := (&http.Request{Method: "GET"}).WithContext(tc.Ctx)
+		err := &url.Error{Op: "Get", URL: "http://example.com", Err: tc.Err}
+		if shouldRetry(req, nil, err) {
+			t.Fatalf("%s: the request shouldn't be retried", name)
+		}
+
+		// Not even a server error is retried once the context has ended
+		if shouldRetry(req, &http.Response{StatusCode: 503}, nil) {
+			t.Fatalf("%s: the request shouldn't be retried", name)
+		}
+	}
+}
+
+func TestStateClient_retry(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		resp.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	clock := new(fakeClock)
+	b.stateClient.Clock = clock
+
+	payload, err := b.stateClient.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if payload != nil {
+		t.Fatalf("bad: %#v", payload)
+	}
+
+	expected := []time.Duration{retryBackoff(0, 0), retryBackoff(1, 0)}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Fatalf("expected sleeps %v, got %v", expected, clock.sleeps)
+	}
+}
+
+func TestStateClient_retryCancelled(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The context ends during the first wait, which never finishes
+	b.stateClient.Clock = &cancelClock{cancel: cancel}
+
+	req, err := retryablehttp.NewRequest("GET", b.stateClient.url().String(), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+
+	if _, err := b.stateClient.do(req); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+}
+
+func TestStateClient_retryExhausted(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		resp.WriteHeader(http.StatusBadGateway)
+		resp.Write([]byte("upstream unavailable"))
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.stateClient.Clock = new(fakeClock)
+
+	_, err := b.stateClient.Get()
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), "502") || !strings.Contains(err.Error(), "upstream unavailable") {
+		t.Fatalf("expected the last response in the error, got: %s", err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != defaultRetryMax+1 {
+		t.Fatalf("expected %d requests, got %d", defaultRetryMax+1, n)
+	}
+}
+
+func TestStateClient_retryWrite(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	clock := new(fakeClock)
+	b.stateClient.Clock = clock
+	b.stateClient.Force = true
+
+	s := terraform.NewState()
+	if err := b.stateClient.Put(testStateBytes(t, s)); err == nil {
+		t.Fatal("should error")
+	}
+
+	// Server errors on writes must not be retried
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+	if len(clock.sleeps) != 0 {
+		t.Fatalf("bad: %v", clock.sleeps)
+	}
+}
+
+func TestStateClient_retryWriteIdempotencyKey(t *testing.T) {
+	var lock sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		keys = append(keys, req.Header.Get(idempotencyKeyHeader))
+
+		// The first attempt at each write is throttled
+		if len(keys)%2 == 1 {
+			resp.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	b.stateClient.Clock = new(fakeClock)
+	b.stateClient.Force = true
+
+	s := terraform.NewState()
+	for i := 0; i < 2; i++ {
+		if err := b.stateClient.Put(testStateBytes(t, s)); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if len(keys) != 4 {
+		t.Fatalf("expected 4 requests, got: %v", keys)
+	}
+	for _, k := range keys {
+		if k == "" {
+			t.Fatalf("expected an idempotency key on every write, got: %v", keys)
+		}
+	}
+	if keys[0] != keys[1] || keys[2] != keys[3] {
+		t.Fatalf("retries should send the same key: %v", keys)
+	}
+	if keys[0] == keys[2] {
+		t.Fatalf("writes should send different keys: %v", keys)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		Status   int
+		Header   string
+		Expected time.Duration
+		OK       bool
+	}{
+		{429, "120", 120 * time.Second, true},
+		{429, "0", 0, true},
+		{429, now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{429, now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{429, "", 0, false},
+		{429, "soon", 0, false},
+		{429, "-1", 0, false},
+		{503, "120", 0, false},
+	}
+
+	for _, tc := range cases {
+		resp := &http.Response{StatusCode: tc.Status, Header: make(http.Header)}
+		if tc.Header != "" {
+			resp.Header.Set("Retry-After", tc.Header)
+		}
+
+		actual, ok := retryAfter(resp, now)
+		if actual != tc.Expected || ok != tc.OK {
+			t.Fatalf("%d %q: expected %s, %t, got %s, %t",
+				tc.Status, tc.Header, tc.Expected, tc.OK, actual, ok)
+		}
+	}
+}
+
+func TestStateClient_retryAfter(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			resp.Header().Set("Retry-After", "7")
+			resp.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			// No header falls back to the backoff schedule
+			resp.WriteHeader(http.StatusTooManyRequests)
+		default:
+			resp.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	clock := new(fakeClock)
+	b.stateClient.Clock = clock
+
+	// Rate limited writes are retried too
+	s := terraform.NewState()
+	if err := b.stateClient.Put(testStateBytes(t, s)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []time.Duration{7 * time.Second, retryBackoff(1, 0)}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Fatalf("expected sleeps %v, got %v", expected, clock.sleeps)
+	}
+}
+
+func TestStateClient_retryAfterCapped(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		resp.Header().Set("Retry-After", "20")
+		resp.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	clock := new(fakeClock)
+	b.stateClient.Clock = clock
+
+	_, err := b.stateClient.Get()
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("bad: %v", err)
+	}
+
+	// The total wait is capped at the 30s default timeout
+	expected := []time.Duration{20 * time.Second, 10 * time.Second}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Fatalf("expected sleeps %v, got %v", expected, clock.sleeps)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
+
+// fakeClock is a clock that records sleeps instead of waiting, and never
+// adds jitter. Sleeping advances its time.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Jitter() float64 { return 0 }
+
+// cancelClock is a fakeClock whose waits never finish, calling cancel
+// instead, to test being interrupted while waiting.
+type cancelClock struct {
+	fakeClock
+
+	cancel func()
+}
+
+func (c *cancelClock) After(d time.Duration) <-chan time.Time {
+	c.cancel()
+	return nil
+}
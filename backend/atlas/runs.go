@@ -0,0 +1,251 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// Run is a Terraform run executed by Atlas.
+type Run struct {
+	ID string `json:"id"`
+
+	// Type is the kind of run, such as "plan" or "apply".
+	Type string `json:"type,omitempty"`
+
+	// Status is the status of the run as reported by Atlas. Statuses that
+	// aren't known to Terraform are kept as they are.
+	Status string `json:"status"`
+
+	// TriggeredBy is the username of whoever started the run, and Message
+	// describes why it was started.
+	TriggeredBy string `json:"triggered_by,omitempty"`
+	Message     string `json:"message,omitempty"`
+
+	// CreatedAt is when the run was started, and UpdatedAt when its status
+	// last changed.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Done returns true if the run has reached a terminal status and will not
+// progress any further.
+func (r *Run) Done() bool {
+	switch r.Status {
+	case "applied", "planned", "errored", "canceled", "discarded":
+		return true
+	default:
+		return false
+	}
+}
+
+// ListRuns returns the runs of the configured environment, newest first,
+// following pagination until limit runs have been collected. If limit is
+// zero or less, all the runs are returned.
+func (b *Backend) ListRuns(ctx context.Context, limit int) ([]Run, error) {
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	return b.stateClient.listRuns(ctx, limit)
+}
+
+func (c *stateClient) listRuns(ctx context.Context, limit int) ([]Run, error) {
+	var result []Run
+
+	next := c.readEndpoints().RunsURL(c.User, c.Name)
+	for next != nil {
+		var page struct {
+			Runs []Run `json:"runs"`
+		}
+
+		header, err := c.getJSONPage(ctx, next, &page)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list runs: %v", err)
+		}
+		result = append(result, page.Runs...)
+
+		if limit > 0 && len(result) >= limit {
+			return result[:limit], nil
+		}
+
+		next, err = nextPage(next, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// getRun returns the current status of a run.
+func (c *stateClient) getRun(ctx context.Context, id string) (*Run, error) {
+	var result struct {
+		Run *Run `json:"run"`
+	}
+	if _, err := c.getJSONPage(ctx, c.runURL(id), &result); err != nil {
+		return nil, fmt.Errorf("Failed to read run %s: %v", id, err)
+	}
+	if result.Run == nil {
+		return nil, fmt.Errorf("Failed to read run %s: empty response", id)
+	}
+
+	return result.Run, nil
+}
+
+// getRunLog returns the log output of a run starting at the given byte
+// offset.
+func (c *stateClient) getRunLog(ctx context.Context, id string, offset int) ([]byte, error) {
+	u := c.runURL(id)
+	u.Path = path.Join(u.Path, "log")
+	values := u.Query()
+	values.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = values.Encode()
+
+	req, err := retryablehttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read log for run %s: %v", id, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, c.httpError(resp)
+	}
+}
+
+// cancelRun asks Atlas to cancel a run. It returns true if the request was
+// accepted, and false if the run had already finished, in which case there
+// is nothing to cancel.
+func (c *stateClient) cancelRun(ctx context.Context, id string) (bool, error) {
+	if c.dryRun("cancel run %s", id) {
+		return true, nil
+	}
+
+	u := c.runURL(id)
+	u.Path = path.Join(u.Path, "cancel")
+
+	req, err := retryablehttp.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return false, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, fmt.Errorf("Failed to cancel run %s: %v", id, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	default:
+		return false, c.httpError(resp)
+	}
+}
+
+// runRequest describes a run of the backend to record in Atlas.
+type runRequest struct {
+	// Type is the kind of run, "plan" or "apply".
+	Type string `json:"type"`
+
+	// Message describes the run, such as the commit it's for.
+	Message string `json:"message"`
+
+	Destroy bool `json:"is_destroy"`
+
+	// Targets are the resource addresses the run is limited to, if any.
+	Targets []string `json:"targets,omitempty"`
+
+	// Parallelism limits the number of concurrent operations of the run.
+	Parallelism int `json:"parallelism,omitempty"`
+
+	// TerraformVersion is the version of Terraform for the run, if it
+	// shouldn't use the environment's default.
+	TerraformVersion string `json:"terraform_version,omitempty"`
+}
+
+// createRun records a run of the environment in Atlas so that it's listed
+// with the environment's other runs. If the Atlas server doesn't support
+// recording runs, nil is returned.
+func (c *stateClient) createRun(ctx context.Context, r *runRequest) (*Run, error) {
+	if c.dryRun("record the %s run of %s", r.Type, path.Join(c.User, c.Name)) {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"run": r})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode run: %v", err)
+	}
+
+	req, err := retryablehttp.NewRequest("POST", c.runsURL().String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = int64(len(body))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create run: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, nil
+	case http.StatusUnprocessableEntity:
+		if r.TerraformVersion != "" {
+			return nil, terraformVersionError(resp, r.TerraformVersion)
+		}
+		return nil, c.httpError(resp)
+	default:
+		return nil, c.httpError(resp)
+	}
+
+	var result struct {
+		Run *Run `json:"run"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Failed to decode run: %v", err)
+	}
+
+	return result.Run, nil
+}
+
+// runsURL returns the URL listing the runs of the environment.
+func (c *stateClient) runsURL() *url.URL {
+	return c.endpoints().RunsURL(c.User, c.Name)
+}
+
+// runURL returns the URL of a run.
+func (c *stateClient) runURL(id string) *url.URL {
+	return c.endpoints().RunURL(id)
+}
@@ -0,0 +1,450 @@
+package atlas
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/hashicorp/terraform/config/module"
+)
+
+func TestStateClient_getRun(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"planning"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	run, err := b.stateClient.getRun(context.Background(), "run-abc123")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if run.ID != "run-abc123" {
+		t.Fatalf("bad: %#v", run)
+	}
+	if run.Status != "planning" {
+		t.Fatalf("bad: %#v", run)
+	}
+	if run.Done() {
+		t.Fatal("run should not be done")
+	}
+}
+
+func TestStateClient_getRunLog(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"planning"},
+		log:      "hello\nworld\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if _, err := b.stateClient.getRun(context.Background(), "run-abc123"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	log, err := b.stateClient.getRunLog(context.Background(), "run-abc123", 6)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(log) != "world\n" {
+		t.Fatalf("bad: %q", log)
+	}
+
+	log, err = b.stateClient.getRunLog(context.Background(), "run-abc123", 12)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(log) != 0 {
+		t.Fatalf("bad: %q", log)
+	}
+}
+
+func TestStateClient_getRunCancelled(t *testing.T) {
+	// Atlas never answers, so only the context can end the requests
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	for name, get := range map[string]func(context.Context) error{
+		"run": func(ctx context.Context) error {
+			_, err := b.stateClient.getRun(ctx, "run-abc123")
+			return err
+		},
+		"log": func(ctx context.Context) error {
+			_, err := b.stateClient.getRunLog(ctx, "run-abc123", 0)
+			return err
+		},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- get(ctx) }()
+		select {
+		case err := <-errCh:
+			if err == nil {
+				t.Fatalf("%s: expected an error", name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the request wasn't cancelled", name)
+		}
+	}
+}
+
+func TestBackend_ListRuns(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if req.URL.Path != "/api/v1/environments/someuser/some-test-remote-state/runs" {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch req.URL.Query().Get("page") {
+		case "":
+			resp.Header().Set("Link", `<?page=2>; rel="next"`)
+			resp.Write([]byte(`{"runs": [
+				{"id": "run-3", "type": "apply", "status": "applying", "triggered_by": "alice",
+				 "message": "Deploy abc123",
+				 "created_at": "2017-03-01T12:00:00Z", "updated_at": "2017-03-01T12:05:00Z"},
+				{"id": "run-2", "type": "plan", "status": "policy_override", "triggered_by": "bob",
+				 "created_at": "2017-02-01T12:00:00Z", "updated_at": "2017-02-01T12:05:00Z"}
+			]}`))
+		case "2":
+			resp.Write([]byte(`{"runs": [
+				{"id": "run-1", "type": "apply", "status": "applied", "triggered_by": "alice",
+				 "created_at": "2017-01-01T12:00:00Z", "updated_at": "2017-01-01T12:05:00Z"}
+			]}`))
+		default:
+			resp.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	runs, err := b.ListRuns(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var ids []string
+	for _, r := range runs {
+		ids = append(ids, r.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"run-3", "run-2", "run-1"}) {
+		t.Fatalf("bad: %v", ids)
+	}
+
+	first := runs[0]
+	if first.Type != "apply" || first.TriggeredBy != "alice" || first.Message != "Deploy abc123" || first.Done() {
+		t.Fatalf("bad: %#v", first)
+	}
+	if !first.CreatedAt.Equal(time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)) ||
+		!first.UpdatedAt.Equal(time.Date(2017, 3, 1, 12, 5, 0, 0, time.UTC)) {
+		t.Fatalf("bad timestamps: %#v", first)
+	}
+
+	// Unknown statuses are kept as they are
+	if runs[1].Status != "policy_override" {
+		t.Fatalf("bad: %#v", runs[1])
+	}
+
+	// The second page shouldn't be requested once the limit is reached
+	atomic.StoreInt32(&requests, 0)
+	runs, err = b.ListRuns(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("bad: %#v", runs)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+}
+
+func TestBackend_ListRunsReadAddress(t *testing.T) {
+	var primaryRuns int32
+	primary := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&primaryRuns, 1)
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+
+	replica := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/environments/someuser/some-test-remote-state/runs" {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resp.Write([]byte(`{"runs": [{"id": "run-1", "type": "plan", "status": "planned"}]}`))
+	}))
+	defer replica.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      primary.URL,
+		"read_address": replica.URL,
+	})
+
+	runs, err := b.ListRuns(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(runs) != 1 || runs[0].ID != "run-1" {
+		t.Fatalf("bad: %#v", runs)
+	}
+	if n := atomic.LoadInt32(&primaryRuns); n != 0 {
+		t.Fatalf("expected no requests to the primary, got %d", n)
+	}
+}
+
+func TestNextPage_pagination(t *testing.T) {
+	current, _ := url.Parse("https://atlas.example.com/api/v1/environments/a/b/runs?page=1")
+
+	header := http.Header{}
+	header.Set("X-Pagination", `{"current_page": 1, "next_page": 2, "total_pages": 2}`)
+	next, err := nextPage(current, header)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "https://atlas.example.com/api/v1/environments/a/b/runs?page=2"
+	if next == nil || next.String() != expected {
+		t.Fatalf("expected %q, got %v", expected, next)
+	}
+
+	header.Set("X-Pagination", `{"current_page": 2, "next_page": null, "total_pages": 2}`)
+	next, err = nextPage(current, header)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if next != nil {
+		t.Fatalf("expected no next page, got %s", next)
+	}
+}
+
+// fakeRuns is a fake Atlas server for the run API. Each request for the
+// status of a run returns the next of the given statuses, repeating the
+// last one once they are exhausted. The log is revealed one line per
+// status poll so that streaming can be observed. Once the run is canceled,
+// its status is "canceled".
+type fakeRuns struct {
+	t *testing.T
+
+	sync.Mutex
+	statuses []string
+	log      string
+	polls    int
+	cancels  int
+	canceled bool
+
+	// policyChecks are the policy checks of the run. If nil, the run has
+	// none.
+	policyChecks []PolicyCheck
+	policyReads  int
+
+	// costEstimate is the cost estimate of the run. If nil, the run has
+	// none.
+	costEstimate *CostEstimate
+
+	// serverInfo is reported by the version endpoint. If nil, the server
+	// is an older one without it.
+	serverInfo *ServerInfo
+	infoReads  int
+}
+
+func (f *fakeRuns) handler(resp http.ResponseWriter, req *http.Request) {
+	if req.Header.Get(atlasTokenHeader) == "" {
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if req.URL.Path == "/api/v1/version" {
+		f.infoReads++
+		if f.serverInfo == nil {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(resp).Encode(f.serverInfo)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/v1/terraform/runs/"), "/")
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1 && req.Method == "GET":
+		status := f.statuses[len(f.statuses)-1]
+		if f.polls < len(f.statuses) {
+			status = f.statuses[f.polls]
+		}
+		if f.canceled {
+			status = "canceled"
+		}
+		f.polls++
+
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"run": &Run{ID: id, Status: status},
+		})
+
+	case len(parts) == 2 && parts[1] == "policy-checks" && req.Method == "GET":
+		f.policyReads++
+		if f.policyChecks == nil {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"policy_checks": f.policyChecks,
+		})
+
+	case len(parts) == 2 && parts[1] == "cost-estimate" && req.Method == "GET":
+		if f.costEstimate == nil {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"cost_estimate": f.costEstimate,
+		})
+
+	case len(parts) == 2 && parts[1] == "cancel" && req.Method == "POST":
+		f.cancels++
+		run := &Run{Status: f.statuses[len(f.statuses)-1]}
+		if f.polls < len(f.statuses) {
+			run.Status = f.statuses[f.polls]
+		}
+		if f.canceled || run.Done() {
+			resp.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		f.canceled = true
+		resp.WriteHeader(http.StatusAccepted)
+
+	case len(parts) == 2 && parts[1] == "log" && req.Method == "GET":
+		offset, err := strconv.Atoi(req.URL.Query().Get("offset"))
+		if err != nil {
+			f.t.Fatalf("bad offset: %s", err)
+		}
+
+		// Only reveal as many lines as there have been polls, unless
+		// the run is done.
+		log := f.log
+		if f.polls < len(f.statuses) {
+			lines := strings.SplitAfter(f.log, "\n")
+			if f.polls < len(lines) {
+				log = strings.Join(lines[:f.polls], "")
+			}
+		}
+		if offset >= len(log) {
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		resp.Write([]byte(log[offset:]))
+
+	default:
+		resp.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBackend_runMessage(t *testing.T) {
+	cases := map[string]struct {
+		Config   map[string]interface{}
+		Expected string
+	}{
+		"default": {
+			map[string]interface{}{},
+			"Triggered via Terraform CLI",
+		},
+		"configured": {
+			map[string]interface{}{"run_message": "Deploy abc123 (#42)"},
+			"Deploy abc123 (#42)",
+		},
+	}
+
+	for name, tc := range cases {
+		fakeAtlas := newFakeAtlas(t, nil)
+		srv := fakeAtlas.Server()
+
+		conf := map[string]interface{}{
+			"access_token": "sometoken",
+			"name":         "someuser/some-test-remote-state",
+			"address":      srv.URL,
+		}
+		for k, v := range tc.Config {
+			conf[k] = v
+		}
+		b := &Backend{}
+		backend.TestBackendConfig(t, b, conf)
+		testProvider(t, b, "test")
+
+		mod, modCleanup := module.TestTree(t, "./test-fixtures/plan")
+		op := testOperationPlan()
+		op.Module = mod
+
+		run, err := b.Operation(context.Background(), op)
+		if err != nil {
+			t.Fatalf("%s: bad: %s", name, err)
+		}
+		<-run.Done()
+		modCleanup()
+		srv.Close()
+		if run.Err != nil {
+			t.Fatalf("%s: err: %s", name, run.Err)
+		}
+
+		if len(fakeAtlas.runs) != 1 {
+			t.Fatalf("%s: expected one run to be created, got %d", name, len(fakeAtlas.runs))
+		}
+		var payload struct {
+			Run runRequest `json:"run"`
+		}
+		if err := json.Unmarshal(fakeAtlas.runs[0], &payload); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+		expected := runRequest{Type: "plan", Message: tc.Expected}
+		if !reflect.DeepEqual(payload.Run, expected) {
+			t.Fatalf("%s: bad: %#v", name, payload.Run)
+		}
+	}
+}
+
+func TestStateClient_createRunUnsupported(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	run, err := b.stateClient.createRun(context.Background(), &runRequest{Type: "plan"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if run != nil {
+		t.Fatalf("bad: %#v", run)
+	}
+}
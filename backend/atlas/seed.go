@@ -0,0 +1,70 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// ErrEnvironmentNotEmpty is returned by SeedState when the environment
+// already has a state with resources in it.
+type ErrEnvironmentNotEmpty struct {
+	Environment string
+	Serial      int64
+}
+
+func (e *ErrEnvironmentNotEmpty) Error() string {
+	return fmt.Sprintf(
+		"Refusing to seed the state of %s: it already has a state with\n"+
+			"resources, at serial %d. Set force_lineage to overwrite it.",
+		e.Environment, e.Serial)
+}
+
+// SeedState imports an existing state, such as a local one when moving to
+// Atlas, into the configured environment. Atlas creates the environment
+// when its first state is written.
+//
+// The state is written as serial 1, keeping its lineage, or with a new one
+// if it has none. SeedState refuses to overwrite a state with resources in
+// it unless force_lineage is set, in which case the state is written with
+// the serial after the one it replaces so that Atlas accepts it.
+func (b *Backend) SeedState(ctx context.Context, s *terraform.State) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	existing, err := b.FetchState(ctx)
+	if err != nil {
+		return err
+	}
+
+	seed := s.DeepCopy()
+	if seed == nil {
+		seed = terraform.NewState()
+	}
+	seed.Init()
+	seed.Serial = 1
+
+	if existing != nil {
+		if existing.HasResources() && !b.stateClient.Force {
+			return &ErrEnvironmentNotEmpty{
+				Environment: b.name,
+				Serial:      existing.Serial,
+			}
+		}
+		if existing.Serial >= seed.Serial {
+			seed.Serial = existing.Serial + 1
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := terraform.WriteState(seed, &buf); err != nil {
+		return fmt.Errorf("Failed to encode state: %v", err)
+	}
+
+	// The existing state has been checked already, so its lineage doesn't
+	// matter.
+	return b.stateClient.writeState(buf.Bytes(), false)
+}
@@ -0,0 +1,115 @@
+package atlas
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestBackend_SeedState(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	local := testSeedState("local-lineage", 7)
+	if err := b.SeedState(context.Background(), local); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := fakeAtlas.CurrentState()
+	if actual.Serial != 1 || actual.Lineage != "local-lineage" {
+		t.Fatalf("bad: serial %d, lineage %q", actual.Serial, actual.Lineage)
+	}
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = foo
+	`)
+
+	// The given state isn't modified
+	if local.Serial != 7 {
+		t.Fatalf("bad: %d", local.Serial)
+	}
+}
+
+func TestBackend_SeedStateNewLineage(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.SeedState(context.Background(), testSeedState("", 3)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if actual := fakeAtlas.CurrentState(); actual.Lineage == "" {
+		t.Fatal("the state should be given a lineage")
+	}
+}
+
+func TestBackend_SeedStateNotEmpty(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, testSeedState("remote-lineage", 4)))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	err := b.SeedState(context.Background(), testSeedState("local-lineage", 1))
+	notEmpty, ok := err.(*ErrEnvironmentNotEmpty)
+	if !ok {
+		t.Fatalf("expected *ErrEnvironmentNotEmpty, got: %#v", err)
+	}
+	if notEmpty.Serial != 4 {
+		t.Fatalf("bad: %#v", notEmpty)
+	}
+	if fakeAtlas.puts != 0 {
+		t.Fatal("the state should not be written")
+	}
+
+	// Forcing overwrites it, after the serial it replaces
+	b.stateClient.Force = true
+	if err := b.SeedState(context.Background(), testSeedState("local-lineage", 1)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	actual := fakeAtlas.CurrentState()
+	if actual.Serial != 5 || actual.Lineage != "local-lineage" {
+		t.Fatalf("bad: serial %d, lineage %q", actual.Serial, actual.Lineage)
+	}
+}
+
+func TestBackend_SeedStateEmptyTarget(t *testing.T) {
+	// A state without resources doesn't count as existing state
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.SeedState(context.Background(), testSeedState("local-lineage", 1)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual := fakeAtlas.CurrentState(); actual.Lineage != "local-lineage" {
+		t.Fatalf("bad lineage: %q", actual.Lineage)
+	}
+}
+
+// testSeedState returns a state with a single resource.
+func testSeedState(lineage string, serial int64) *terraform.State {
+	return &terraform.State{
+		Version: terraform.StateVersion,
+		Lineage: lineage,
+		Serial:  serial,
+		Modules: []*terraform.ModuleState{
+			&terraform.ModuleState{
+				Path: terraform.RootModulePath,
+				Resources: map[string]*terraform.ResourceState{
+					"test_instance.foo": &terraform.ResourceState{
+						Type: "test_instance",
+						Primary: &terraform.InstanceState{
+							ID: "foo",
+						},
+					},
+				},
+			},
+		},
+	}
+}
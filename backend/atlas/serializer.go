@@ -0,0 +1,83 @@
+package atlas
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// StateSerializer encodes states as they're stored in Atlas. The encoded
+// state is what's encrypted and compressed, if those are enabled, so a
+// serializer doesn't need to handle either. Deserialize must return the
+// state with the serial it was stored with, since Atlas orders writes by
+// serial.
+type StateSerializer interface {
+	Serialize(*terraform.State) ([]byte, error)
+	Deserialize([]byte) (*terraform.State, error)
+}
+
+// JSONSerializer encodes states as Terraform writes them. It's what's used
+// if no serializer is set, and it can be wrapped by serializers that only
+// change part of the encoding.
+type JSONSerializer struct{}
+
+func (JSONSerializer) Serialize(s *terraform.State) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := terraform.WriteState(s, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (JSONSerializer) Deserialize(data []byte) (*terraform.State, error) {
+	serial, err := readSerial(data)
+	if err != nil {
+		return nil, err
+	}
+	s, err := terraform.ReadState(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading bumps the serial if the state isn't formatted the way
+	// Terraform writes it, which isn't a change to the state here
+	s.Serial = serial
+	return s, nil
+}
+
+// serializeState returns the state, as written by Terraform, encoded with
+// the client's serializer. Without one, it's returned as it is, which is
+// what JSONSerializer would return.
+func (c *stateClient) serializeState(state []byte) ([]byte, error) {
+	if c.Serializer == nil {
+		return state, nil
+	}
+
+	s, err := JSONSerializer{}.Deserialize(state)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read state: %v", err)
+	}
+	data, err := c.Serializer.Serialize(s)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize state: %v", err)
+	}
+
+	return data, nil
+}
+
+// deserializeState returns a state read from Atlas, encoded with the
+// client's serializer, as Terraform writes it.
+func (c *stateClient) deserializeState(data []byte) ([]byte, error) {
+	if c.Serializer == nil {
+		return data, nil
+	}
+
+	s, err := c.Serializer.Deserialize(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to deserialize state: %v", err)
+	}
+
+	return JSONSerializer{}.Serialize(s)
+}
@@ -0,0 +1,91 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// compactSerializer wraps JSONSerializer to store states without
+// indentation.
+type compactSerializer struct {
+	JSONSerializer
+	serialized, deserialized int
+}
+
+func (s *compactSerializer) Serialize(state *terraform.State) ([]byte, error) {
+	s.serialized++
+	data, err := s.JSONSerializer.Serialize(state)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *compactSerializer) Deserialize(data []byte) (*terraform.State, error) {
+	s.deserialized++
+	return s.JSONSerializer.Deserialize(data)
+}
+
+func TestJSONSerializer(t *testing.T) {
+	s := testSeedState("lineage", 1)
+	data, err := JSONSerializer{}.Serialize(s)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(data, testStateBytes(t, s)) {
+		t.Fatalf("should be written as Terraform writes it: %s", data)
+	}
+
+	actual, err := JSONSerializer{}.Deserialize(data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !actual.Equal(s) {
+		t.Fatalf("bad: %s", actual)
+	}
+}
+
+func TestStateClient_serializer(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	serializer := new(compactSerializer)
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	}).(*stateClient)
+	client.Serializer = serializer
+
+	state := testStateBytes(t, testSeedState("lineage", 1))
+	if err := client.Put(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if serializer.serialized != 1 {
+		t.Fatalf("expected 1 serialize, got %d", serializer.serialized)
+	}
+	if bytes.Contains(fakeAtlas.state, []byte("\n")) {
+		t.Fatalf("state not compact: %s", fakeAtlas.state)
+	}
+
+	// The state is read back as Terraform writes it, with the same serial
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if serializer.deserialized != 1 {
+		t.Fatalf("expected 1 deserialize, got %d", serializer.deserialized)
+	}
+	if payload == nil || !bytes.Equal(payload.Data, state) {
+		t.Fatalf("bad: %#v", payload)
+	}
+}
@@ -0,0 +1,87 @@
+package atlas
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ServerInfo describes the Atlas server that the backend is configured
+// for. Servers that predate the version endpoint report an empty Version
+// and no features.
+type ServerInfo struct {
+	// Version is the version of Atlas running on the server.
+	Version string `json:"version"`
+
+	// Features are the optional features enabled on the server.
+	Features ServerFeatures `json:"features"`
+}
+
+// ServerFeatures are the optional features of an Atlas server.
+type ServerFeatures struct {
+	// CostEstimation is true if Atlas estimates the cost of runs.
+	CostEstimation bool `json:"cost_estimation"`
+
+	// Sentinel is true if Atlas checks runs against Sentinel policies.
+	Sentinel bool `json:"sentinel"`
+}
+
+// ServerInfo returns the version and features of the Atlas server. An
+// error is returned if the server can't be reached. The result is read
+// once and then cached for the lifetime of the backend.
+func (b *Backend) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	if b.stateClient == nil {
+		return ServerInfo{}, errNotConfigured
+	}
+
+	b.serverInfoLock.Lock()
+	defer b.serverInfoLock.Unlock()
+
+	if b.serverInfo == nil {
+		info, err := b.stateClient.serverInfo(ctx)
+		if err != nil {
+			return ServerInfo{}, err
+		}
+		b.serverInfo = &info
+	}
+
+	return *b.serverInfo, nil
+}
+
+func (c *stateClient) serverInfo(ctx context.Context) (ServerInfo, error) {
+	var result ServerInfo
+	status, err := c.getJSONContext(ctx, c.endpoints().VersionURL(), &result)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusNotFound:
+		// Older versions of Atlas don't have the endpoint, or any of the
+		// features that are reported by it.
+		log.Printf("[DEBUG] backend/atlas: %s doesn't report its version", c.Server)
+		return ServerInfo{}, nil
+	case http.StatusUnauthorized:
+		return ServerInfo{}, ErrUnauthorized
+	case http.StatusForbidden:
+		return ServerInfo{}, ErrForbidden
+	default:
+		return ServerInfo{}, fmt.Errorf(
+			"Unexpected response reading the Atlas server version: HTTP %d", status)
+	}
+}
+
+// serverFeatures returns the features of the server for deciding whether
+// to use them. If they can't be read, that's logged and no features are
+// returned.
+func (b *Backend) serverFeatures(ctx context.Context) ServerFeatures {
+	info, err := b.ServerInfo(ctx)
+	if err != nil {
+		log.Printf("[WARN] backend/atlas: failed to read the server's features: %s", err)
+	}
+
+	return info.Features
+}
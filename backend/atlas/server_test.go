@@ -0,0 +1,76 @@
+package atlas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestBackend_ServerInfo(t *testing.T) {
+	expected := ServerInfo{
+		Version:  "1.2.3",
+		Features: ServerFeatures{CostEstimation: true, Sentinel: true},
+	}
+	fake := &fakeRuns{
+		t:          t,
+		statuses:   []string{"planned"},
+		serverInfo: &expected,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	for i := 0; i < 2; i++ {
+		actual, err := b.ServerInfo(context.Background())
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad: %#v", actual)
+		}
+	}
+
+	// The result is cached
+	if fake.infoReads != 1 {
+		t.Fatalf("expected the server to be asked once, got %d", fake.infoReads)
+	}
+}
+
+func TestBackend_ServerInfoOldServer(t *testing.T) {
+	fake := &fakeRuns{
+		t:        t,
+		statuses: []string{"planned"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(fake.handler))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	actual, err := b.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(actual, ServerInfo{}) {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if b.serverFeatures(context.Background()).CostEstimation {
+		t.Fatal("an old server shouldn't support cost estimation")
+	}
+}
+
+func TestBackend_ServerInfoUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	b := testBackend(t, srv)
+	srv.Close()
+	b.stateClient.RetryMax = 0
+
+	if _, err := b.ServerInfo(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Failures aren't cached
+	if b.serverInfo != nil {
+		t.Fatalf("bad: %#v", b.serverInfo)
+	}
+}
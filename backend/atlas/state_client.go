@@ -2,31 +2,192 @@ package atlas
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/hashicorp/go-rootcerts"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/state/remote"
 	"github.com/hashicorp/terraform/terraform"
+	"golang.org/x/net/http2"
 )
 
 const (
 	// defaultAtlasServer is used when no address is given
 	defaultAtlasServer = "https://atlas.hashicorp.com/"
 	atlasTokenHeader   = "X-Atlas-Token"
+
+	// idempotencyKeyHeader identifies a state write, so that Atlas can
+	// tell a retry of a write it has already applied from a new write.
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// defaultTimeout is how long each request may take if no timeout is
+	// configured
+	defaultTimeout = 30 * time.Second
+
+	// defaultMaxStateSize is the largest state that's written to Atlas if
+	// max_state_size isn't set.
+	defaultMaxStateSize = 100 * 1024 * 1024
+)
+
+// ErrStateSerialConflict is returned when Atlas rejects a state write because
+// the stored state conflicts with the serial that was being written. This
+// usually means that someone else has written the state since it was read.
+type ErrStateSerialConflict struct {
+	LocalSerial  int64
+	RemoteSerial int64
+	Message      string
+
+	// BackupPath is the path of the local backup of the state that failed
+	// to be written, if one was made.
+	BackupPath string
+}
+
+func (e *ErrStateSerialConflict) Error() string {
+	msg := fmt.Sprintf(
+		"Atlas detected a remote state conflict: the local state has serial %d\n"+
+			"but the state stored in Atlas has serial %d. Another Terraform run\n"+
+			"has most likely modified the state; refresh and try again.\n\n"+
+			"Message: %s", e.LocalSerial, e.RemoteSerial, e.Message)
+	if e.BackupPath != "" {
+		msg += fmt.Sprintf("\n\nA backup of the local state was written to: %s", e.BackupPath)
+	}
+
+	return msg
+}
+
+// ErrStateLineageMismatch is returned when the state being written has a
+// different lineage than the state stored in Atlas. This usually means that
+// the state belongs to a different environment, and writing it would replace
+// that environment's state.
+type ErrStateLineageMismatch struct {
+	LocalLineage  string
+	RemoteLineage string
+}
+
+func (e *ErrStateLineageMismatch) Error() string {
+	return fmt.Sprintf(
+		"Refusing to write state: the local state has lineage %q but the\n"+
+			"state stored in Atlas has lineage %q. The states most likely belong\n"+
+			"to different environments. Set force_lineage to write it anyway.",
+		e.LocalLineage, e.RemoteLineage)
+}
+
+// ErrStatePersistFailed is returned when a state couldn't be written to
+// Atlas. The state in Atlas may then be missing changes that were made,
+// such as by an apply, so the error explains how to recover.
+type ErrStatePersistFailed struct {
+	// Err is the reason the write failed.
+	Err error
+
+	// Serial is the serial of the state that failed to be written.
+	Serial int64
+
+	// BackupPath is the path of the local backup of the state that failed
+	// to be written, if one was made.
+	BackupPath string
+}
+
+func (e *ErrStatePersistFailed) Error() string {
+	msg := fmt.Sprintf(
+		"Failed to save state: %s\n\n"+
+			"The state in Atlas may be missing changes that were made. ", e.Err)
+	if e.BackupPath != "" {
+		return msg + fmt.Sprintf(
+			"A backup of the\nstate that failed to be written, with serial %d, was written to:\n\n"+
+				"  %s\n\n"+
+				"Once the problem is fixed, push it to Atlas with:\n\n"+
+				"  terraform state push %s",
+			e.Serial, e.BackupPath, e.BackupPath)
+	}
+
+	return msg + fmt.Sprintf(
+		"No local backup\nwas made of the state with serial %d, so any resources that were created\n"+
+			"may need to be imported once the problem is fixed.", e.Serial)
+}
+
+var (
+	// ErrUnauthorized is returned when Atlas rejects the access token.
+	ErrUnauthorized = errors.New(
+		"Atlas rejected the access token as invalid or missing. Check that\n" +
+			"access_token or ATLAS_TOKEN is set to a token that hasn't been\n" +
+			"revoked.")
+
+	// ErrForbidden is returned when the access token is valid but doesn't
+	// have permission for the environment.
+	ErrForbidden = errors.New(
+		"The access token lacks permission for this Atlas environment. Ask an\n" +
+			"owner of the organization to grant the token's user access to it.")
 )
 
+// ErrStateTooLarge is returned when writing a state that's larger than
+// max_state_size, rather than attempting an upload that's unlikely to
+// succeed.
+type ErrStateTooLarge struct {
+	Size    int
+	MaxSize int
+}
+
+func (e *ErrStateTooLarge) Error() string {
+	return fmt.Sprintf(
+		"Refusing to write state: the state is %d bytes, which is more than\n"+
+			"max_state_size (%d bytes). Check for resources or outputs that hold\n"+
+			"unusually large values, or raise max_state_size.",
+		e.Size, e.MaxSize)
+}
+
+// ErrRequestTimeout is returned when a request to Atlas doesn't complete
+// within the configured timeout. This is distinct from an error returned by
+// the server so that it can be handled differently.
+type ErrRequestTimeout struct {
+	Method  string
+	URL     string
+	Timeout time.Duration
+
+	// Attempts is the number of attempts made at the request, if it was
+	// retried before the attempt that timed out.
+	Attempts int
+}
+
+func (e *ErrRequestTimeout) Error() string {
+	msg := fmt.Sprintf("%s %s timed out after %s", e.Method, e.URL, e.Timeout)
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(", giving up after %d attempts", e.Attempts)
+	}
+
+	return msg
+}
+
+// requestError returns the error of a request to Atlas that failed without
+// a response, described by msg. An *ErrRequestTimeout is returned as it is
+// so that callers can still tell a timeout apart from other failures.
+func requestError(msg string, err error) error {
+	if _, ok := err.(*ErrRequestTimeout); ok {
+		return err
+	}
+
+	return fmt.Errorf("%s: %v", msg, err)
+}
+
 // AtlasClient implements the Client interface for an Atlas compatible server.
 type stateClient struct {
 	Server      string
@@ -35,26 +196,201 @@ type stateClient struct {
 	Name        string
 	AccessToken string
 	RunId       string
-	HTTPClient  *retryablehttp.Client
+	GZip        bool
+	RootCAs     *x509.CertPool
+	ProxyURL    *url.URL
+	BasicUser   string
+	BasicPass   string
+	UserAgent   string
+	Headers     map[string]string
+
+	// ReadServerURL, if not nil, is the address of a read-only replica of
+	// Atlas to read the state and list runs from, rather than ServerURL.
+	// If ReadFallback is true, a read that finds no state on the replica
+	// is made again from ServerURL, in case the replica is behind.
+	ReadServerURL *url.URL
+	ReadFallback  bool
+
+	// BackupDir is where a local backup of the state is written before
+	// each write to Atlas, keeping the newest BackupCount of them. If
+	// BackupDir is empty, no backups are made.
+	BackupDir   string
+	BackupCount int
+
+	// ChunkSize is the size of each part when a state that is too large
+	// for a single request is uploaded in parts.
+	ChunkSize int
+
+	// MaxStateSize is the largest state in bytes that's written to Atlas.
+	// If it's zero, states of any size are written.
+	MaxStateSize int
+
+	Timeout    time.Duration
+	RetryMax   int
+	HTTPClient *retryablehttp.Client
+
+	// RetryWaitMin and RetryWaitMax bound the wait between retries. If
+	// either is zero, its default is used.
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+
+	// Cipher, if set, encrypts the state before it's written to Atlas and
+	// decrypts it when it's read.
+	Cipher *stateCipher
+
+	// Serializer, if set, encodes the state as it's stored in Atlas. If
+	// nil, it's stored as Terraform writes it.
+	Serializer StateSerializer
+
+	// Observer, if not nil, is notified each time the state is written.
+	Observer OperationObserver
+
+	// DryRun skips every call that would change something in Atlas,
+	// outputting what it would have done with dryRunOutput instead. Reads
+	// are made as usual.
+	DryRun       bool
+	dryRunOutput func(string)
+
+	// correlation is the ID of the running operation, sent with each
+	// request. It's shared by the copies of the client made for each
+	// environment.
+	correlation *correlationID
+
+	// skew records the clock skew seen in lock responses. It's shared by
+	// the copies of the client made for each environment.
+	skew *clockSkew
+
+	// LockTimeout is how long Lock keeps retrying while the state is
+	// locked by someone else. If zero, Lock fails at once.
+	LockTimeout time.Duration
+
+	// Clock is used to wait between retries. If nil, the real clock is
+	// used.
+	Clock clock
+
+	// SkipCertVerification disables verification of the server's TLS
+	// certificate. This should only be used for testing.
+	SkipCertVerification bool
+
+	// MaxIdleConns is how many idle connections to Atlas are kept open for
+	// reuse. If zero, the transport's default is used. DisableKeepAlives
+	// makes a new connection for every request instead, which also means
+	// HTTP/2 isn't used.
+	MaxIdleConns      int
+	DisableKeepAlives bool
+
+	// TLSMinVersion is the oldest version of TLS to connect with. If zero,
+	// Go's default is used.
+	TLSMinVersion uint16
+
+	// Metrics, if not nil, observes every request made to Atlas.
+	Metrics Metrics
+
+	// etags holds the ETag of the last read of each state URL. It's shared
+	// by the copies of the client made for each environment. If nil, every
+	// read fetches the whole state.
+	etags *etagCache
+
+	// connStats counts the connections and requests of the transport
+	// built by newHTTPClient. It's shared by the copies of the client made
+	// for each environment. If nil, nothing is counted.
+	connStats *transportCounters
+
+	// redactor masks secrets in errors. If nil, nothing is masked.
+	redactor *redactor
+
+	// Force disables the check that the state being written has the same
+	// lineage as the state stored in Atlas.
+	Force bool
 
 	conflictHandlingAttempted bool
+
+	// cached is the state last read by Get, if cacheValid is true. This
+	// saves reading the state again for each refresh within an operation.
+	cached     *remote.Payload
+	cacheValid bool
+
+	// serialBase is the serial of the state last read by Get or written by
+	// Put, if hasSerialBase is true. See withNextSerial.
+	serialBase    int64
+	hasSerialBase bool
 }
 
+// Get returns the state, reading it from Atlas only if it hasn't already
+// been read since it was last written.
 func (c *stateClient) Get() (*remote.Payload, error) {
+	if c.cacheValid {
+		return c.cached, nil
+	}
+
+	payload, err := c.get(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var serial int64
+	if payload != nil {
+		if serial, err = readSerial(payload.Data); err != nil {
+			return nil, err
+		}
+	}
+	c.setSerialBase(serial)
+
+	c.cached = payload
+	c.cacheValid = true
+	return payload, nil
+}
+
+// invalidateCache makes the next Get read the state from Atlas.
+func (c *stateClient) invalidateCache() {
+	c.cached = nil
+	c.cacheValid = false
+}
+
+// get reads the state from Atlas, giving up when ctx is done. Apart from its
+// ETag cache, which has its own lock, it only reads from c, so it's safe to
+// call concurrently.
+func (c *stateClient) get(ctx context.Context) (*remote.Payload, error) {
+	if c.ReadServerURL == nil {
+		return c.getFrom(ctx, c.url())
+	}
+
+	payload, err := c.getFrom(ctx, c.readURL())
+	if err != nil || payload != nil || !c.ReadFallback {
+		return payload, err
+	}
+
+	log.Printf("[DEBUG] backend/atlas: no state on the read replica, reading it from %s", c.Server)
+	return c.getFrom(ctx, c.url())
+}
+
+// getFrom reads the state from the given URL, giving up when ctx is done.
+func (c *stateClient) getFrom(ctx context.Context, u *url.URL) (*remote.Payload, error) {
 	// Make the HTTP request
-	req, err := retryablehttp.NewRequest("GET", c.url().String(), nil)
+	req, err := retryablehttp.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
 	}
+	req.Request = req.Request.WithContext(ctx)
 
 	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	// Explicitly negotiate the encoding so that the HTTP client never
+	// decompresses transparently, which would break the MD5 check below.
+	if c.GZip {
+		req.Header.Set("Accept-Encoding", "gzip")
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
 
-	// Request the url
-	client, err := c.http()
-	if err != nil {
-		return nil, err
+	// If the state was read from here before with an ETag, Atlas only
+	// sends it again if it has changed
+	etag, cached := c.etags.Get(u.String())
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
 	}
-	resp, err := client.Do(req)
+
+	// Request the url
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -64,20 +400,31 @@ func (c *stateClient) Get() (*remote.Payload, error) {
 	switch resp.StatusCode {
 	case http.StatusOK:
 		// Handled after
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, withRequestID(resp, fmt.Errorf(
+				"Atlas reported the state as not modified, but it wasn't read before"))
+		}
+
+		log.Printf("[DEBUG] backend/atlas: state not modified since it was last read")
+		payload := *cached
+		return &payload, nil
 	case http.StatusNoContent:
+		c.etags.Set(u.String(), "", nil)
 		return nil, nil
 	case http.StatusNotFound:
+		c.etags.Set(u.String(), "", nil)
 		return nil, nil
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("HTTP remote state endpoint requires auth")
+		return nil, ErrUnauthorized
 	case http.StatusForbidden:
-		return nil, fmt.Errorf("HTTP remote state endpoint invalid auth")
+		return nil, ErrForbidden
 	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("HTTP remote state internal server error")
+		return nil, withRequestID(resp, fmt.Errorf("HTTP remote state internal server error"))
 	default:
-		return nil, fmt.Errorf(
+		return nil, withRequestID(resp, fmt.Errorf(
 			"Unexpected HTTP response code: %d\n\nBody: %s",
-			resp.StatusCode, c.readBody(resp.Body))
+			resp.StatusCode, c.readBody(resp.Body)))
 	}
 
 	// Read in the body
@@ -86,42 +433,253 @@ func (c *stateClient) Get() (*remote.Payload, error) {
 		return nil, fmt.Errorf("Failed to read remote state: %v", err)
 	}
 
-	// Create the payload
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+
+	// Generate the MD5 and verify it against the one Atlas reports, if any,
+	// so that truncated or corrupted downloads are caught. Atlas stores the
+	// state as it was uploaded, so this is computed before decompressing.
+	hash := md5.Sum(buf.Bytes())
 	payload := &remote.Payload{
 		Data: buf.Bytes(),
+		MD5:  hash[:],
 	}
+	if raw := resp.Header.Get("Content-MD5"); raw != "" {
+		expected, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode Content-MD5 '%s': %v", raw, err)
+		}
 
-	if len(payload.Data) == 0 {
-		return nil, nil
+		if !bytes.Equal(expected, payload.MD5) {
+			return nil, fmt.Errorf(
+				"state MD5 mismatch: got %x want %x", payload.MD5, expected)
+		}
 	}
 
-	// Check for the MD5
-	if raw := resp.Header.Get("Content-MD5"); raw != "" {
-		md5, err := base64.StdEncoding.DecodeString(raw)
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		data, err := uncompressState(payload.Data)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to decode Content-MD5 '%s': %v", raw, err)
+			return nil, fmt.Errorf("Failed to decompress remote state: %v", err)
 		}
 
-		payload.MD5 = md5
-	} else {
-		// Generate the MD5
-		hash := md5.Sum(payload.Data)
-		payload.MD5 = hash[:]
+		payload.Data = data
+	}
+
+	if payload.Data, err = c.decodeState(payload.Data); err != nil {
+		return nil, err
 	}
 
+	c.logState("read", payload.Data)
+
+	// Without an ETag, the next read fetches the whole state again
+	c.etags.Set(u.String(), resp.Header.Get("ETag"), payload)
+
 	return payload, nil
 }
 
 func (c *stateClient) Put(state []byte) error {
-	// Get the target URL
+	state, err := c.withNextSerial(state)
+	if err != nil {
+		return err
+	}
+
+	return c.writeState(state, !c.Force)
+}
+
+// withNextSerial returns the state with its serial set to follow the
+// serial of the state this client last read from or wrote to Atlas, if it
+// doesn't already. The state being written is based on that one, and
+// Atlas rejects writes whose serial didn't increase, such as the second
+// write of a state during an apply. If no state was stored, the first one
+// written gets serial 1.
+//
+// Without a read or write to go by, the state is written as it is. There's
+// no telling then whether it's based on the stored state, so Atlas is left
+// to report any conflict rather than the write replacing a newer state.
+func (c *stateClient) withNextSerial(state []byte) ([]byte, error) {
+	if !c.hasSerialBase {
+		return state, nil
+	}
+
+	serial, err := readSerial(state)
+	if err != nil {
+		return nil, err
+	}
+
+	next := c.serialBase + 1
+	if serial >= next {
+		return state, nil
+	}
+
+	s, err := terraform.ReadState(bytes.NewReader(state))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read state: %v", err)
+	}
+	s.Serial = next
+
+	var buf bytes.Buffer
+	if err := terraform.WriteState(s, &buf); err != nil {
+		return nil, fmt.Errorf("Failed to encode state: %v", err)
+	}
+
+	log.Printf("[DEBUG] backend/atlas: writing state with serial %d rather than %d", next, serial)
+	return buf.Bytes(), nil
+}
+
+// setSerialBase records the serial of the state last read from or written
+// to Atlas, for withNextSerial. A serial of zero means no state is stored.
+func (c *stateClient) setSerialBase(serial int64) {
+	c.serialBase = serial
+	c.hasSerialBase = true
+}
+
+// writeState backs up the state and writes it to Atlas, first checking
+// that its lineage matches the stored state if checkLineage is true.
+func (c *stateClient) writeState(state []byte, checkLineage bool) error {
+	if c.DryRun {
+		serial, err := readSerial(state)
+		if err != nil {
+			return err
+		}
+		c.dryRun("write the state of %s with serial %d (%d bytes)",
+			path.Join(c.User, c.Name), serial, len(state))
+		return nil
+	}
+
+	// Whether or not the write succeeds, the cached state may no longer
+	// be what Atlas has.
+	defer c.invalidateCache()
+
+	write := func() error {
+		if checkLineage {
+			if err := c.checkLineage(state); err != nil {
+				return err
+			}
+		}
+
+		return c.put(state)
+	}
+
+	if c.BackupDir == "" {
+		return write()
+	}
+
+	path, err := c.writeBackup(state, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = write()
+	if err == nil || err == ErrUnauthorized || err == ErrForbidden {
+		// Auth errors are returned as they are so that they can be
+		// recognized; the fix for them doesn't involve the backup.
+		return err
+	}
+
+	switch err := err.(type) {
+	case *ErrStateLineageMismatch, *ErrEnvironmentArchived:
+		return err
+	case *ErrStateSerialConflict:
+		err.BackupPath = path
+		return err
+	default:
+		serial, _ := readSerial(state)
+		return &ErrStatePersistFailed{Err: err, Serial: serial, BackupPath: path}
+	}
+}
+
+// checkLineage returns an error if the lineage of the given state differs
+// from that of the state stored in Atlas. States without a lineage, and
+// environments without a state yet, are always accepted.
+func (c *stateClient) checkLineage(state []byte) error {
+	localLineage, err := readLineage(state)
+	if err != nil {
+		return err
+	}
+	if localLineage == "" {
+		return nil
+	}
+
+	payload, err := c.Get()
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		return nil
+	}
+
+	remoteLineage, err := readLineage(payload.Data)
+	if err != nil {
+		return err
+	}
+	if remoteLineage != "" && remoteLineage != localLineage {
+		return &ErrStateLineageMismatch{
+			LocalLineage:  localLineage,
+			RemoteLineage: remoteLineage,
+		}
+	}
+
+	return nil
+}
+
+// put writes the state to Atlas.
+func (c *stateClient) put(state []byte) error {
+	if c.MaxStateSize > 0 && len(state) > c.MaxStateSize {
+		return &ErrStateTooLarge{Size: len(state), MaxSize: c.MaxStateSize}
+	}
+
+	// Get the target URL, including the serial we're writing so that Atlas
+	// can reject writes that are based on an outdated state.
+	serial, err := readSerial(state)
+	if err != nil {
+		return err
+	}
 	base := c.url()
+	values := base.Query()
+	values.Set("serial", strconv.FormatInt(serial, 10))
+	base.RawQuery = values.Encode()
+
+	c.logState("writing", state)
+
+	body, err := c.serializeState(state)
+	if err != nil {
+		return err
+	}
+	if c.Cipher != nil {
+		lineage, err := readLineage(state)
+		if err != nil {
+			return err
+		}
+		body, err = c.Cipher.Encrypt(body, serial, lineage)
+		if err != nil {
+			return fmt.Errorf("Failed to encrypt state: %v", err)
+		}
+	}
+
+	// Compress the state if enabled. The MD5 is computed over the bytes that
+	// are actually sent, since that is what Atlas stores.
+	if c.GZip {
+		body, err = compressState(body)
+		if err != nil {
+			return fmt.Errorf("Failed to compress state: %v", err)
+		}
+	}
 
 	// Generate the MD5
-	hash := md5.Sum(state)
+	hash := md5.Sum(body)
 	b64 := base64.StdEncoding.EncodeToString(hash[:])
 
+	// Every attempt at this write carries the same key. Atlas versions
+	// that don't know the header ignore it, which is no worse than not
+	// sending one, so failing to generate it isn't fatal either.
+	key, err := uuid.GenerateUUID()
+	if err != nil {
+		log.Printf("[WARN] backend/atlas: failed to generate an idempotency key: %s", err)
+	}
+
 	// Make the HTTP client and request
-	req, err := retryablehttp.NewRequest("PUT", base.String(), bytes.NewReader(state))
+	req, err := retryablehttp.NewRequest("PUT", base.String(), bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("Failed to make HTTP request: %v", err)
 	}
@@ -130,33 +688,56 @@ func (c *stateClient) Put(state []byte) error {
 	req.Header.Set(atlasTokenHeader, c.AccessToken)
 	req.Header.Set("Content-MD5", b64)
 	req.Header.Set("Content-Type", "application/json")
-	req.ContentLength = int64(len(state))
+	if c.GZip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	req.ContentLength = int64(len(body))
 
 	// Make the request
-	client, err := c.http()
+	resp, err := c.do(req)
 	if err != nil {
-		return err
+		return requestError("Failed to upload state", err)
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("Failed to upload state: %v", err)
+
+	// If the state is too large for a single request, upload it in parts
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		resp.Body.Close()
+
+		log.Printf("[DEBUG] State is too large for a single upload, uploading %d bytes in parts", len(body))
+		resp, err = c.putChunked(base, body, b64, key)
+		if err != nil {
+			return requestError("Failed to upload state", err)
+		}
 	}
 	defer resp.Body.Close()
 
 	// Handle the error codes
 	switch resp.StatusCode {
 	case http.StatusOK:
+		c.setSerialBase(serial)
+		c.observer().StatePersisted(serial)
 		return nil
 	case http.StatusConflict:
-		return c.handleConflict(c.readBody(resp.Body), state)
+		return c.handleConflict(c.readBody(resp.Body), state, serial)
+	case http.StatusLocked:
+		// The state of an archived environment is read-only
+		return &ErrEnvironmentArchived{Environment: path.Join(c.User, c.Name)}
 	default:
-		return fmt.Errorf(
-			"HTTP error: %d\n\nBody: %s",
-			resp.StatusCode, c.readBody(resp.Body))
+		return c.httpError(resp)
 	}
 }
 
 func (c *stateClient) Delete() error {
+	if c.dryRun("delete the state of %s", path.Join(c.User, c.Name)) {
+		return nil
+	}
+
+	c.invalidateCache()
+	c.hasSerialBase = false
+
 	// Make the HTTP request
 	req, err := retryablehttp.NewRequest("DELETE", c.url().String(), nil)
 	if err != nil {
@@ -165,13 +746,9 @@ func (c *stateClient) Delete() error {
 	req.Header.Set(atlasTokenHeader, c.AccessToken)
 
 	// Make the request
-	client, err := c.http()
-	if err != nil {
-		return err
-	}
-	resp, err := client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return fmt.Errorf("Failed to delete state: %v", err)
+		return requestError("Failed to delete state", err)
 	}
 	defer resp.Body.Close()
 
@@ -184,9 +761,218 @@ func (c *stateClient) Delete() error {
 	case http.StatusNotFound:
 		return nil
 	default:
-		return fmt.Errorf(
+		return c.httpError(resp)
+	}
+}
+
+func (c *stateClient) Lock(info *state.LockInfo) (string, error) {
+	info.Path = path.Join(c.User, c.Name)
+	if c.dryRun("lock the state of %s", info.Path) {
+		return info.ID, nil
+	}
+
+	id, err := c.lock(info)
+	lockErr, ok := err.(*state.LockError)
+	if !ok || c.LockTimeout <= 0 {
+		return id, err
+	}
+
+	// Retry with backoff until the lock is released or the timeout is
+	// reached. Each attempt only reads the lock info, and the lock is only
+	// requested again once it's been released.
+	deadline := c.clock().Now().Add(c.LockTimeout)
+	for attempt := 0; ; attempt++ {
+		remaining := deadline.Sub(c.clock().Now())
+		if remaining <= 0 {
+			return "", lockErr
+		}
+
+		wait := c.retryWait(attempt)
+		if wait > remaining {
+			wait = remaining
+		}
+		log.Printf("[DEBUG] State %q is locked, retrying in %s", info.Path, wait)
+		c.clock().Sleep(wait)
+
+		existing, err := c.getLockInfo()
+		if err != nil {
+			return "", &state.LockError{Err: err}
+		}
+		if existing != nil {
+			lockErr.Info = existing
+			continue
+		}
+
+		id, err := c.lock(info)
+		if err, ok := err.(*state.LockError); ok {
+			// Someone else took the lock first
+			lockErr = err
+			continue
+		}
+
+		return id, err
+	}
+}
+
+// lock makes a single attempt to lock the state.
+func (c *stateClient) lock(info *state.LockInfo) (string, error) {
+	req, err := retryablehttp.NewRequest(
+		"PUT", c.lockURL().String(), bytes.NewReader(info.Marshal()))
+	if err != nil {
+		return "", fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", requestError("Failed to lock state", err)
+	}
+	defer resp.Body.Close()
+	c.recordClockSkew(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return info.ID, nil
+	case http.StatusConflict, http.StatusLocked:
+		lockErr := &state.LockError{
+			Err: fmt.Errorf("state %q is already locked", info.Path),
+		}
+
+		existing := &state.LockInfo{}
+		if err := json.NewDecoder(resp.Body).Decode(existing); err != nil {
+			lockErr.Err = fmt.Errorf("error decoding lock info: %v", err)
+		} else {
+			lockErr.Info = existing
+		}
+
+		return "", lockErr
+	default:
+		return "", c.httpError(resp)
+	}
+}
+
+func (c *stateClient) Unlock(id string) error {
+	if c.dryRun("unlock the state of %s", path.Join(c.User, c.Name)) {
+		return nil
+	}
+
+	// Verify that the lock being released is the one that is held, so that
+	// we never release a lock taken by someone else.
+	info, err := c.getLockInfo()
+	if err != nil {
+		return &state.LockError{Err: err}
+	}
+	if info == nil {
+		return nil
+	}
+	if info.ID != id {
+		return &state.LockError{
+			Info: info,
+			Err:  fmt.Errorf("lock id %q does not match existing lock", id),
+		}
+	}
+
+	return c.deleteLock()
+}
+
+// ForceUnlock releases the lock on the state with the given ID, or
+// whichever lock holds it if id is empty. This is only meant for recovering
+// from a lock left behind by a crashed Terraform run; Unlock should be used
+// otherwise. The info of the discarded lock is returned, or nil if the state
+// wasn't locked.
+func (c *stateClient) ForceUnlock(id string) (*state.LockInfo, error) {
+	info, err := c.getLockInfo()
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, nil
+	}
+	if id != "" && info.ID != id {
+		// The lock was likely taken since by a run that's still going
+		return nil, &state.LockError{
+			Info: info,
+			Err:  fmt.Errorf("lock id %q does not match existing lock", id),
+		}
+	}
+
+	if err := c.deleteLock(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// getLockInfo returns the info of the lock currently held on the state, or
+// nil if the state isn't locked.
+func (c *stateClient) getLockInfo() (*state.LockInfo, error) {
+	req, err := retryablehttp.NewRequest("GET", c.lockURL().String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, requestError("Failed to read lock info", err)
+	}
+	defer resp.Body.Close()
+	c.recordClockSkew(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		info := &state.LockInfo{}
+		if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+			return nil, fmt.Errorf("error decoding lock info: %v", err)
+		}
+
+		return info, nil
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, c.httpError(resp)
+	}
+}
+
+func (c *stateClient) deleteLock() error {
+	if c.dryRun("remove the lock on the state of %s", path.Join(c.User, c.Name)) {
+		return nil
+	}
+
+	req, err := retryablehttp.NewRequest("DELETE", c.lockURL().String(), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return requestError("Failed to unlock state", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return c.httpError(resp)
+	}
+}
+
+// httpError returns the error for an unexpected response. Authentication
+// and authorization failures are returned as ErrUnauthorized and
+// ErrForbidden so that callers can explain them.
+func (c *stateClient) httpError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	default:
+		return withRequestID(resp, fmt.Errorf(
 			"HTTP error: %d\n\nBody: %s",
-			resp.StatusCode, c.readBody(resp.Body))
+			resp.StatusCode, c.readBody(resp.Body)))
 	}
 }
 
@@ -201,58 +987,257 @@ func (c *stateClient) readBody(b io.Reader) string {
 		result = "<empty>"
 	}
 
-	return result
+	// The body may echo the request, including secrets
+	return c.redactor.Redact(result)
 }
 
 func (c *stateClient) url() *url.URL {
-	values := url.Values{}
+	u := c.endpoints().StateURL(c.User, c.Name)
+	u.RawQuery = c.runQuery()
+	return u
+}
 
-	values.Add("atlas_run_id", c.RunId)
+// readURL returns the URL to read the state from, which is on the read
+// replica if one is configured.
+func (c *stateClient) readURL() *url.URL {
+	u := c.readEndpoints().StateURL(c.User, c.Name)
+	u.RawQuery = c.runQuery()
+	return u
+}
 
-	return &url.URL{
-		Scheme:   c.ServerURL.Scheme,
-		Host:     c.ServerURL.Host,
-		Path:     path.Join("api/v1/terraform/state", c.User, c.Name),
-		RawQuery: values.Encode(),
-	}
+// lockURL returns the URL of the lock for the state.
+func (c *stateClient) lockURL() *url.URL {
+	u := c.endpoints().LockURL(c.User, c.Name)
+	u.RawQuery = c.runQuery()
+	return u
 }
 
+// runQuery returns the query identifying the Atlas run, if any, that the
+// state is used by.
+func (c *stateClient) runQuery() string {
+	values := url.Values{}
+	values.Add("atlas_run_id", c.RunId)
+	return values.Encode()
+}
+
+// http returns the HTTP client of c, building it on first use if it
+// wasn't given one. Clients set up by Configure are always given the
+// client shared by the backend, so that they never build their own.
 func (c *stateClient) http() (*retryablehttp.Client, error) {
 	if c.HTTPClient != nil {
 		return c.HTTPClient, nil
 	}
-	tlsConfig := &tls.Config{}
-	err := rootcerts.ConfigureTLS(tlsConfig, &rootcerts.Config{
-		CAFile: os.Getenv("ATLAS_CAFILE"),
-		CAPath: os.Getenv("ATLAS_CAPATH"),
-	})
+
+	rc, err := c.newHTTPClient()
 	if err != nil {
 		return nil, err
 	}
-	rc := retryablehttp.NewClient()
 
-	rc.CheckRetry = func(resp *http.Response, err error) (bool, error) {
+	c.HTTPClient = rc
+	return rc, nil
+}
+
+// newHTTPClient builds an HTTP client with the TLS, proxy, header and
+// authentication settings of c. The client is safe for concurrent use,
+// and its transport pools connections, so it should be built once and
+// shared rather than per request.
+func (c *stateClient) newHTTPClient() (*retryablehttp.Client, error) {
+	tlsConfig := &tls.Config{
+		RootCAs:            c.RootCAs,
+		InsecureSkipVerify: c.SkipCertVerification,
+		MinVersion:         c.TLSMinVersion,
+	}
+	if c.RootCAs == nil {
+		err := rootcerts.ConfigureTLS(tlsConfig, &rootcerts.Config{
+			CAPath: os.Getenv("ATLAS_CAPATH"),
+		})
 		if err != nil {
-			// don't bother retrying if the certs don't match
-			if err, ok := err.(*url.Error); ok {
-				if _, ok := err.Err.(x509.UnknownAuthorityError); ok {
-					return false, nil
-				}
-			}
-			// continue retrying
-			return true, nil
+			return nil, err
 		}
-		return retryablehttp.DefaultRetryPolicy(resp, err)
 	}
+	rc := retryablehttp.NewClient()
+
+	// Requests are logged by do, at the levels TF_LOG filters on. The
+	// client's own logger writes straight to stderr, so it's discarded.
+	rc.Logger = log.New(ioutil.Discard, "", 0)
 
-	t := cleanhttp.DefaultTransport()
+	// Retries are handled by do, which knows whether the request being
+	// made is idempotent.
+	rc.CheckRetry = func(resp *http.Response, err error) (bool, error) {
+		return false, nil
+	}
+
+	// The client is shared by every request of the backend, so its
+	// connections are pooled rather than made anew for each request.
+	t := cleanhttp.DefaultPooledTransport()
 	t.TLSClientConfig = tlsConfig
-	rc.HTTPClient.Transport = t
+	if c.ProxyURL != nil {
+		t.Proxy = http.ProxyURL(c.ProxyURL)
+	}
+	if c.MaxIdleConns > 0 {
+		// Every request goes to the same host, so the limit per host is
+		// the limit overall.
+		t.MaxIdleConns = c.MaxIdleConns
+		t.MaxIdleConnsPerHost = c.MaxIdleConns
+	}
+	if c.DisableKeepAlives {
+		t.DisableKeepAlives = true
+	} else {
+		// Setting our own TLS config stops the transport from using HTTP/2
+		// by itself, so it's enabled explicitly. It's only used if the
+		// server supports it.
+		if err := http2.ConfigureTransport(t); err != nil {
+			return nil, err
+		}
+	}
+	var rt http.RoundTripper = t
+	if c.connStats != nil {
+		rt = c.connStats.instrument(t)
+	}
+	rc.HTTPClient.Transport = &userAgentTransport{
+		UserAgent: userAgent(c.UserAgent),
+		Transport: rt,
+	}
+	if len(c.Headers) > 0 {
+		rc.HTTPClient.Transport = &headersTransport{
+			Headers:   c.Headers,
+			Transport: rc.HTTPClient.Transport,
+		}
+	}
+	if c.BasicUser != "" {
+		rc.HTTPClient.Transport = &basicAuthTransport{
+			Username:  c.BasicUser,
+			Password:  c.BasicPass,
+			Transport: rc.HTTPClient.Transport,
+		}
+	}
 
-	c.HTTPClient = rc
 	return rc, nil
 }
 
+// do performs a request, retrying transient failures as described by
+// shouldRetry with a backoff between attempts. If the server asks for a
+// specific wait with a Retry-After header, that is used instead, with the
+// total of such waits capped at the configured timeout. If the context of
+// the request ends while waiting, its error is returned.
+func (c *stateClient) do(req *retryablehttp.Request) (*http.Response, error) {
+	// Each attempt gets its own timeout, within the context of the request
+	ctx := req.Context()
+
+	if id := c.correlation.Get(); id != "" {
+		req.Header.Set(correlationIDHeader, id)
+	}
+
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		c.logRequest(req.Method, req.URL, attempt)
+		start := c.clock().Now()
+		resp, err := c.doOnce(ctx, req)
+		dur := c.clock().Now().Sub(start)
+		c.observeRequest(req, resp, dur)
+		if resp != nil {
+			log.Printf("[DEBUG] backend/atlas: %s %s: %s in %s (request id: %s)",
+				req.Method, c.logURL(req.URL), resp.Status, dur,
+				resp.Header.Get(requestIDHeader))
+		}
+		if attempt >= c.RetryMax || !shouldRetry(req.Request, resp, err) {
+			if timeoutErr, ok := err.(*ErrRequestTimeout); ok {
+				timeoutErr.Attempts = attempt + 1
+			} else if err != nil && attempt > 0 {
+				err = fmt.Errorf("%s %s giving up after %d attempts: %v",
+					req.Method, req.URL, attempt+1, err)
+			}
+
+			return resp, c.redactor.RedactError(err)
+		}
+
+		wait := c.retryWait(attempt)
+		if d, ok := retryAfter(resp, c.clock().Now()); ok {
+			wait = d
+			if c.Timeout > 0 && waited+wait > c.Timeout {
+				wait = c.Timeout - waited
+			}
+			if wait <= 0 {
+				return resp, nil
+			}
+			waited += wait
+		}
+
+		if resp != nil {
+			log.Printf("[DEBUG] backend/atlas: %s %s (status: %d): retrying in %s",
+				req.Method, c.logURL(req.URL), resp.StatusCode, wait)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			log.Printf("[DEBUG] backend/atlas: %s %s (error: %v): retrying in %s",
+				req.Method, c.logURL(req.URL), c.redactor.RedactError(err), wait)
+		}
+
+		// An interrupt shouldn't have to wait out the backoff
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.clock().After(wait):
+		}
+	}
+}
+
+// doOnce performs a single attempt of a request with the configured
+// timeout. If the request times out the error is an *ErrRequestTimeout.
+func (c *stateClient) doOnce(parent context.Context, req *retryablehttp.Request) (*http.Response, error) {
+	client, err := c.http()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Timeout <= 0 {
+		return client.Do(req)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, c.Timeout)
+	req.Request = req.Request.WithContext(ctx)
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &ErrRequestTimeout{
+				Method:  req.Method,
+				URL:     req.URL.String(),
+				Timeout: c.Timeout,
+			}
+		}
+
+		return nil, err
+	}
+
+	// The deadline covers reading the body, so only release the context
+	// once the body is closed.
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// clock returns the clock used to wait between retries.
+func (c *stateClient) clock() clock {
+	if c.Clock == nil {
+		return realClock{}
+	}
+
+	return c.Clock
+}
+
+// cancelBody is a response body that cancels its request's context when
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 // Atlas returns an HTTP 409 - Conflict if the pushed state reports the same
 // Serial number but the checksum of the raw content differs. This can
 // sometimes happen when Terraform changes state representation internally
@@ -267,46 +1252,81 @@ func (c *stateClient) http() (*retryablehttp.Client, error) {
 //
 // In other words, in this situation Terraform can override Atlas's detected
 // conflict by asserting that the state it is pushing is indeed correct.
-func (c *stateClient) handleConflict(msg string, state []byte) error {
+func (c *stateClient) handleConflict(msg string, state []byte, serial int64) error {
 	log.Printf("[DEBUG] Handling Atlas conflict response: %s", msg)
 
+	// The latest state is needed here, not the cached one, nor one from a
+	// read replica that may be behind
+	payload, err := c.getFrom(context.Background(), c.url())
+	if err != nil {
+		return conflictHandlingError(err)
+	}
+	if payload == nil {
+		return conflictHandlingError(errors.New("no remote state found"))
+	}
+
+	currentState, err := terraform.ReadState(bytes.NewReader(payload.Data))
+	if err != nil {
+		return conflictHandlingError(err)
+	}
+
+	conflictErr := &ErrStateSerialConflict{
+		LocalSerial:  serial,
+		RemoteSerial: currentState.Serial,
+		Message:      msg,
+	}
+
 	if c.conflictHandlingAttempted {
 		log.Printf("[DEBUG] Already attempted conflict resolution; returning conflict.")
-	} else {
-		c.conflictHandlingAttempted = true
-		log.Printf("[DEBUG] Atlas reported conflict, checking for equivalent states.")
+		return conflictErr
+	}
 
-		payload, err := c.Get()
-		if err != nil {
-			return conflictHandlingError(err)
-		}
+	c.conflictHandlingAttempted = true
+	log.Printf("[DEBUG] Atlas reported conflict, checking for equivalent states.")
 
-		currentState, err := terraform.ReadState(bytes.NewReader(payload.Data))
-		if err != nil {
-			return conflictHandlingError(err)
-		}
+	proposedState, err := terraform.ReadState(bytes.NewReader(state))
+	if err != nil {
+		return conflictHandlingError(err)
+	}
 
-		proposedState, err := terraform.ReadState(bytes.NewReader(state))
-		if err != nil {
-			return conflictHandlingError(err)
-		}
+	if !statesAreEquivalent(currentState, proposedState) {
+		log.Printf("[DEBUG] States are not equivalent, returning conflict.")
+		return conflictErr
+	}
 
-		if statesAreEquivalent(currentState, proposedState) {
-			log.Printf("[DEBUG] States are equivalent, incrementing serial and retrying.")
-			proposedState.Serial++
-			var buf bytes.Buffer
-			if err := terraform.WriteState(proposedState, &buf); err != nil {
-				return conflictHandlingError(err)
+	log.Printf("[DEBUG] States are equivalent, incrementing serial and retrying.")
+	proposedState.Serial++
+	var buf bytes.Buffer
+	if err := terraform.WriteState(proposedState, &buf); err != nil {
+		return conflictHandlingError(err)
+	}
+	return c.put(buf.Bytes())
+}
 
-			}
-			return c.Put(buf.Bytes())
-		} else {
-			log.Printf("[DEBUG] States are not equivalent, returning conflict.")
+// decryptState returns the state read from Atlas decrypted, if a Cipher is
+// set. Without one, an encrypted state is an error rather than being read
+// as an empty state.
+func (c *stateClient) decryptState(data []byte) ([]byte, error) {
+	if c.Cipher == nil {
+		if isEncryptedState(data) {
+			return nil, ErrStateEncrypted
 		}
+
+		return data, nil
 	}
 
-	return fmt.Errorf(
-		"Atlas detected a remote state conflict.\n\nMessage: %s", msg)
+	return c.Cipher.Decrypt(data)
+}
+
+// decodeState returns a state read from Atlas, after it's decompressed, as
+// Terraform writes it.
+func (c *stateClient) decodeState(data []byte) ([]byte, error) {
+	data, err := c.decryptState(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.deserializeState(data)
 }
 
 func conflictHandlingError(err error) error {
@@ -314,6 +1334,54 @@ func conflictHandlingError(err error) error {
 		"Error while handling a conflict response from Atlas: %s", err)
 }
 
+// readSerial extracts the serial from a raw JSON encoded state without
+// fully loading it, since loading may alter the state.
+func readSerial(state []byte) (int64, error) {
+	var s struct {
+		Serial int64 `json:"serial"`
+	}
+	if err := json.Unmarshal(state, &s); err != nil {
+		return 0, fmt.Errorf("Failed to read serial from state: %v", err)
+	}
+
+	return s.Serial, nil
+}
+
+// readLineage extracts the lineage from a raw JSON encoded state.
+func readLineage(state []byte) (string, error) {
+	var s struct {
+		Lineage string `json:"lineage"`
+	}
+	if err := json.Unmarshal(state, &s); err != nil {
+		return "", fmt.Errorf("Failed to read lineage from state: %v", err)
+	}
+
+	return s.Lineage, nil
+}
+
+func compressState(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func uncompressState(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return ioutil.ReadAll(gz)
+}
+
 func statesAreEquivalent(current, proposed *terraform.State) bool {
 	return current.Serial == proposed.Serial && current.Equal(proposed)
 }
@@ -5,16 +5,22 @@ import (
 	"crypto/md5"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/terraform/backend"
 	"github.com/hashicorp/terraform/helper/acctest"
+	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/state/remote"
 	"github.com/hashicorp/terraform/terraform"
 )
@@ -32,6 +38,7 @@ func testStateClient(t *testing.T, c map[string]interface{}) remote.Client {
 
 func TestStateClient_impl(t *testing.T) {
 	var _ remote.Client = new(stateClient)
+	var _ remote.ClientLocker = new(stateClient)
 }
 
 func TestStateClient(t *testing.T) {
@@ -69,7 +76,7 @@ func TestStateClient_noRetryOnBadCerts(t *testing.T) {
 	brokenCfg := &tls.Config{
 		RootCAs: new(x509.CertPool),
 	}
-	httpClient.HTTPClient.Transport.(*http.Transport).TLSClientConfig = brokenCfg
+	testTransport(t, httpClient.HTTPClient.Transport).TLSClientConfig = brokenCfg
 
 	// Instrument CheckRetry to make sure we didn't retry
 	retries := 0
@@ -147,6 +154,181 @@ func TestStateClient_NoConflict(t *testing.T) {
 	}
 }
 
+func TestStateClient_serialFirstWrite(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+	if _, err := client.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := client.Put(testStateBytes(t, terraform.NewState())); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if serial := fakeAtlas.CurrentSerial(); serial != 1 {
+		t.Fatalf("expected serial 1, got %d", serial)
+	}
+}
+
+func TestStateClient_serialSubsequentWrite(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	fakeAtlas.noConflictAllowed = true
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+	if _, err := client.Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Each write follows the last one, even though the serial of the
+	// state being written never changes
+	s := terraform.NewState()
+	for i := 1; i <= 3; i++ {
+		s.RootModule().Outputs["count"] = &terraform.OutputState{
+			Type:  "string",
+			Value: strconv.Itoa(i),
+		}
+		if err := client.Put(testStateBytes(t, s)); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if serial := fakeAtlas.CurrentSerial(); serial != int64(i) {
+			t.Fatalf("expected serial %d, got %d", i, serial)
+		}
+	}
+}
+
+func TestStateClient_serialCached(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	fakeAtlas.noConflictAllowed = true
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/api/v1/terraform/state/") {
+			atomic.AddInt32(&gets, 1)
+		}
+		fakeAtlas.handler(resp, req)
+	}))
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A change written with the serial that was read gets the next one,
+	// without reading the state again
+	s, err := terraform.ReadState(bytes.NewReader(payload.Data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	s.RootModule().Outputs["drift"] = &terraform.OutputState{
+		Type:  "string",
+		Value: "fixed",
+	}
+	if err := client.Put(testStateBytes(t, s)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if serial := fakeAtlas.CurrentSerial(); serial != 3 {
+		t.Fatalf("expected serial 3, got %d", serial)
+	}
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Fatalf("expected 1 read of the state, got %d", n)
+	}
+}
+
+func TestStateClient_readAddress(t *testing.T) {
+	primary := newFakeAtlas(t, testStateSimple)
+	primarySrv := primary.Server()
+	defer primarySrv.Close()
+
+	replicaState := terraform.NewState()
+	replicaState.Lineage = "c00ad9ac-9b35-42fe-846e-b06f0ef877e9"
+	replicaState.Serial = 2
+	replica := newFakeAtlas(t, testStateBytes(t, replicaState))
+	replicaSrv := replica.Server()
+	defer replicaSrv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      primarySrv.URL,
+		"read_address": replicaSrv.URL,
+	})
+
+	// Reads hit the replica
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if payload == nil || !bytes.Equal(payload.Data, replica.state) {
+		t.Fatalf("expected the replica's state, got: %#v", payload)
+	}
+
+	// Writes hit the primary
+	if err := client.Put(testStateBytes(t, replicaState)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if primary.puts != 1 || replica.puts != 0 {
+		t.Fatalf("bad: %d puts to the primary, %d to the replica", primary.puts, replica.puts)
+	}
+}
+
+func TestStateClient_readAddressFallback(t *testing.T) {
+	primary := newFakeAtlas(t, testStateSimple)
+	primarySrv := primary.Server()
+	defer primarySrv.Close()
+
+	// The replica hasn't caught up yet
+	replica := newFakeAtlas(t, nil)
+	replicaSrv := replica.Server()
+	defer replicaSrv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      primarySrv.URL,
+		"read_address": replicaSrv.URL,
+	})
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if payload == nil || !bytes.Equal(payload.Data, testStateSimple) {
+		t.Fatalf("expected the primary's state, got: %#v", payload)
+	}
+
+	// Without the fallback, the replica's answer stands
+	client = testStateClient(t, map[string]interface{}{
+		"access_token":  "sometoken",
+		"name":          "someuser/some-test-remote-state",
+		"address":       primarySrv.URL,
+		"read_address":  replicaSrv.URL,
+		"read_fallback": false,
+	})
+	payload, err = client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if payload != nil {
+		t.Fatalf("expected no state, got: %#v", payload)
+	}
+}
+
 func TestStateClient_LegitimateConflict(t *testing.T) {
 	fakeAtlas := newFakeAtlas(t, testStateSimple)
 	srv := fakeAtlas.Server()
@@ -156,38 +338,500 @@ func TestStateClient_LegitimateConflict(t *testing.T) {
 		"access_token": "sometoken",
 		"name":         "someuser/some-test-remote-state",
 		"address":      srv.URL,
-	})
+	})
+
+	state, err := terraform.ReadState(bytes.NewReader(testStateSimple))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	terraform.WriteState(state, &buf)
+
+	// Changing the state but not the serial. Should generate a conflict.
+	state.RootModule().Outputs["drift"] = &terraform.OutputState{
+		Type:      "string",
+		Sensitive: false,
+		Value:     "happens",
+	}
+
+	var stateJson bytes.Buffer
+	if err := terraform.WriteState(state, &stateJson); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = client.Put(stateJson.Bytes())
+	if err == nil {
+		t.Fatal("Expected error from state conflict, got none.")
+	}
+
+	conflictErr, ok := err.(*ErrStateSerialConflict)
+	if !ok {
+		t.Fatalf("expected *ErrStateSerialConflict, got %T: %s", err, err)
+	}
+	if conflictErr.LocalSerial != 2 || conflictErr.RemoteSerial != 2 {
+		t.Fatalf("bad serials: %#v", conflictErr)
+	}
+}
+
+func TestStateClient_PutSerial(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	state, err := terraform.ReadState(bytes.NewReader(testStateSimple))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	state.Serial++
+
+	var stateJson bytes.Buffer
+	if err := terraform.WriteState(state, &stateJson); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Put(stateJson.Bytes()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if fakeAtlas.lastSerial != "3" {
+		t.Fatalf("expected serial 3 to be sent, got %q", fakeAtlas.lastSerial)
+	}
+}
+
+func TestStateClient_LineageMismatch(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	state, err := terraform.ReadState(bytes.NewReader(testStateSimple))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	state.Lineage = "other-lineage"
+	state.Serial++
+
+	var stateJson bytes.Buffer
+	if err := terraform.WriteState(state, &stateJson); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = client.Put(stateJson.Bytes())
+	lineageErr, ok := err.(*ErrStateLineageMismatch)
+	if !ok {
+		t.Fatalf("expected *ErrStateLineageMismatch, got %T: %v", err, err)
+	}
+	if lineageErr.LocalLineage != "other-lineage" ||
+		lineageErr.RemoteLineage != "c00ad9ac-9b35-42fe-846e-b06f0ef877e9" {
+		t.Fatalf("bad lineages: %#v", lineageErr)
+	}
+	if !strings.Contains(err.Error(), "other-lineage") {
+		t.Fatalf("expected lineage in error, got: %s", err)
+	}
+	if fakeAtlas.lastSerial != "" {
+		t.Fatalf("state shouldn't have been written, got serial %q", fakeAtlas.lastSerial)
+	}
+
+	// Forcing the write should succeed.
+	client.(*stateClient).Force = true
+	if err := client.Put(stateJson.Bytes()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fakeAtlas.lastSerial != "3" {
+		t.Fatalf("expected serial 3 to be sent, got %q", fakeAtlas.lastSerial)
+	}
+}
+
+func TestStateClient_cache(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	var gets int32
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		fakeAtlas.handler(resp, req)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	s, err := b.State(backend.DefaultStateName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Refreshing twice only reads the state once
+	for i := 0; i < 2; i++ {
+		if err := s.RefreshState(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Fatalf("expected 1 GET, got %d", n)
+	}
+
+	// Writing the state invalidates the cache, so the next refresh sees
+	// the new serial.
+	current := s.State()
+	current.Serial++
+	if err := s.WriteState(current); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	atomic.StoreInt32(&gets, 0)
+	if err := s.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Fatalf("expected 1 GET, got %d", n)
+	}
+	if serial := s.State().Serial; serial != 3 {
+		t.Fatalf("expected serial 3, got %d", serial)
+	}
+
+	// The cache belongs to the state, so another state reads it again
+	other, err := b.State(backend.DefaultStateName)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := other.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := atomic.LoadInt32(&gets); n != 2 {
+		t.Fatalf("expected 2 GETs, got %d", n)
+	}
+}
+
+func TestStateClient_UnresolvableConflict(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+
+	// Something unexpected causes Atlas to conflict in a way that we can't fix.
+	fakeAtlas.AlwaysConflict(true)
+
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	state, err := terraform.ReadState(bytes.NewReader(testStateSimple))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var stateJson bytes.Buffer
+	if err := terraform.WriteState(state, &stateJson); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		if err := client.Put(stateJson.Bytes()); err == nil {
+			t.Fatal("Expected error from state conflict, got none.")
+		}
+	}()
+
+	select {
+	case <-doneCh:
+		// OK
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("Timed out after 500ms, probably because retrying infinitely.")
+	}
+}
+
+func TestStateClient_GetMD5(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	sum := md5.Sum(testStateSimple)
+	if !bytes.Equal(payload.MD5, sum[:]) {
+		t.Fatalf("bad md5: %x", payload.MD5)
+	}
+}
+
+func TestStateClient_GetMD5Mismatch(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	sum := md5.Sum(testStateModuleOrderChange)
+	fakeAtlas.md5 = sum[:]
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	_, err := client.Get()
+	if err == nil {
+		t.Fatal("expected MD5 mismatch error")
+	}
+	if !strings.Contains(err.Error(), "state MD5 mismatch") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestStateClient_PutMD5(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	fakeAtlas.verifyMD5 = true
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	if err := client.Put(testStateSimple); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestStateClient_gzip(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	fakeAtlas.gzip = true
+	fakeAtlas.verifyMD5 = true
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	original, err := terraform.ReadState(bytes.NewReader(testStateSimple))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	original.Serial++
+
+	var stateJson bytes.Buffer
+	if err := terraform.WriteState(original, &stateJson); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Put(stateJson.Bytes()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual, err := terraform.ReadState(bytes.NewReader(payload.Data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !actual.Equal(original) || actual.Serial != original.Serial {
+		t.Fatalf("bad state after round trip: %s", actual)
+	}
+}
+
+func TestStateClient_gzipDisabled(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	fakeAtlas.gzip = true
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"gzip":         false,
+	})
+
+	payload, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(payload.Data, testStateSimple) {
+		t.Fatalf("bad: %s", payload.Data)
+	}
+}
+
+func TestStateClient_Lock(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	})
+
+	lockInfo := state.NewLockInfo()
+	lockInfo.Operation = "test"
+	lockID, err := client.(*stateClient).Lock(lockInfo)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if lockID != lockInfo.ID {
+		t.Fatalf("bad lock id: %q", lockID)
+	}
+	if fakeAtlas.lock == nil || fakeAtlas.lock.ID != lockID {
+		t.Fatalf("lock not recorded: %#v", fakeAtlas.lock)
+	}
+
+	if err := client.(*stateClient).Unlock(lockID); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fakeAtlas.lock != nil {
+		t.Fatalf("lock not released: %#v", fakeAtlas.lock)
+	}
+}
+
+func TestStateClient_LockAlreadyLocked(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	existing := state.NewLockInfo()
+	existing.Operation = "apply"
+	fakeAtlas.lock = existing
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	}).(*stateClient)
 
-	state, err := terraform.ReadState(bytes.NewReader(testStateSimple))
-	if err != nil {
-		t.Fatalf("err: %s", err)
+	_, err := client.Lock(state.NewLockInfo())
+	lockErr, ok := err.(*state.LockError)
+	if !ok {
+		t.Fatalf("expected *state.LockError, got %T: %v", err, err)
+	}
+	if lockErr.Info == nil || lockErr.Info.ID != existing.ID {
+		t.Fatalf("bad lock info: %#v", lockErr.Info)
 	}
 
-	var buf bytes.Buffer
-	terraform.WriteState(state, &buf)
+	// Unlocking with the wrong ID must not release the lock
+	if err := client.Unlock("not-the-id"); err == nil {
+		t.Fatal("expected error unlocking with the wrong id")
+	}
+	if fakeAtlas.lock == nil {
+		t.Fatal("lock was released with the wrong id")
+	}
 
-	// Changing the state but not the serial. Should generate a conflict.
-	state.RootModule().Outputs["drift"] = &terraform.OutputState{
-		Type:      "string",
-		Sensitive: false,
-		Value:     "happens",
+	if err := client.Unlock(existing.ID); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fakeAtlas.lock != nil {
+		t.Fatal("lock not released")
 	}
+}
 
-	var stateJson bytes.Buffer
-	if err := terraform.WriteState(state, &stateJson); err != nil {
+func TestStateClient_LockTimeout(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	existing := state.NewLockInfo()
+	existing.Operation = "apply"
+	fakeAtlas.lock = existing
+
+	// The lock is released after it's been found held twice
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fakeAtlas.handler(w, r)
+		if strings.HasSuffix(r.URL.Path, "/lock") && fakeAtlas.lock == existing {
+			if attempts++; attempts == 2 {
+				fakeAtlas.lock = nil
+			}
+		}
+	}))
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"lock_timeout": "1m",
+	}).(*stateClient)
+	clock := &fakeClock{now: time.Now()}
+	client.Clock = clock
+
+	lockInfo := state.NewLockInfo()
+	lockID, err := client.Lock(lockInfo)
+	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
-	if err := client.Put(stateJson.Bytes()); err == nil {
-		t.Fatal("Expected error from state conflict, got none.")
+	if fakeAtlas.lock == nil || fakeAtlas.lock.ID != lockID {
+		t.Fatalf("lock not recorded: %#v", fakeAtlas.lock)
+	}
+	if len(clock.sleeps) != 2 {
+		t.Fatalf("expected 2 waits, got: %v", clock.sleeps)
+	}
+	if clock.sleeps[1] <= clock.sleeps[0] {
+		t.Fatalf("waits should back off: %v", clock.sleeps)
 	}
 }
 
-func TestStateClient_UnresolvableConflict(t *testing.T) {
+func TestStateClient_LockTimeoutExpired(t *testing.T) {
 	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	existing := state.NewLockInfo()
+	existing.Operation = "apply"
+	fakeAtlas.lock = existing
+	srv := fakeAtlas.Server()
+	defer srv.Close()
 
-	// Something unexpected causes Atlas to conflict in a way that we can't fix.
-	fakeAtlas.AlwaysConflict(true)
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"lock_timeout": "10s",
+	}).(*stateClient)
+	clock := &fakeClock{now: time.Now()}
+	client.Clock = clock
+
+	_, err := client.Lock(state.NewLockInfo())
+	lockErr, ok := err.(*state.LockError)
+	if !ok {
+		t.Fatalf("expected *state.LockError, got %T: %v", err, err)
+	}
+	if lockErr.Info == nil || lockErr.Info.ID != existing.ID {
+		t.Fatalf("bad lock info: %#v", lockErr.Info)
+	}
 
+	var waited time.Duration
+	for _, d := range clock.sleeps {
+		waited += d
+	}
+	if waited != 10*time.Second {
+		t.Fatalf("expected to wait 10s, waited %s", waited)
+	}
+}
+
+func TestStateClient_ForceUnlock(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	existing := state.NewLockInfo()
+	existing.Operation = "apply"
+	fakeAtlas.lock = existing
 	srv := fakeAtlas.Server()
 	defer srv.Close()
 
@@ -195,30 +839,285 @@ func TestStateClient_UnresolvableConflict(t *testing.T) {
 		"access_token": "sometoken",
 		"name":         "someuser/some-test-remote-state",
 		"address":      srv.URL,
-	})
+	}).(*stateClient)
 
-	state, err := terraform.ReadState(bytes.NewReader(testStateSimple))
+	// A different lock ID, such as one from a crashed run, leaves the
+	// current lock alone
+	if _, err := client.ForceUnlock("other-id"); err == nil {
+		t.Fatal("expected an error for a mismatched lock id")
+	}
+	if fakeAtlas.lock == nil {
+		t.Fatal("lock should be kept")
+	}
+
+	info, err := client.ForceUnlock(existing.ID)
 	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
+	if info == nil || info.ID != existing.ID {
+		t.Fatalf("bad discarded lock info: %#v", info)
+	}
+	if fakeAtlas.lock != nil {
+		t.Fatal("lock not released")
+	}
 
-	var stateJson bytes.Buffer
-	if err := terraform.WriteState(state, &stateJson); err != nil {
+	// Without an ID, any lock is released
+	fakeAtlas.lock = existing
+	if info, err := client.ForceUnlock(""); err != nil || info == nil {
+		t.Fatalf("bad: %#v, %v", info, err)
+	}
+	if fakeAtlas.lock != nil {
+		t.Fatal("lock not released")
+	}
+
+	// Unlocked state should be a no-op
+	info, err = client.ForceUnlock("")
+	if err != nil {
 		t.Fatalf("err: %s", err)
 	}
-	doneCh := make(chan struct{})
-	go func() {
-		defer close(doneCh)
-		if err := client.Put(stateJson.Bytes()); err == nil {
-			t.Fatal("Expected error from state conflict, got none.")
+	if info != nil {
+		t.Fatalf("expected no lock info, got: %#v", info)
+	}
+}
+
+func TestStateClient_Timeout(t *testing.T) {
+	var requests int32
+	done := make(chan struct{})
+	defer close(done)
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-done:
+		case <-req.Context().Done():
 		}
-	}()
+	}))
+	defer srv.Close()
 
-	select {
-	case <-doneCh:
-		// OK
-	case <-time.After(500 * time.Millisecond):
-		t.Fatalf("Timed out after 500ms, probably because retrying infinitely.")
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"timeout":      "50ms",
+	}).(*stateClient)
+
+	_, err := client.Get()
+	timeoutErr, ok := err.(*ErrRequestTimeout)
+	if !ok {
+		t.Fatalf("expected *ErrRequestTimeout, got: %#v", err)
+	}
+	if timeoutErr.Method != "GET" || timeoutErr.Timeout != 50*time.Millisecond {
+		t.Fatalf("bad: %#v", timeoutErr)
+	}
+
+	// Timed out requests shouldn't be retried
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+}
+
+func TestStateClient_TimeoutWrite(t *testing.T) {
+	cases := map[string]func(c *stateClient) error{
+		"Put": func(c *stateClient) error {
+			return c.Put(testStateBytes(t, terraform.NewState()))
+		},
+		"Lock": func(c *stateClient) error {
+			_, err := c.Lock(state.NewLockInfo())
+			return err
+		},
+	}
+
+	for name, f := range cases {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				// Only writes hang; there's no state to read
+				if req.Method != "PUT" {
+					resp.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				select {
+				case <-done:
+				case <-req.Context().Done():
+				}
+			}))
+			defer srv.Close()
+			defer close(done)
+
+			client := testStateClient(t, map[string]interface{}{
+				"access_token": "sometoken",
+				"name":         "someuser/some-test-remote-state",
+				"address":      srv.URL,
+				"timeout":      "50ms",
+			}).(*stateClient)
+
+			// A failed write keeps the timeout as the reason it failed
+			err := f(client)
+			if persistErr, ok := err.(*ErrStatePersistFailed); ok {
+				err = persistErr.Err
+			}
+			timeoutErr, ok := err.(*ErrRequestTimeout)
+			if !ok {
+				t.Fatalf("expected *ErrRequestTimeout, got: %#v", err)
+			}
+			if timeoutErr.Method != "PUT" || timeoutErr.Timeout != 50*time.Millisecond {
+				t.Fatalf("bad: %#v", timeoutErr)
+			}
+		})
+	}
+}
+
+func TestStateClient_MaxStateSize(t *testing.T) {
+	dir := testTempDir(t)
+	defer os.RemoveAll(dir)
+
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token":   "sometoken",
+		"name":           "someuser/some-test-remote-state",
+		"address":        srv.URL,
+		"max_state_size": 1024,
+		"backup_dir":     dir,
+	}).(*stateClient)
+
+	// A state with a large output is well over the limit
+	s := testSeedState("lineage", 1)
+	s.RootModule().Outputs = map[string]*terraform.OutputState{
+		"big": &terraform.OutputState{
+			Type:  "string",
+			Value: strings.Repeat("x", 2048),
+		},
+	}
+	data := testStateBytes(t, s)
+
+	// The state is still backed up, so the error is wrapped
+	err := client.Put(data)
+	persistErr, ok := err.(*ErrStatePersistFailed)
+	if !ok {
+		t.Fatalf("expected *ErrStatePersistFailed, got: %#v", err)
+	}
+	tooLarge, ok := persistErr.Err.(*ErrStateTooLarge)
+	if !ok {
+		t.Fatalf("expected *ErrStateTooLarge, got: %#v", persistErr.Err)
+	}
+	if tooLarge.Size != len(data) || tooLarge.MaxSize != 1024 {
+		t.Fatalf("bad: %#v", tooLarge)
+	}
+	if fakeAtlas.puts != 0 {
+		t.Fatalf("the state shouldn't be uploaded, got %d puts", fakeAtlas.puts)
+	}
+
+	// A state within the limit is written
+	if err := client.Put(testStateBytes(t, testSeedState("lineage", 1))); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fakeAtlas.puts != 1 {
+		t.Fatalf("expected 1 put, got %d", fakeAtlas.puts)
+	}
+}
+
+func TestStateClient_ETag(t *testing.T) {
+	state := testStateBytes(t, testSeedState("lineage", 1))
+	var full, notModified int
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		full++
+		resp.Header().Set("ETag", `"v1"`)
+		resp.Write(state)
+	}))
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	}).(*stateClient)
+
+	for i := 0; i < 3; i++ {
+		client.invalidateCache()
+		payload, err := client.Get()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if payload == nil || !bytes.Equal(payload.Data, state) {
+			t.Fatalf("bad: %#v", payload)
+		}
+	}
+
+	if full != 1 || notModified != 2 {
+		t.Fatalf("expected 1 full read and 2 not modified, got %d and %d", full, notModified)
+	}
+}
+
+func TestStateClient_noETag(t *testing.T) {
+	state := testStateBytes(t, testSeedState("lineage", 1))
+	var conditional int
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") != "" {
+			conditional++
+		}
+
+		resp.Write(state)
+	}))
+	defer srv.Close()
+
+	client := testStateClient(t, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+	}).(*stateClient)
+
+	for i := 0; i < 2; i++ {
+		client.invalidateCache()
+		if _, err := client.Get(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if conditional != 0 {
+		t.Fatalf("expected no conditional reads, got %d", conditional)
+	}
+}
+
+func TestStateClient_authErrors(t *testing.T) {
+	cases := map[int]error{
+		http.StatusUnauthorized: ErrUnauthorized,
+		http.StatusForbidden:    ErrForbidden,
+	}
+
+	for status, expected := range cases {
+		srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+			resp.WriteHeader(status)
+		}))
+
+		client := testStateClient(t, map[string]interface{}{
+			"access_token": "sometoken",
+			"name":         "someuser/some-test-remote-state",
+			"address":      srv.URL,
+		}).(*stateClient)
+
+		if _, err := client.Get(); err != expected {
+			t.Fatalf("%d: Get: expected %v, got %v", status, expected, err)
+		}
+		if err := client.Put(testStateSimple); err != expected {
+			t.Fatalf("%d: Put: expected %v, got %v", status, expected, err)
+		}
+		if err := client.Delete(); err != expected {
+			t.Fatalf("%d: Delete: expected %v, got %v", status, expected, err)
+		}
+		if _, err := client.Lock(state.NewLockInfo()); err != expected {
+			t.Fatalf("%d: Lock: expected %v, got %v", status, expected, err)
+		}
+
+		srv.Close()
 	}
 }
 
@@ -233,6 +1132,46 @@ type fakeAtlas struct {
 
 	// Used to fail the test immediately if a conflict happens.
 	noConflictAllowed bool
+
+	// The serial query parameter sent with the last PUT, and the number
+	// of PUTs of the state.
+	lastSerial string
+	puts       int
+
+	// If set, md5 is sent as the Content-MD5 of GET responses instead of the
+	// real checksum of the state.
+	md5 []byte
+
+	// Used to verify the Content-MD5 header of uploaded states.
+	verifyMD5 bool
+
+	// If set, GET responses are gzip compressed when the client accepts it.
+	gzip bool
+
+	// The currently held lock, if any.
+	lock *state.LockInfo
+
+	// Historical versions of the state, by version number.
+	versions map[int][]byte
+
+	// The body of the last upload of run variables.
+	variables []byte
+
+	// The bodies of the runs created, in order.
+	runs [][]byte
+
+	// Whether the environment is archived, which blocks writes of the state.
+	archived bool
+
+	// The saved plans and the MD5 each was saved with, by ID.
+	plans    map[string][]byte
+	planSums map[string][md5.Size]byte
+
+	// The VCS repository the environment is connected to, if any.
+	vcsRepo string
+
+	// Whether the environment is protected from destroys.
+	destroyLocked bool
 }
 
 func newFakeAtlas(t *testing.T, state []byte) *fakeAtlas {
@@ -250,6 +1189,9 @@ func (f *fakeAtlas) CurrentState() *terraform.State {
 	// we read the state manually here, because terraform may alter state
 	// during read
 	currentState := &terraform.State{}
+	if len(f.state) == 0 {
+		return currentState
+	}
 	err := json.Unmarshal(f.state, currentState)
 	if err != nil {
 		f.t.Fatalf("err: %s", err)
@@ -285,14 +1227,176 @@ func (f *fakeAtlas) handler(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The token has access to every organization
+	if strings.HasPrefix(req.URL.Path, "/api/v1/organizations/") {
+		resp.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The settings of the environment
+	if env := strings.TrimPrefix(req.URL.Path, "/api/v1/environments/"); env != req.URL.Path &&
+		strings.Count(env, "/") == 1 && req.Method == "GET" {
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"environment": &environmentSettings{
+				VCSRepo:       f.vcsRepo,
+				DestroyLocked: f.destroyLocked,
+			},
+		})
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/lock") {
+		f.lockHandler(resp, req)
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/archive") {
+		switch req.Method {
+		case "POST":
+			f.archived = true
+		case "DELETE":
+			if !f.archived {
+				http.Error(resp, "not archived", http.StatusNotFound)
+				return
+			}
+			f.archived = false
+		}
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/variables") && req.Method == "PUT" {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			f.t.Fatalf("err: %s", err)
+		}
+		f.variables = body
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/plans") && req.Method == "POST" {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			f.t.Fatalf("err: %s", err)
+		}
+		hash := md5.Sum(body)
+		if req.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(hash[:]) {
+			http.Error(resp, "plan MD5 mismatch", http.StatusBadRequest)
+			return
+		}
+		if req.URL.Query().Get("name") == "" {
+			http.Error(resp, "missing plan name", http.StatusBadRequest)
+			return
+		}
+
+		if f.plans == nil {
+			f.plans = make(map[string][]byte)
+			f.planSums = make(map[string][md5.Size]byte)
+		}
+		id := "plan-" + strconv.Itoa(len(f.plans)+1)
+		f.plans[id] = body
+		f.planSums[id] = hash
+
+		resp.WriteHeader(http.StatusCreated)
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"plan": map[string]string{"id": id},
+		})
+		return
+	}
+
+	if i := strings.Index(req.URL.Path, "/plans/"); i >= 0 && req.Method == "GET" {
+		id := req.URL.Path[i+len("/plans/"):]
+		body, ok := f.plans[id]
+		if !ok {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		hash := f.planSums[id]
+		resp.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(hash[:]))
+		resp.Write(body)
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/runs") && req.Method == "POST" {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			f.t.Fatalf("err: %s", err)
+		}
+		f.runs = append(f.runs, body)
+
+		resp.WriteHeader(http.StatusCreated)
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"run": &Run{ID: "run-" + strconv.Itoa(len(f.runs)), Status: "pending"},
+		})
+		return
+	}
+
+	if i := strings.Index(req.URL.Path, "/versions/"); i >= 0 {
+		v, err := strconv.Atoi(req.URL.Path[i+len("/versions/"):])
+		if err != nil {
+			http.Error(resp, "bad version", http.StatusBadRequest)
+			return
+		}
+		data, ok := f.versions[v]
+		if !ok {
+			http.NotFound(resp, req)
+			return
+		}
+		resp.Write(data)
+		return
+	}
+
 	switch req.Method {
 	case "GET":
 		// Respond with the current stored state.
+		sum := f.CurrentSum()
+		checksum := sum[:]
+		if f.md5 != nil {
+			checksum = f.md5
+		}
+		body := f.state
+		if f.gzip && req.Header.Get("Accept-Encoding") == "gzip" {
+			var err error
+			body, err = compressState(f.state)
+			if err != nil {
+				f.t.Fatalf("err: %s", err)
+			}
+			sum := md5.Sum(body)
+			checksum = sum[:]
+			resp.Header().Set("Content-Encoding", "gzip")
+		}
+		resp.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(checksum))
 		resp.Header().Set("Content-Type", "application/json")
-		resp.Write(f.state)
+		resp.Write(body)
 	case "PUT":
+		if f.archived {
+			http.Error(resp, "environment is archived", http.StatusLocked)
+			return
+		}
+
+		f.lastSerial = req.URL.Query().Get("serial")
+		f.puts++
+
 		var buf bytes.Buffer
 		buf.ReadFrom(req.Body)
+
+		if f.verifyMD5 {
+			sum := md5.Sum(buf.Bytes())
+			expected := base64.StdEncoding.EncodeToString(sum[:])
+			if got := req.Header.Get("Content-MD5"); got != expected {
+				http.Error(resp, "bad Content-MD5", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			data, err := uncompressState(buf.Bytes())
+			if err != nil {
+				f.t.Fatalf("err: %s", err)
+			}
+			buf.Reset()
+			buf.Write(data)
+		}
 		sum := md5.Sum(buf.Bytes())
 
 		// we read the state manually here, because terraform may alter state
@@ -303,7 +1407,8 @@ func (f *fakeAtlas) handler(resp http.ResponseWriter, req *http.Request) {
 			f.t.Fatalf("err: %s", err)
 		}
 
-		conflict := f.CurrentSerial() == state.Serial && f.CurrentSum() != sum
+		conflict := len(f.state) > 0 &&
+			f.CurrentSerial() == state.Serial && f.CurrentSum() != sum
 		conflict = conflict || f.alwaysConflict
 		if conflict {
 			if f.noConflictAllowed {
@@ -317,6 +1422,33 @@ func (f *fakeAtlas) handler(resp http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (f *fakeAtlas) lockHandler(resp http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		if f.lock == nil {
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+		resp.Write(f.lock.Marshal())
+	case "PUT":
+		if f.lock != nil {
+			resp.WriteHeader(http.StatusConflict)
+			resp.Write(f.lock.Marshal())
+			return
+		}
+
+		info := &state.LockInfo{}
+		if err := json.NewDecoder(req.Body).Decode(info); err != nil {
+			f.t.Fatalf("err: %s", err)
+		}
+		f.lock = info
+		resp.WriteHeader(http.StatusOK)
+	case "DELETE":
+		f.lock = nil
+		resp.WriteHeader(http.StatusNoContent)
+	}
+}
+
 // This is a tfstate file with the module order changed, which is a structural
 // but not a semantic difference. Terraform will sort these modules as it
 // loads the state.
@@ -0,0 +1,116 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// StateDiff is the difference between the resources of a local state and
+// those of the state stored in Atlas. Resources are identified by their
+// address, such as "module.child.aws_instance.foo", and each list is
+// sorted.
+type StateDiff struct {
+	// LocalOnly are the resources only in the local state.
+	LocalOnly []string
+
+	// RemoteOnly are the resources only in the state in Atlas.
+	RemoteOnly []string
+
+	// Changed are the resources in both states that differ between them.
+	Changed []string
+}
+
+// Empty returns true if the states have the same resources.
+func (d *StateDiff) Empty() bool {
+	return len(d.LocalOnly) == 0 && len(d.RemoteOnly) == 0 && len(d.Changed) == 0
+}
+
+// String returns a summary of the counts, followed by the addresses of the
+// resources that differ.
+func (d *StateDiff) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d only in the local state, %d only in Atlas, %d changed",
+		len(d.LocalOnly), len(d.RemoteOnly), len(d.Changed))
+
+	for _, addr := range d.LocalOnly {
+		fmt.Fprintf(&buf, "\n  + %s", addr)
+	}
+	for _, addr := range d.RemoteOnly {
+		fmt.Fprintf(&buf, "\n  - %s", addr)
+	}
+	for _, addr := range d.Changed {
+		fmt.Fprintf(&buf, "\n  ~ %s", addr)
+	}
+
+	return buf.String()
+}
+
+// DiffState compares the given local state to the state of the configured
+// environment in Atlas, such as to check that they match before moving from
+// one to the other. It only reads the state, so it takes no locks. An
+// environment without a state is compared as an empty state.
+func (b *Backend) DiffState(ctx context.Context, local *terraform.State) (StateDiff, error) {
+	if b.stateClient == nil {
+		return StateDiff{}, errNotConfigured
+	}
+
+	remote, err := b.FetchState(ctx)
+	if err != nil {
+		return StateDiff{}, err
+	}
+
+	return diffStates(local, remote), nil
+}
+
+// diffStates returns the difference between the resources of two states,
+// either of which may be nil.
+func diffStates(local, remote *terraform.State) StateDiff {
+	localResources := stateResources(local)
+	remoteResources := stateResources(remote)
+
+	var diff StateDiff
+	for addr, l := range localResources {
+		r, ok := remoteResources[addr]
+		switch {
+		case !ok:
+			diff.LocalOnly = append(diff.LocalOnly, addr)
+		case !l.Equal(r):
+			diff.Changed = append(diff.Changed, addr)
+		}
+	}
+	for addr := range remoteResources {
+		if _, ok := localResources[addr]; !ok {
+			diff.RemoteOnly = append(diff.RemoteOnly, addr)
+		}
+	}
+
+	sort.Strings(diff.LocalOnly)
+	sort.Strings(diff.RemoteOnly)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// stateResources returns the resources of a state by their address.
+func stateResources(s *terraform.State) map[string]*terraform.ResourceState {
+	result := make(map[string]*terraform.ResourceState)
+	if s == nil {
+		return result
+	}
+
+	for _, m := range s.Modules {
+		var prefix string
+		for _, name := range m.Path[1:] {
+			prefix += "module." + name + "."
+		}
+
+		for k, r := range m.Resources {
+			result[prefix+k] = r
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,91 @@
+package atlas
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestBackend_DiffState(t *testing.T) {
+	remote := testSeedState("lineage", 1)
+	remote.RootModule().Resources["test_instance.bar"] = &terraform.ResourceState{
+		Type:    "test_instance",
+		Primary: &terraform.InstanceState{ID: "bar"},
+	}
+	remote.AddModule([]string{"root", "child"}).Resources["test_instance.baz"] = &terraform.ResourceState{
+		Type:    "test_instance",
+		Primary: &terraform.InstanceState{ID: "baz"},
+	}
+
+	fakeAtlas := newFakeAtlas(t, testStateBytes(t, remote))
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+	b := testBackend(t, srv)
+
+	// The same resources don't differ
+	diff, err := b.DiffState(context.Background(), remote.DeepCopy())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !diff.Empty() {
+		t.Fatalf("expected no difference, got: %s", diff.String())
+	}
+
+	// One resource differs, one is missing and one is added
+	local := remote.DeepCopy()
+	local.RootModule().Resources["test_instance.foo"].Primary.ID = "changed"
+	delete(local.RootModule().Resources, "test_instance.bar")
+	local.RootModule().Resources["test_instance.new"] = &terraform.ResourceState{
+		Type:    "test_instance",
+		Primary: &terraform.InstanceState{ID: "new"},
+	}
+
+	diff, err = b.DiffState(context.Background(), local)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := StateDiff{
+		LocalOnly:  []string{"test_instance.new"},
+		RemoteOnly: []string{"test_instance.bar"},
+		Changed:    []string{"test_instance.foo"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("bad: %#v", diff)
+	}
+
+	expectedStr := `1 only in the local state, 1 only in Atlas, 1 changed
+  + test_instance.new
+  - test_instance.bar
+  ~ test_instance.foo`
+	if actual := diff.String(); actual != expectedStr {
+		t.Fatalf("bad:\n%s", actual)
+	}
+
+	if fakeAtlas.lock != nil || fakeAtlas.puts != 0 {
+		t.Fatal("DiffState should only read the state")
+	}
+}
+
+func TestBackend_DiffStateNoRemoteState(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+	b := testBackend(t, srv)
+
+	local := testSeedState("lineage", 1)
+	local.AddModule([]string{"root", "child"}).Resources["test_instance.baz"] = &terraform.ResourceState{
+		Type:    "test_instance",
+		Primary: &terraform.InstanceState{ID: "baz"},
+	}
+
+	diff, err := b.DiffState(context.Background(), local)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{"module.child.test_instance.baz", "test_instance.foo"}
+	if !reflect.DeepEqual(diff.LocalOnly, expected) {
+		t.Fatalf("bad: %#v", diff)
+	}
+}
@@ -0,0 +1,329 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// StateVersion is a single historical version of the state stored in Atlas.
+type StateVersion struct {
+	// Version is the Atlas version number of the stored state.
+	Version int `json:"version"`
+
+	// Serial is the serial of the state in this version.
+	Serial int64 `json:"serial"`
+
+	// CreatedAt is when this version was stored.
+	CreatedAt time.Time `json:"created_at"`
+
+	// MD5 is the hex encoded checksum of the stored state.
+	MD5 string `json:"md5"`
+}
+
+// ListStateVersions returns all the versions of the state of the
+// configured environment that Atlas has stored, following pagination if
+// the results span multiple pages.
+func (b *Backend) ListStateVersions(ctx context.Context) ([]StateVersion, error) {
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	return b.stateClient.listStateVersions(ctx)
+}
+
+func (c *stateClient) listStateVersions(ctx context.Context) ([]StateVersion, error) {
+	var result []StateVersion
+
+	next := c.versionsURL()
+	for next != nil {
+		var page struct {
+			Versions []StateVersion `json:"versions"`
+		}
+
+		header, err := c.getJSONPage(ctx, next, &page)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list state versions: %v", err)
+		}
+		result = append(result, page.Versions...)
+
+		next, err = nextPage(next, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// RollbackState restores the given version of the state of the configured
+// environment as the current state. The serial of the restored state is
+// bumped past the current serial so that it's accepted as the newest
+// state. It's an error if the version predates a lineage change, unless
+// force_lineage is set.
+func (b *Backend) RollbackState(ctx context.Context, version int) error {
+	if b.stateClient == nil {
+		return errNotConfigured
+	}
+
+	return b.stateClient.rollbackState(ctx, version)
+}
+
+func (c *stateClient) rollbackState(ctx context.Context, version int) error {
+	if c.dryRun("roll the state of %s back to version %d", path.Join(c.User, c.Name), version) {
+		return nil
+	}
+
+	data, err := c.getStateVersion(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	target, err := terraform.ReadState(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Failed to read state version %d: %v", version, err)
+	}
+
+	current, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		currentState, err := terraform.ReadState(bytes.NewReader(current.Data))
+		if err != nil {
+			return fmt.Errorf("Failed to read current state: %v", err)
+		}
+		if !c.Force {
+			if err := checkVersionLineage(version, data, current.Data); err != nil {
+				return err
+			}
+		}
+
+		target.Serial = currentState.Serial + 1
+	}
+
+	var buf bytes.Buffer
+	if err := terraform.WriteState(target, &buf); err != nil {
+		return err
+	}
+
+	// The lineage has been checked against the state just read, so it
+	// isn't read again to check it.
+	return c.writeState(buf.Bytes(), false)
+}
+
+// StateOutputsAt returns the root module outputs of the given version of
+// the state of the configured environment. It's an error if the version
+// has a different lineage than the current state, since its outputs then
+// belong to other infrastructure.
+func (b *Backend) StateOutputsAt(ctx context.Context, version int) (map[string]*terraform.OutputState, error) {
+	if b.stateClient == nil {
+		return nil, errNotConfigured
+	}
+
+	return b.stateClient.stateOutputsAt(ctx, version)
+}
+
+func (c *stateClient) stateOutputsAt(ctx context.Context, version int) (map[string]*terraform.OutputState, error) {
+	data, err := c.getStateVersion(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := terraform.ReadState(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read state version %d: %v", version, err)
+	}
+
+	current, err := c.Get()
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		if err := checkVersionLineage(version, data, current.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	outputs := make(map[string]*terraform.OutputState)
+	if root := target.RootModule(); root != nil {
+		for k, v := range root.Outputs {
+			outputs[k] = v
+		}
+	}
+
+	return outputs, nil
+}
+
+// checkVersionLineage returns an error if the given version of the state
+// predates a lineage change, that is if its lineage differs from that of
+// the current state. The lineages are read from the stored states, since
+// ReadState makes one up for a state without one, and a state without a
+// lineage matches any other.
+func checkVersionLineage(version int, data, current []byte) error {
+	lineage, err := readLineage(data)
+	if err != nil {
+		return err
+	}
+	currentLineage, err := readLineage(current)
+	if err != nil {
+		return err
+	}
+	if lineage == "" || currentLineage == "" || lineage == currentLineage {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"state version %d predates a lineage change: its lineage is %q,\n"+
+			"but the current state's lineage is %q",
+		version, lineage, currentLineage)
+}
+
+// getStateVersion returns the raw state stored for the given version.
+func (c *stateClient) getStateVersion(ctx context.Context, version int) ([]byte, error) {
+	u := c.versionsURL()
+	u.Path = path.Join(u.Path, strconv.Itoa(version))
+
+	req, err := retryablehttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Request = req.Request.WithContext(ctx)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Handled after
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("state version %d does not exist", version)
+	default:
+		return nil, c.httpError(resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read state version %d: %v", version, err)
+	}
+
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		data, err = uncompressState(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decompress state version %d: %v", version, err)
+		}
+	}
+
+	return c.decodeState(data)
+}
+
+// versionsURL returns the URL listing the stored versions of the state.
+func (c *stateClient) versionsURL() *url.URL {
+	u := c.url()
+	u.Path = path.Join(u.Path, "versions")
+	return u
+}
+
+// getJSON performs a GET request and decodes the JSON response into v. The
+// value of the Link header is returned so that callers can paginate.
+func (c *stateClient) getJSON(u *url.URL, v interface{}) (string, error) {
+	header, err := c.getJSONPage(context.Background(), u, v)
+	if err != nil {
+		return "", err
+	}
+
+	return header.Get("Link"), nil
+}
+
+// getJSONPage performs a GET request with the given context and decodes the
+// JSON response into v. The response headers are returned so that callers
+// can paginate with nextPage.
+func (c *stateClient) getJSONPage(ctx context.Context, u *url.URL, v interface{}) (http.Header, error) {
+	req, err := retryablehttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Request = req.Request.WithContext(ctx)
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.httpError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("Failed to decode response: %v", err)
+	}
+
+	return resp.Header, nil
+}
+
+var nextLinkRegexp = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// nextPageURL returns the URL of the next page from the value of a Link
+// header, resolved relative to the current URL. If there is no next page,
+// nil is returned.
+func nextPageURL(current *url.URL, link string) (*url.URL, error) {
+	m := nextLinkRegexp.FindStringSubmatch(link)
+	if m == nil {
+		return nil, nil
+	}
+
+	next, err := url.Parse(m[1])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse next page URL %q: %v", m[1], err)
+	}
+
+	return current.ResolveReference(next), nil
+}
+
+// nextPage returns the URL of the next page of results given the headers
+// of the current page. The Link header is preferred; if there isn't one,
+// the next_page of the X-Pagination header is used as the page parameter.
+// If there is no next page, nil is returned.
+func nextPage(current *url.URL, header http.Header) (*url.URL, error) {
+	if link := header.Get("Link"); link != "" {
+		return nextPageURL(current, link)
+	}
+
+	raw := header.Get("X-Pagination")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var pagination struct {
+		NextPage int `json:"next_page"`
+	}
+	if err := json.Unmarshal([]byte(raw), &pagination); err != nil {
+		return nil, fmt.Errorf("Failed to parse X-Pagination header %q: %v", raw, err)
+	}
+	if pagination.NextPage == 0 {
+		return nil, nil
+	}
+
+	next := *current
+	values := next.Query()
+	values.Set("page", strconv.Itoa(pagination.NextPage))
+	next.RawQuery = values.Encode()
+	return &next, nil
+}
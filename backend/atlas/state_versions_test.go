@@ -0,0 +1,223 @@
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestBackend_ListStateVersions(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/terraform/state/someuser/some-test-remote-state/versions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, srv.URL, r.URL.Path))
+			fmt.Fprint(w, `{"versions": [
+				{"version": 2, "serial": 5, "created_at": "2017-03-01T10:00:00Z", "md5": "abc", "extra": true}
+			]}`)
+		case "2":
+			fmt.Fprint(w, `{"versions": [
+				{"version": 1, "serial": 3, "created_at": "2017-02-01T10:00:00Z", "md5": "def"}
+			]}`)
+		}
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	versions, err := b.ListStateVersions(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []StateVersion{
+		{
+			Version:   2,
+			Serial:    5,
+			CreatedAt: time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC),
+			MD5:       "abc",
+		},
+		{
+			Version:   1,
+			Serial:    3,
+			CreatedAt: time.Date(2017, 2, 1, 10, 0, 0, 0, time.UTC),
+			MD5:       "def",
+		},
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("bad: %#v", versions)
+	}
+}
+
+func TestBackend_ListStateVersionsNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if _, err := b.ListStateVersions(context.Background()); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	current := &url.URL{Scheme: "https", Host: "example.com", Path: "/a"}
+
+	cases := []struct {
+		Link     string
+		Expected string
+	}{
+		{"", ""},
+		{`<https://example.com/a?page=2>; rel="next"`, "https://example.com/a?page=2"},
+		{`</a?page=3>; rel="next", </a?page=1>; rel="prev"`, "https://example.com/a?page=3"},
+		{`</a?page=1>; rel="prev"`, ""},
+	}
+
+	for _, tc := range cases {
+		next, err := nextPageURL(current, tc.Link)
+		if err != nil {
+			t.Fatalf("%q: err: %s", tc.Link, err)
+		}
+
+		actual := ""
+		if next != nil {
+			actual = next.String()
+		}
+		if actual != tc.Expected {
+			t.Fatalf("%q: expected %q, got %q", tc.Link, tc.Expected, actual)
+		}
+	}
+}
+
+func TestBackend_RollbackState(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	fakeAtlas.versions = map[int][]byte{1: testStateModuleOrderChange}
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.RollbackState(context.Background(), 1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The rolled back state must be the head, with a serial past the
+	// previous head.
+	current := fakeAtlas.CurrentState()
+	if current.Serial != 3 {
+		t.Fatalf("expected serial 3, got %d", current.Serial)
+	}
+	if current.ModuleByPath([]string{"root", "child1", "grandchild"}) == nil {
+		t.Fatalf("expected version 1 to be restored, got: %s", current)
+	}
+	if fakeAtlas.lastSerial != "3" {
+		t.Fatalf("bad serial sent: %q", fakeAtlas.lastSerial)
+	}
+}
+
+func TestBackend_RollbackStateMissingVersion(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	err := b.RollbackState(context.Background(), 42)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "state version 42 does not exist") {
+		t.Fatalf("bad error: %s", err)
+	}
+	if !bytes.Equal(fakeAtlas.state, testStateSimple) {
+		t.Fatal("state should not be modified")
+	}
+}
+
+func TestBackend_RollbackStateLineage(t *testing.T) {
+	current := testStateOutputs(3, "lineage-a", "ip", "10.0.0.3")
+	fakeAtlas := newFakeAtlas(t, current)
+	fakeAtlas.versions = map[int][]byte{
+		1: testStateOutputs(1, "lineage-old", "ip", "10.0.0.1"),
+	}
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	err := b.RollbackState(context.Background(), 1)
+	if err == nil || !strings.Contains(err.Error(), "predates a lineage change") {
+		t.Fatalf("expected lineage error, got: %v", err)
+	}
+	if !bytes.Equal(fakeAtlas.state, current) {
+		t.Fatal("state should not be modified")
+	}
+}
+
+func TestBackend_RollbackStateNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if err := b.RollbackState(context.Background(), 1); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
+
+func TestBackend_StateOutputsAt(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateOutputs(3, "lineage-a", "ip", "10.0.0.3"))
+	fakeAtlas.versions = map[int][]byte{
+		1: testStateOutputs(1, "lineage-old", "ip", "10.0.0.1"),
+		2: testStateOutputs(2, "lineage-a", "ip", "10.0.0.2"),
+	}
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	outputs, err := b.StateOutputsAt(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(outputs) != 1 || outputs["ip"] == nil || outputs["ip"].Value != "10.0.0.2" {
+		t.Fatalf("bad: %#v", outputs)
+	}
+
+	_, err = b.StateOutputsAt(context.Background(), 1)
+	if err == nil || !strings.Contains(err.Error(), "predates a lineage change") {
+		t.Fatalf("expected lineage error, got: %v", err)
+	}
+}
+
+func TestBackend_StateOutputsAtNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if _, err := b.StateOutputsAt(context.Background(), 1); err != errNotConfigured {
+		t.Fatalf("expected errNotConfigured, got: %v", err)
+	}
+}
+
+// testStateOutputs returns a state with a single root module output.
+func testStateOutputs(serial int64, lineage, name, value string) []byte {
+	s := &terraform.State{
+		Version: terraform.StateVersion,
+		Serial:  serial,
+		Lineage: lineage,
+		Modules: []*terraform.ModuleState{
+			&terraform.ModuleState{
+				Path: []string{"root"},
+				Outputs: map[string]*terraform.OutputState{
+					name: &terraform.OutputState{Type: "string", Value: value},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := terraform.WriteState(s, &buf); err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
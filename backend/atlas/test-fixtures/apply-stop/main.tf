@@ -0,0 +1,13 @@
+resource "test_instance" "foo" {
+    ami = "foo"
+}
+
+resource "test_instance" "bar" {
+    ami = "bar"
+    depends_on = ["test_instance.foo"]
+}
+
+resource "test_instance" "baz" {
+    ami = "baz"
+    depends_on = ["test_instance.bar"]
+}
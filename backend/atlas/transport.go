@@ -0,0 +1,147 @@
+package atlas
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// defaultMaxIdleConns is how many idle connections are kept open if
+// max_idle_conns isn't set, which is the same as Go's default transport.
+const defaultMaxIdleConns = 100
+
+// defaultTLSMinVersion is the oldest version of TLS used to connect to
+// Atlas if tls_min_version isn't set.
+const defaultTLSMinVersion = "1.2"
+
+// tlsVersions are the versions of TLS that tls_min_version accepts.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// basicAuthTransport is an http.RoundTripper that adds HTTP basic auth
+// credentials to every request, for Atlas installations behind a gateway
+// that requires them. This is in addition to the Atlas token.
+type basicAuthTransport struct {
+	Username string
+	Password string
+
+	Transport http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.SetBasicAuth(t.Username, t.Password)
+	return t.Transport.RoundTrip(req)
+}
+
+func (t *basicAuthTransport) CloseIdleConnections() {
+	closeIdleConnections(t.Transport)
+}
+
+// userAgentTransport is an http.RoundTripper that sets the User-Agent of
+// every request, so that Atlas can tell requests from Terraform apart.
+type userAgentTransport struct {
+	UserAgent string
+
+	Transport http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.Header.Set("User-Agent", t.UserAgent)
+	return t.Transport.RoundTrip(req)
+}
+
+func (t *userAgentTransport) CloseIdleConnections() {
+	closeIdleConnections(t.Transport)
+}
+
+// userAgent returns the User-Agent to send to Atlas, with the given suffix
+// appended if it isn't empty.
+func userAgent(suffix string) string {
+	ua := fmt.Sprintf("Terraform/%s (atlas-backend)", terraform.VersionString())
+	if suffix != "" {
+		ua += " " + suffix
+	}
+
+	return ua
+}
+
+// headersTransport is an http.RoundTripper that adds custom headers to
+// every request, such as those required by a gateway in front of Atlas.
+type headersTransport struct {
+	Headers map[string]string
+
+	Transport http.RoundTripper
+}
+
+func (t *headersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return t.Transport.RoundTrip(req)
+}
+
+func (t *headersTransport) CloseIdleConnections() {
+	closeIdleConnections(t.Transport)
+}
+
+// closeIdleConnections closes the idle connections of rt, if it keeps any.
+// The transports above wrap the one that does, so they pass it on.
+func closeIdleConnections(rt http.RoundTripper) {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if c, ok := rt.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// reservedHeaders are the headers set by the client itself, which custom
+// headers may not override.
+var reservedHeaders = []string{
+	"Accept-Encoding",
+	"Authorization",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-MD5",
+	"Content-Type",
+	"Host",
+	"User-Agent",
+	atlasTokenHeader,
+	idempotencyKeyHeader,
+}
+
+// isReservedHeader returns true if the given header may not be set as a
+// custom header.
+func isReservedHeader(k string) bool {
+	k = http.CanonicalHeaderKey(k)
+	for _, reserved := range reservedHeaders {
+		if k == http.CanonicalHeaderKey(reserved) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cloneRequest returns a shallow copy of the request with its own copy of
+// the headers, since a RoundTripper must not modify the request it's given.
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+
+	return clone
+}
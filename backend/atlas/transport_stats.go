@@ -0,0 +1,139 @@
+package atlas
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// TransportStats is a snapshot of the backend's connections to Atlas, as
+// returned by Backend.TransportStats.
+type TransportStats struct {
+	// IdleConns are the open connections waiting in the pool for a
+	// request, and ActiveConns those serving one. With HTTP/2, several
+	// requests can share a connection, so ActiveConns is capped at the
+	// number of open connections.
+	IdleConns   int
+	ActiveConns int
+
+	// Requests is the total number of HTTP requests made to Atlas,
+	// including each retry.
+	Requests int64
+}
+
+// TransportStats returns a snapshot of the connections to Atlas of the
+// HTTP client shared by the backend, for diagnostics. Unlike Metrics, it
+// needs nothing to be set up. It's safe to call concurrently with
+// operations, and returns zeros if the backend isn't configured.
+func (b *Backend) TransportStats() TransportStats {
+	if b.stateClient == nil {
+		return TransportStats{}
+	}
+
+	return b.stateClient.connStats.Stats()
+}
+
+// transportCounters counts the connections made by a transport and the
+// requests made over them. It's updated atomically, so a nil
+// *transportCounters isn't counted and reads as zeros.
+type transportCounters struct {
+	open     int64
+	active   int64
+	requests int64
+}
+
+// Stats returns a snapshot of the counters.
+func (s *transportCounters) Stats() TransportStats {
+	if s == nil {
+		return TransportStats{}
+	}
+
+	open := atomic.LoadInt64(&s.open)
+	active := atomic.LoadInt64(&s.active)
+	if active > open {
+		active = open
+	}
+
+	return TransportStats{
+		IdleConns:   int(open - active),
+		ActiveConns: int(active),
+		Requests:    atomic.LoadInt64(&s.requests),
+	}
+}
+
+// instrument makes t count its connections and requests in s, returning
+// the RoundTripper to use in place of t.
+func (s *transportCounters) instrument(t *http.Transport) http.RoundTripper {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		atomic.AddInt64(&s.open, 1)
+		return &countedConn{Conn: conn, counters: s}, nil
+	}
+
+	return &countingTransport{counters: s, Transport: t}
+}
+
+// countedConn is a connection counted as open until it's closed.
+type countedConn struct {
+	net.Conn
+
+	counters *transportCounters
+	once     sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.counters.open, -1) })
+	return c.Conn.Close()
+}
+
+// countingTransport is an http.RoundTripper that counts each request, and
+// counts it as active until its response body is closed, which is when
+// its connection goes back to the pool.
+type countingTransport struct {
+	counters *transportCounters
+
+	Transport http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.counters.requests, 1)
+	atomic.AddInt64(&t.counters.active, 1)
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		atomic.AddInt64(&t.counters.active, -1)
+		return nil, err
+	}
+
+	resp.Body = &countedBody{ReadCloser: resp.Body, counters: t.counters}
+	return resp, nil
+}
+
+func (t *countingTransport) CloseIdleConnections() {
+	closeIdleConnections(t.Transport)
+}
+
+// countedBody is a response body whose request is counted as active until
+// it's closed.
+type countedBody struct {
+	io.ReadCloser
+
+	counters *transportCounters
+	once     sync.Once
+}
+
+func (b *countedBody) Close() error {
+	b.once.Do(func() { atomic.AddInt64(&b.counters.active, -1) })
+	return b.ReadCloser.Close()
+}
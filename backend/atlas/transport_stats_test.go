@@ -0,0 +1,46 @@
+package atlas
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBackend_TransportStats(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, testStateSimple)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+
+	// Configure has already made requests, such as to check access
+	before := b.TransportStats()
+	if before.Requests == 0 {
+		t.Fatal("the requests made by Configure should be counted")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.FetchState(context.Background()); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	stats := b.TransportStats()
+	if stats.Requests != before.Requests+3 {
+		t.Fatalf("expected %d requests, got %d", before.Requests+3, stats.Requests)
+	}
+
+	// Every response has been read, so the connection is back in the pool
+	if stats.ActiveConns != 0 {
+		t.Fatalf("expected no active connections, got %d", stats.ActiveConns)
+	}
+	if stats.IdleConns == 0 {
+		t.Fatal("expected an idle connection")
+	}
+}
+
+func TestBackend_TransportStatsNotConfigured(t *testing.T) {
+	b := &Backend{}
+	if stats := b.TransportStats(); stats != (TransportStats{}) {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
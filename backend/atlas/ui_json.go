@@ -0,0 +1,50 @@
+package atlas
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// outputFormatHuman and outputFormatJSON are the values of
+	// output_format.
+	outputFormatHuman = "human"
+	outputFormatJSON  = "json"
+)
+
+// jsonUi is a cli.Ui that writes each message as a JSON object on its own
+// line, for tools that parse the output of Terraform.
+type jsonUi struct {
+	cli.Ui
+}
+
+// jsonMessage is a single line of output from jsonUi.
+type jsonMessage struct {
+	// Type is the kind of message: output, info, warning or error.
+	Type string `json:"type"`
+
+	// Message is the text of the message, without any coloring.
+	Message string `json:"message"`
+}
+
+func (u *jsonUi) Output(msg string) { u.write("output", msg) }
+func (u *jsonUi) Info(msg string)   { u.write("info", msg) }
+func (u *jsonUi) Warn(msg string)   { u.write("warning", msg) }
+func (u *jsonUi) Error(msg string)  { u.write("error", msg) }
+
+func (u *jsonUi) write(typ, msg string) {
+	msg = strings.TrimSpace(msg)
+	if msg == "" {
+		return
+	}
+
+	raw, err := json.Marshal(&jsonMessage{Type: typ, Message: msg})
+	if err != nil {
+		// Marshaling a struct of strings can't fail
+		panic(err)
+	}
+
+	u.Ui.Output(string(raw))
+}
@@ -0,0 +1,112 @@
+package atlas
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/mitchellh/cli"
+	"github.com/mitchellh/colorstring"
+)
+
+func TestJSONUi(t *testing.T) {
+	ui := new(cli.MockUi)
+	u := &jsonUi{Ui: ui}
+
+	u.Output("Plan: 1 to add\n")
+	u.Warn("Warning: deprecated")
+	u.Error("Error: failed")
+	u.Info("")
+
+	expected := []jsonMessage{
+		{Type: "output", Message: "Plan: 1 to add"},
+		{Type: "warning", Message: "Warning: deprecated"},
+		{Type: "error", Message: "Error: failed"},
+	}
+
+	var actual []jsonMessage
+	s := bufio.NewScanner(strings.NewReader(ui.OutputWriter.String()))
+	for s.Scan() {
+		var m jsonMessage
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			t.Fatalf("bad line %q: %s", s.Text(), err)
+		}
+		actual = append(actual, m)
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected %#v, got %#v", expected[i], actual[i])
+		}
+	}
+}
+
+func TestBackend_outputFormatJSON(t *testing.T) {
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":  "sometoken",
+		"name":          "someuser/some-test-remote-state",
+		"output_format": "json",
+	})
+
+	ui := new(cli.MockUi)
+	err := b.CLIInit(&backend.CLIOpts{
+		CLI:      ui,
+		CLIColor: &colorstring.Colorize{Colors: colorstring.DefaultColors},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if u, ok := b.CLI.(*redactUi); !ok {
+		t.Fatalf("bad: %T", b.CLI)
+	} else if _, ok := u.Ui.(*jsonUi); !ok {
+		t.Fatalf("bad: %T", u.Ui)
+	}
+	if !b.Colorize().Disable {
+		t.Fatal("color should be disabled")
+	}
+
+	b.outputRunLog("Error: failed")
+	if out := ui.OutputWriter.String(); out != `{"type":"output","message":"Error: failed"}`+"\n" {
+		t.Fatalf("bad: %q", out)
+	}
+}
+
+func TestBackend_outputFormatJSONRedacted(t *testing.T) {
+	// JSON escapes these, so the secret must be redacted before encoding
+	password := `p&ss"w<rd>`
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":        "sometoken",
+		"name":                "someuser/some-test-remote-state",
+		"output_format":       "json",
+		"http_basic_user":     "someuser",
+		"http_basic_password": password,
+	})
+
+	ui := new(cli.MockUi)
+	err := b.CLIInit(&backend.CLIOpts{
+		CLI:      ui,
+		CLIColor: &colorstring.Colorize{Colors: colorstring.DefaultColors},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b.CLI.Output("password is " + password)
+
+	var m jsonMessage
+	if err := json.Unmarshal([]byte(ui.OutputWriter.String()), &m); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if m.Message != "password is "+redactedValue {
+		t.Fatalf("bad: %q", m.Message)
+	}
+}
@@ -0,0 +1,263 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/hcl/hcl/printer"
+	"github.com/hashicorp/terraform/backend"
+)
+
+const (
+	// Categories of variables, as Atlas distinguishes them.
+	variableCategoryTerraform = "terraform"
+	variableCategoryEnv       = "env"
+)
+
+// VariablePayload is a single variable of a run as sent to Atlas. Atlas
+// sets Terraform variables for the run from those in the "terraform"
+// category, and shell environment variables from those in the "env" one.
+type VariablePayload struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Category string `json:"category"`
+
+	// HCL is true if Value is an HCL encoded list or map rather than a
+	// plain string. Atlas parses it as HCL, so it must not be JSON: HCL
+	// maps are written with "=" rather than ":".
+	HCL bool `json:"hcl"`
+
+	// Sensitive variables are write-only in Atlas and are never output by
+	// Terraform.
+	Sensitive bool `json:"sensitive"`
+}
+
+// runVariables returns the variables to upload for an operation: the
+// Terraform variables of the operation followed by the configured
+// environment variables, each sorted by key.
+func (b *Backend) runVariables(op *backend.Operation) ([]VariablePayload, error) {
+	var result []VariablePayload
+
+	for _, k := range sortedKeys(op.Variables) {
+		v := VariablePayload{
+			Key:       k,
+			Category:  variableCategoryTerraform,
+			Sensitive: b.sensitiveVariables[k],
+		}
+
+		switch raw := op.Variables[k].(type) {
+		case string:
+			v.Value = raw
+		default:
+			// Everything else, such as lists and maps, is sent as HCL
+			data, err := encodeHCL(raw)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to encode variable %q: %v", k, err)
+			}
+			v.Value = data
+			v.HCL = true
+		}
+
+		result = append(result, v)
+	}
+
+	envKeys := make([]string, 0, len(b.envVariables))
+	for k := range b.envVariables {
+		envKeys = append(envKeys, k)
+	}
+	sort.Strings(envKeys)
+	for _, k := range envKeys {
+		result = append(result, VariablePayload{
+			Key:       k,
+			Value:     b.envVariables[k],
+			Category:  variableCategoryEnv,
+			Sensitive: b.sensitiveVariables[k],
+		})
+	}
+
+	return result, nil
+}
+
+// uploadVariables uploads the variables of an operation to the Atlas
+// environment, outputting what was sent with sensitive values redacted.
+func (b *Backend) uploadVariables(op *backend.Operation) error {
+	vars, err := b.runVariables(op)
+	if err != nil {
+		return err
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+
+	for _, v := range vars {
+		if v.Sensitive {
+			b.secrets.Add(v.Value)
+		}
+	}
+
+	if b.CLI != nil {
+		b.CLI.Output(b.Colorize().Color(
+			"[reset][bold]Uploading variables to Atlas:[reset]\n" + formatVariables(vars)))
+	}
+
+	return b.stateClient.putVariables(vars)
+}
+
+// formatVariables returns a human readable list of variables, with the
+// values of sensitive variables redacted.
+func formatVariables(vars []VariablePayload) string {
+	var buf bytes.Buffer
+	for _, v := range vars {
+		value := v.Value
+		if v.Sensitive {
+			value = redactedValue
+		}
+
+		fmt.Fprintf(&buf, "  %s (%s) = %s\n", v.Key, v.Category, value)
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// putVariables replaces the run variables of the environment.
+func (c *stateClient) putVariables(vars []VariablePayload) error {
+	if c.dryRun("upload %d variables to %s", len(vars), path.Join(c.User, c.Name)) {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"variables": vars})
+	if err != nil {
+		return fmt.Errorf("Failed to encode variables: %v", err)
+	}
+
+	req, err := retryablehttp.NewRequest("PUT", c.variablesURL().String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to make HTTP request: %v", err)
+	}
+	req.Header.Set(atlasTokenHeader, c.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = int64(len(body))
+
+	// The body contains the values of sensitive variables, so only the
+	// number of variables is logged.
+	log.Printf("[DEBUG] Uploading %d variables to Atlas", len(vars))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to upload variables: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	default:
+		return c.httpError(resp)
+	}
+}
+
+// variablesURL returns the URL of the run variables of the environment.
+func (c *stateClient) variablesURL() *url.URL {
+	return c.endpoints().VariablesURL(c.User, c.Name)
+}
+
+// prefixedEnv returns the variables of the environment whose names start
+// with prefix, with the prefix removed. If prefix is empty, none are
+// returned.
+func prefixedEnv(prefix string) map[string]string {
+	result := make(map[string]string)
+	if prefix == "" {
+		return result
+	}
+
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+			continue
+		}
+
+		if k := strings.TrimPrefix(parts[0], prefix); k != "" {
+			result[k] = parts[1]
+		}
+	}
+
+	return result
+}
+
+// encodeHCL encodes the value of a variable as HCL. Lists and maps may be
+// nested. The result is checked and formatted by the HCL printer, so that
+// a value Atlas can't parse is caught before the run.
+func encodeHCL(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := writeHCL(&buf, v); err != nil {
+		return "", err
+	}
+
+	// The printer only formats whole files, so the value is formatted as
+	// the value of an assignment, which is then removed again.
+	const assignment = "value = "
+	out, err := printer.Format(append([]byte(assignment), buf.Bytes()...))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(string(out), assignment)), nil
+}
+
+func writeHCL(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		buf.WriteString(strconv.Quote(v))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		fmt.Fprintf(buf, "%d", v)
+	case float32, float64:
+		fmt.Fprintf(buf, "%g", v)
+	case []interface{}:
+		buf.WriteString("[")
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := writeHCL(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	case map[string]interface{}:
+		buf.WriteString("{\n")
+		for _, k := range sortedKeys(v) {
+			fmt.Fprintf(buf, "%s = ", strconv.Quote(k))
+			if err := writeHCL(buf, v[k]); err != nil {
+				return err
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString("}")
+	default:
+		return fmt.Errorf("can't encode a %T as HCL", v)
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
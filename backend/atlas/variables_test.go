@@ -0,0 +1,206 @@
+package atlas
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/terraform/backend"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_uploadVariables(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token": "sometoken",
+		"name":         "someuser/some-test-remote-state",
+		"address":      srv.URL,
+		"environment_variables": map[string]interface{}{
+			"AWS_SECRET_ACCESS_KEY": "s3cret",
+			"TF_LOG":                "DEBUG",
+		},
+		"sensitive_variables": []interface{}{"password", "AWS_SECRET_ACCESS_KEY"},
+	})
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	op := testOperationPlan()
+	op.Variables = map[string]interface{}{
+		"ami":      "baz",
+		"password": "hunter2",
+		"zones":    []interface{}{"a", "b"},
+	}
+	if err := b.uploadVariables(op); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var payload struct {
+		Variables []VariablePayload `json:"variables"`
+	}
+	if err := json.Unmarshal(fakeAtlas.variables, &payload); err != nil {
+		t.Fatalf("bad payload %q: %s", fakeAtlas.variables, err)
+	}
+
+	expected := []VariablePayload{
+		{Key: "ami", Value: "baz", Category: "terraform"},
+		{Key: "password", Value: "hunter2", Category: "terraform", Sensitive: true},
+		{Key: "zones", Value: `["a", "b"]`, Category: "terraform", HCL: true},
+		{Key: "AWS_SECRET_ACCESS_KEY", Value: "s3cret", Category: "env", Sensitive: true},
+		{Key: "TF_LOG", Value: "DEBUG", Category: "env"},
+	}
+	if !reflect.DeepEqual(payload.Variables, expected) {
+		t.Fatalf("bad: %#v", payload.Variables)
+	}
+
+	output := ui.OutputWriter.String() + ui.ErrorWriter.String()
+	for _, secret := range []string{"hunter2", "s3cret"} {
+		if strings.Contains(output, secret) {
+			t.Fatalf("sensitive value %q in output:\n\n%s", secret, output)
+		}
+	}
+	for _, expected := range []string{"ami (terraform) = baz", "password (terraform) = ***"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in output:\n\n%s", expected, output)
+		}
+	}
+}
+
+func TestBackend_uploadVariablesPrefix(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	for k, v := range map[string]string{
+		"TF_RUN_FOO":    "bar",
+		"TF_RUN_SECRET": "s3cret",
+		"TF_RUN_TF_LOG": "TRACE",
+		"TF_RUN_":       "empty",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":   "sometoken",
+		"name":           "someuser/some-test-remote-state",
+		"address":        srv.URL,
+		"env_var_prefix": "TF_RUN_",
+		"environment_variables": map[string]interface{}{
+			"TF_LOG": "DEBUG",
+		},
+	})
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	if err := b.uploadVariables(testOperationPlan()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var payload struct {
+		Variables []VariablePayload `json:"variables"`
+	}
+	if err := json.Unmarshal(fakeAtlas.variables, &payload); err != nil {
+		t.Fatalf("bad payload %q: %s", fakeAtlas.variables, err)
+	}
+
+	// The configured TF_LOG takes precedence over the forwarded one
+	expected := []VariablePayload{
+		{Key: "FOO", Value: "bar", Category: "env", Sensitive: true},
+		{Key: "SECRET", Value: "s3cret", Category: "env", Sensitive: true},
+		{Key: "TF_LOG", Value: "DEBUG", Category: "env"},
+	}
+	if !reflect.DeepEqual(payload.Variables, expected) {
+		t.Fatalf("bad: %#v", payload.Variables)
+	}
+
+	output := ui.OutputWriter.String()
+	for _, secret := range []string{"bar", "s3cret"} {
+		if strings.Contains(output, secret) {
+			t.Fatalf("forwarded value %q in output:\n\n%s", secret, output)
+		}
+	}
+}
+
+func TestBackend_uploadVariablesNone(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	if err := b.uploadVariables(testOperationPlan()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fakeAtlas.variables != nil {
+		t.Fatalf("nothing should be uploaded, got: %s", fakeAtlas.variables)
+	}
+}
+
+func TestEncodeHCL(t *testing.T) {
+	cases := map[string]struct {
+		Value    interface{}
+		Expected string
+	}{
+		"string": {
+			`say "hi"`,
+			`"say \"hi\""`,
+		},
+		"list": {
+			[]interface{}{"a", "b"},
+			`["a", "b"]`,
+		},
+		"map": {
+			map[string]interface{}{"region": "us-east-1", "count": 2},
+			"{\n  \"count\"  = 2\n  \"region\" = \"us-east-1\"\n}",
+		},
+		"nested": {
+			map[string]interface{}{"zones": []interface{}{"a", "b"}},
+			"{\n  \"zones\" = [\"a\", \"b\"]\n}",
+		},
+	}
+
+	for name, tc := range cases {
+		actual, err := encodeHCL(tc.Value)
+		if err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+		if actual != tc.Expected {
+			t.Fatalf("%s: bad: %q", name, actual)
+		}
+
+		// Atlas must be able to parse the value back into what was sent
+		var parsed map[string]interface{}
+		if err := hcl.Decode(&parsed, "value = "+actual); err != nil {
+			t.Fatalf("%s: invalid HCL %q: %s", name, actual, err)
+		}
+		value := parsed["value"]
+		if m, ok := tc.Value.(map[string]interface{}); ok {
+			// HCL decodes each map as a list of maps
+			elems, ok := value.([]map[string]interface{})
+			if !ok || len(elems) != 1 {
+				t.Fatalf("%s: bad: %#v", name, value)
+			}
+			value = elems[0]
+			if len(elems[0]) != len(m) {
+				t.Fatalf("%s: bad: %#v", name, value)
+			}
+		}
+		if fmt.Sprint(value) != fmt.Sprint(tc.Value) {
+			t.Fatalf("%s: parsed %#v, expected %#v", name, value, tc.Value)
+		}
+	}
+}
+
+func TestEncodeHCL_invalid(t *testing.T) {
+	if _, err := encodeHCL(struct{}{}); err == nil {
+		t.Fatal("should error")
+	}
+}
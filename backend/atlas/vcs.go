@@ -0,0 +1,44 @@
+package atlas
+
+import (
+	"fmt"
+	"log"
+)
+
+// ErrVCSConnected is returned when applying from the CLI to an environment
+// whose runs are triggered by a VCS repository, unless allow_cli_apply is
+// set.
+type ErrVCSConnected struct {
+	Environment string
+	Repo        string
+}
+
+func (e *ErrVCSConnected) Error() string {
+	return fmt.Sprintf(
+		"The Atlas environment %s is connected to the VCS repository %s,\n"+
+			"which triggers its runs. Applying from the CLI could conflict with those\n"+
+			"runs, so it's not allowed. Push the change to %s instead, or set\n"+
+			"allow_cli_apply to apply from the CLI anyway.",
+		e.Environment, e.Repo, e.Repo)
+}
+
+// checkCLIApply refuses applies to an environment that's connected to a VCS
+// repository, unless allow_cli_apply is set. Runs in Atlas are triggered by
+// the repository in the first place, so they're not checked. If the
+// environment's settings can't be read, that's only logged.
+func (b *Backend) checkCLIApply() error {
+	if b.allowCLIApply || b.stateClient.RunId != "" {
+		return nil
+	}
+
+	env, err := b.stateClient.getEnvironment()
+	if err != nil {
+		log.Printf("[WARN] backend/atlas: %s", err)
+		return nil
+	}
+	if env.VCSRepo == "" {
+		return nil
+	}
+
+	return &ErrVCSConnected{Environment: b.name, Repo: env.VCSRepo}
+}
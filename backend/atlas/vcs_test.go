@@ -0,0 +1,74 @@
+package atlas
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform/config/module"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestBackend_applyVCSConnected(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	fakeAtlas.vcsRepo = "someuser/some-repo"
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	p := testProvider(t, b, "test")
+	p.ApplyReturn = &terraform.InstanceState{ID: "yes"}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/apply")
+	defer modCleanup()
+
+	op := testOperationApply()
+	op.Module = mod
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	vcsErr, ok := run.Err.(*ErrVCSConnected)
+	if !ok {
+		t.Fatalf("expected *ErrVCSConnected, got: %#v", run.Err)
+	}
+	if vcsErr.Repo != "someuser/some-repo" {
+		t.Fatalf("bad: %s", vcsErr.Repo)
+	}
+	if p.ApplyCalled {
+		t.Fatal("apply shouldn't be called")
+	}
+	if fakeAtlas.puts != 0 {
+		t.Fatal("the state shouldn't be written")
+	}
+
+	// With allow_cli_apply, the apply goes ahead
+	b.allowCLIApply = true
+	run, err = b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+	checkState(t, fakeAtlas, `
+test_instance.foo:
+  ID = yes
+	`)
+}
+
+func TestBackend_applyVCSConnectedAtlasRun(t *testing.T) {
+	fakeAtlas := newFakeAtlas(t, nil)
+	fakeAtlas.vcsRepo = "someuser/some-repo"
+	srv := fakeAtlas.Server()
+	defer srv.Close()
+
+	// Runs in Atlas are the ones the repository triggers
+	b := testBackend(t, srv)
+	b.stateClient.RunId = "run-abc123"
+	if err := b.checkCLIApply(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
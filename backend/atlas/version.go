@@ -0,0 +1,137 @@
+package atlas
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// ErrTerraformVersionUnavailable is returned when the terraform_version
+// requested for runs isn't one that Atlas can run.
+type ErrTerraformVersionUnavailable struct {
+	Version   string
+	Supported []string
+}
+
+func (e *ErrTerraformVersionUnavailable) Error() string {
+	msg := fmt.Sprintf(
+		"Atlas can't run Terraform %s, as set by terraform_version.", e.Version)
+	if len(e.Supported) > 0 {
+		msg += fmt.Sprintf(
+			" The versions that are\navailable are: %s",
+			strings.Join(e.Supported, ", "))
+	}
+
+	return msg
+}
+
+// terraformVersionError returns the error for a run that Atlas rejected
+// because it can't run the requested version of Terraform.
+func terraformVersionError(resp *http.Response, version string) error {
+	// Atlas lists the versions it supports when it rejects one
+	var result struct {
+		SupportedVersions []string `json:"supported_versions"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	return &ErrTerraformVersionUnavailable{
+		Version:   version,
+		Supported: result.SupportedVersions,
+	}
+}
+
+// environmentSettings are the settings of an environment in Atlas.
+type environmentSettings struct {
+	TerraformVersion string `json:"terraform_version"`
+
+	// VCSRepo is the repository that triggers runs of the environment, if
+	// it's connected to one.
+	VCSRepo string `json:"vcs_repo"`
+
+	// DestroyLocked is true if the environment is protected from destroys.
+	DestroyLocked bool `json:"destroy_locked"`
+}
+
+// getEnvironment returns the settings of the environment.
+func (c *stateClient) getEnvironment() (*environmentSettings, error) {
+	var result struct {
+		Environment environmentSettings `json:"environment"`
+	}
+	if _, err := c.getJSON(c.environmentURL(), &result); err != nil {
+		return nil, fmt.Errorf("Failed to read the environment's settings: %v", err)
+	}
+
+	return &result.Environment, nil
+}
+
+// getTerraformVersion returns the version of Terraform that Atlas uses for
+// runs of the environment, or an empty string if it's not reported.
+func (c *stateClient) getTerraformVersion() (string, error) {
+	env, err := c.getEnvironment()
+	if err != nil {
+		return "", err
+	}
+
+	return env.TerraformVersion, nil
+}
+
+// checkTerraformVersion warns if the given local version of Terraform
+// differs from the version Atlas uses for runs, since that can cause
+// surprising differences between plans. It never fails; if the remote
+// version can't be read, that's only logged.
+func (b *Backend) checkTerraformVersion(local string) {
+	remote := b.terraformVersion
+	if remote == "" {
+		var err error
+		remote, err = b.stateClient.getTerraformVersion()
+		if err != nil {
+			log.Printf("[WARN] backend/atlas: %s", err)
+			return
+		}
+	}
+	if remote == "" || sameVersion(local, remote) {
+		return
+	}
+
+	if b.CLI != nil {
+		b.CLI.Output(b.Colorize().Color(fmt.Sprintf(
+			"[reset][yellow]Warning: the local version of Terraform is %s, but Atlas runs\n"+
+				"use %s. Plans may differ between the two.\n", local, remote)))
+	}
+}
+
+// sameVersion returns true if the two versions are the same, ignoring any
+// pre-release or metadata. Versions that can't be parsed are treated as the
+// same, so that no warning is given about them.
+func sameVersion(a, b string) bool {
+	va, err := version.NewVersion(a)
+	if err != nil {
+		return true
+	}
+	vb, err := version.NewVersion(b)
+	if err != nil {
+		return true
+	}
+
+	sa, sb := va.Segments(), vb.Segments()
+	if len(sa) != len(sb) {
+		return false
+	}
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// environmentURL returns the URL of the environment.
+func (c *stateClient) environmentURL() *url.URL {
+	return c.endpoints().EnvironmentURL(c.User, c.Name)
+}
@@ -0,0 +1,130 @@
+package atlas
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/backend"
+	"github.com/mitchellh/cli"
+)
+
+func TestBackend_terraformVersionUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// The version is only for the run; the environment is left as it is
+		if req.Method == "PUT" {
+			t.Errorf("unexpected %s %s", req.Method, req.URL.Path)
+		}
+		if req.Method != "POST" || req.URL.Path != "/api/v1/environments/someuser/some-test-remote-state/runs" {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var body struct {
+			Run struct {
+				TerraformVersion string `json:"terraform_version"`
+			} `json:"run"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if body.Run.TerraformVersion != "0.1.0" {
+			t.Fatalf("bad version: %q", body.Run.TerraformVersion)
+		}
+
+		resp.WriteHeader(http.StatusUnprocessableEntity)
+		resp.Write([]byte(`{"supported_versions": ["0.9.2", "0.9.3"]}`))
+	}))
+	defer srv.Close()
+
+	b := &Backend{}
+	backend.TestBackendConfig(t, b, map[string]interface{}{
+		"access_token":      "sometoken",
+		"name":              "someuser/some-test-remote-state",
+		"address":           srv.URL,
+		"terraform_version": "0.1.0",
+	})
+
+	_, err := b.prepareRun(context.Background(), testOperationPlan())
+	versionErr, ok := err.(*ErrTerraformVersionUnavailable)
+	if !ok {
+		t.Fatalf("expected *ErrTerraformVersionUnavailable, got %T: %v", err, err)
+	}
+	if versionErr.Version != "0.1.0" {
+		t.Fatalf("bad: %#v", versionErr)
+	}
+	if !strings.Contains(err.Error(), "0.9.2, 0.9.3") {
+		t.Fatalf("expected the supported versions in the error, got: %s", err)
+	}
+}
+
+func TestValidate_terraformVersion(t *testing.T) {
+	cases := map[string]bool{
+		"0.9.3":      false,
+		"0.10.0-rc1": false,
+		"latest":     true,
+		"":           true,
+	}
+
+	for value, shouldErr := range cases {
+		_, errs := validateTerraformVersion(value, "terraform_version")
+		if (len(errs) > 0) != shouldErr {
+			t.Fatalf("%q: bad: %v", value, errs)
+		}
+	}
+}
+
+func TestBackend_checkTerraformVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/environments/someuser/some-test-remote-state" {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		resp.Write([]byte(`{"environment": {"terraform_version": "0.9.2"}}`))
+	}))
+	defer srv.Close()
+
+	cases := map[string]bool{
+		"0.9.2":       false,
+		"0.9.2-dev":   false,
+		"0.9.2-beta1": false,
+		"0.9.3":       true,
+	}
+
+	for local, shouldWarn := range cases {
+		b := testBackend(t, srv)
+		ui := new(cli.MockUi)
+		b.CLI = ui
+
+		b.checkTerraformVersion(local)
+
+		output := ui.OutputWriter.String()
+		if strings.Contains(output, "Warning") != shouldWarn {
+			t.Fatalf("%s: bad output: %q", local, output)
+		}
+		if shouldWarn && !strings.Contains(output, "0.9.2") {
+			t.Fatalf("%s: expected the remote version in output: %q", local, output)
+		}
+	}
+}
+
+func TestBackend_checkTerraformVersionUnavailable(t *testing.T) {
+	srv := httptest.NewServer(withOrganizationAccess(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	b := testBackend(t, srv)
+	ui := new(cli.MockUi)
+	b.CLI = ui
+
+	// Failing to read the version must not be reported to the user
+	b.checkTerraformVersion("0.9.3")
+	if ui.OutputWriter != nil || ui.ErrorWriter != nil {
+		t.Fatalf("bad output: %q %q", ui.OutputWriter, ui.ErrorWriter)
+	}
+}
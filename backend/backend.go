@@ -125,10 +125,20 @@ type Operation struct {
 	Targets   []string
 	Variables map[string]interface{}
 
+	// Providers are providers to use for this operation in addition to
+	// the backend's defaults, overriding any default of the same name.
+	// This makes it possible to run operations with mock or pinned
+	// providers, such as in tests.
+	Providers map[string]terraform.ResourceProviderFactory
+
 	// Input/output/control options.
 	UIIn  terraform.UIInput
 	UIOut terraform.UIOutput
 
+	// AutoApprove skips any approval that the backend would otherwise ask
+	// for before applying changes.
+	AutoApprove bool
+
 	// If LockState is true, the Operation must Lock any
 	// state.Lockers for its duration, and Unlock when complete.
 	LockState bool
@@ -159,8 +169,25 @@ type RunningOperation struct {
 	// to note whether a plan is empty or has changes.
 	PlanEmpty bool
 
+	// PlanId is populated after a Plan operation completes without error
+	// if the backend saved the plan, and can be given as the PlanId of a
+	// later apply operation to apply exactly that plan.
+	PlanId string
+
 	// State is the final state after the operation completed. Persisting
 	// this state is managed by the backend. This should only be read
 	// after the operation completes to avoid read/write races.
 	State *terraform.State
+
+	// Outputs are the outputs of the root module, populated after an
+	// output operation completes without error. Sensitive outputs are
+	// included, flagged as such, and it's up to the caller whether to
+	// show their values.
+	Outputs map[string]*terraform.OutputState
+
+	// Cancel, if set, forces an operation that is already stopping because
+	// its context was cancelled to abort without waiting for the work in
+	// progress. The operation is still done only once whatever state it has
+	// is saved. Cancel may be called more than once.
+	Cancel func()
 }
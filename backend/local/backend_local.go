@@ -47,6 +47,18 @@ func (b *Local) context(op *backend.Operation) (*terraform.Context, state.State,
 		opts.Variables = op.Variables
 	}
 
+	// Providers of the operation override the defaults
+	if len(op.Providers) > 0 {
+		providers := make(map[string]terraform.ResourceProviderFactory)
+		for k, v := range opts.Providers {
+			providers[k] = v
+		}
+		for k, v := range op.Providers {
+			providers[k] = v
+		}
+		opts.Providers = providers
+	}
+
 	// Load our state
 	opts.State = s.State()
 
@@ -207,6 +207,57 @@ func TestLocal_planOutPathNoChange(t *testing.T) {
 	}
 }
 
+func TestLocal_planOperationProviders(t *testing.T) {
+	b := TestLocal(t)
+	pDefault := TestLocalProvider(t, b, "test")
+	pOther := TestLocalProvider(t, b, "other")
+	pOther.ResourcesReturn = []terraform.ResourceType{{Name: "other_instance"}}
+
+	pOverride := new(terraform.MockResourceProvider)
+	pOverride.DiffReturn = &terraform.InstanceDiff{}
+	pOverride.ResourcesReturn = []terraform.ResourceType{{Name: "test_instance"}}
+
+	mod, modCleanup := module.TestTree(t, "./test-fixtures/plan-providers")
+	defer modCleanup()
+
+	op := testOperationPlan()
+	op.Module = mod
+	op.Providers = map[string]terraform.ResourceProviderFactory{
+		"test": func() (terraform.ResourceProvider, error) {
+			return pOverride, nil
+		},
+	}
+
+	run, err := b.Operation(context.Background(), op)
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	<-run.Done()
+	if run.Err != nil {
+		t.Fatalf("err: %s", run.Err)
+	}
+
+	// The operation's provider replaces the default of the same name, and
+	// the other defaults are still used
+	if !pOverride.DiffCalled {
+		t.Fatal("the operation's provider should be used")
+	}
+	if pDefault.DiffCalled {
+		t.Fatal("the overridden default provider shouldn't be used")
+	}
+	if !pOther.DiffCalled {
+		t.Fatal("the other default provider should be used")
+	}
+
+	// The defaults themselves are left as they were
+	if len(b.ContextOpts.Providers) != 2 {
+		t.Fatalf("bad: %#v", b.ContextOpts.Providers)
+	}
+	if p, _ := b.ContextOpts.Providers["test"](); p != pDefault {
+		t.Fatal("the default provider shouldn't be replaced")
+	}
+}
+
 func testOperationPlan() *backend.Operation {
 	return &backend.Operation{
 		Type: backend.OperationTypePlan,
@@ -0,0 +1,7 @@
+resource "test_instance" "foo" {
+    ami = "bar"
+}
+
+resource "other_instance" "foo" {
+    ami = "bar"
+}
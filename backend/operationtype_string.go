@@ -4,9 +4,9 @@ package backend
 
 import "fmt"
 
-const _OperationType_name = "OperationTypeInvalidOperationTypeRefreshOperationTypePlanOperationTypeApply"
+const _OperationType_name = "OperationTypeInvalidOperationTypeRefreshOperationTypePlanOperationTypeApplyOperationTypeOutput"
 
-var _OperationType_index = [...]uint8{0, 20, 40, 57, 75}
+var _OperationType_index = [...]uint8{0, 20, 40, 57, 75, 94}
 
 func (i OperationType) String() string {
 	if i >= OperationType(len(_OperationType_index)-1) {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-getter"
 	"github.com/hashicorp/terraform/backend"
@@ -29,7 +30,7 @@ type ApplyCommand struct {
 }
 
 func (c *ApplyCommand) Run(args []string) int {
-	var destroyForce, refresh bool
+	var destroyForce, refresh, autoApprove bool
 	args = c.Meta.process(args, true)
 
 	cmdName := "apply"
@@ -40,6 +41,8 @@ func (c *ApplyCommand) Run(args []string) int {
 	cmdFlags := c.Meta.flagSet(cmdName)
 	if c.Destroy {
 		cmdFlags.BoolVar(&destroyForce, "force", false, "force")
+	} else {
+		cmdFlags.BoolVar(&autoApprove, "auto-approve", false, "auto-approve")
 	}
 	cmdFlags.BoolVar(&refresh, "refresh", true, "refresh")
 	cmdFlags.IntVar(
@@ -185,6 +188,9 @@ func (c *ApplyCommand) Run(args []string) int {
 	opReq.PlanRefresh = refresh
 	opReq.Type = backend.OperationTypeApply
 
+	// A destroy has already been confirmed above
+	opReq.AutoApprove = autoApprove || c.Destroy
+
 	// Perform the operation
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	defer ctxCancel()
@@ -209,6 +215,18 @@ func (c *ApplyCommand) Run(args []string) int {
 			c.Ui.Error(
 				"Two interrupts received. Exiting immediately. Note that data\n" +
 					"loss may have occurred.")
+
+			// Backends that can abort still save the state they have, but
+			// that mustn't keep us from exiting if it hangs
+			if op.Cancel != nil {
+				c.Ui.Output("Saving the state before exiting...")
+				op.Cancel()
+				select {
+				case <-op.Done():
+				case <-time.After(applyAbortTimeout):
+					c.Ui.Error("Timed out saving the state. It may not include every change.")
+				}
+			}
 			return 1
 		case <-op.Done():
 		}
@@ -234,6 +252,10 @@ func (c *ApplyCommand) Run(args []string) int {
 	return 0
 }
 
+// applyAbortTimeout is how long a second interrupt waits for the backend to
+// save the state of the aborted apply before exiting anyway.
+var applyAbortTimeout = 30 * time.Second
+
 func (c *ApplyCommand) Help() string {
 	if c.Destroy {
 		return c.helpDestroy()
@@ -270,6 +292,9 @@ Usage: terraform apply [options] [DIR-OR-PLAN]
 
 Options:
 
+  -auto-approve          Skip approval of the plan before applying, for
+                         backends that ask for it.
+
   -backup=path           Path to backup the existing state file before
                          modifying. Defaults to the "-state-out" path with
                          ".backup" extension. Set to "-" to disable backup.
@@ -2,6 +2,7 @@ package command
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -16,6 +17,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hashicorp/terraform/backend"
+	backendinit "github.com/hashicorp/terraform/backend/init"
+	backendinmem "github.com/hashicorp/terraform/backend/remote-state/inmem"
+	"github.com/hashicorp/terraform/helper/copy"
 	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/mitchellh/cli"
@@ -949,6 +954,74 @@ func TestApply_refresh(t *testing.T) {
 	}
 }
 
+// testAbortHangBackend is an enhanced backend whose operations never
+// finish, even once they're cancelled.
+type testAbortHangBackend struct {
+	backend.Backend
+
+	cancelled chan struct{}
+}
+
+func (b *testAbortHangBackend) Operation(context.Context, *backend.Operation) (*backend.RunningOperation, error) {
+	return &backend.RunningOperation{
+		Context: context.Background(),
+		Cancel:  func() { close(b.cancelled) },
+	}, nil
+}
+
+func TestApply_shutdownTwiceTimeout(t *testing.T) {
+	b := &testAbortHangBackend{Backend: backendinmem.New(), cancelled: make(chan struct{})}
+	backendinit.Set("abort-hang", func() backend.Backend { return b })
+	defer backendinit.Set("abort-hang", nil)
+
+	defer func(d time.Duration) { applyAbortTimeout = d }(applyAbortTimeout)
+	applyAbortTimeout = 10 * time.Millisecond
+
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("apply-abort-hang"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	ui := new(cli.MockUi)
+	ci := &InitCommand{
+		Meta: Meta{
+			Ui: ui,
+		},
+	}
+	if code := ci.Run(nil); code != 0 {
+		t.Fatalf("bad: %d\n%s", code, ui.ErrorWriter)
+	}
+
+	shutdownCh := make(chan struct{})
+	go func() {
+		shutdownCh <- struct{}{}
+		shutdownCh <- struct{}{}
+	}()
+
+	ui = new(cli.MockUi)
+	c := &ApplyCommand{
+		Meta: Meta{
+			Ui: ui,
+		},
+
+		ShutdownCh: shutdownCh,
+	}
+
+	// The second interrupt exits even though the backend never finishes
+	// saving the state
+	if code := c.Run(nil); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	select {
+	case <-b.cancelled:
+	default:
+		t.Fatal("operation should be cancelled")
+	}
+	if errOut := ui.ErrorWriter.String(); !strings.Contains(errOut, "Timed out saving the state") {
+		t.Fatalf("bad: %s", errOut)
+	}
+}
+
 func TestApply_shutdown(t *testing.T) {
 	stopped := false
 	stopCh := make(chan struct{})
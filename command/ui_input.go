@@ -15,6 +15,7 @@ import (
 
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/mitchellh/colorstring"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var defaultInputReader io.Reader
@@ -119,6 +120,17 @@ func (i *UIInput) Input(opts *terraform.InputOpts) (string, error) {
 	// interrupt this if we are interrupted (SIGINT)
 	result := make(chan string, 1)
 	go func() {
+		// Secret values aren't echoed if we're reading from a terminal
+		if f, ok := r.(*os.File); ok && opts.Secret && terminal.IsTerminal(int(f.Fd())) {
+			line, err := terminal.ReadPassword(int(f.Fd()))
+			if err != nil {
+				log.Printf("[ERR] UIInput scan err: %s", err)
+			}
+
+			result <- strings.TrimRightFunc(string(line), unicode.IsSpace)
+			return
+		}
+
 		buf := bufio.NewReader(r)
 		line, err := buf.ReadString('\n')
 		if err != nil {
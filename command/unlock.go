@@ -19,8 +19,10 @@ func (c *UnlockCommand) Run(args []string) int {
 	args = c.Meta.process(args, false)
 
 	force := false
+	anyLock := false
 	cmdFlags := c.Meta.flagSet("force-unlock")
 	cmdFlags.BoolVar(&force, "force", false, "force")
+	cmdFlags.BoolVar(&anyLock, "any", false, "any")
 	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -96,7 +98,19 @@ func (c *UnlockCommand) Run(args []string) int {
 		}
 	}
 
-	if err := st.Unlock(lockID); err != nil {
+	// Backends that can remove a lock without matching its ID only do so
+	// with -any, since the lock may otherwise belong to a run that's still
+	// going.
+	if fu, ok := b.(forceUnlocker); ok {
+		id := lockID
+		if anyLock {
+			id = ""
+		}
+		err = fu.ForceUnlock(env, id)
+	} else {
+		err = st.Unlock(lockID)
+	}
+	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Failed to unlock state: %s", err))
 		return 1
 	}
@@ -105,6 +119,13 @@ func (c *UnlockCommand) Run(args []string) int {
 	return 0
 }
 
+// forceUnlocker is implemented by backends that can remove the lock on a
+// state whatever its ID, such as the Atlas backend. The lock is only removed
+// if its ID matches id, unless id is empty.
+type forceUnlocker interface {
+	ForceUnlock(env, id string) error
+}
+
 func (c *UnlockCommand) Help() string {
 	helpText := `
 Usage: terraform force-unlock LOCK_ID [DIR]
@@ -119,6 +140,10 @@ Usage: terraform force-unlock LOCK_ID [DIR]
 Options:
 
   -force                 Don't ask for input for unlock confirmation.
+
+  -any                   Remove the lock even if its ID isn't LOCK_ID, for
+                         backends that support it. Only use this if the lock
+                         can't be released otherwise.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -1,9 +1,15 @@
 package command
 
 import (
+	"context"
+	"errors"
 	"os"
+	"reflect"
 	"testing"
 
+	"github.com/hashicorp/terraform/backend"
+	backendinit "github.com/hashicorp/terraform/backend/init"
+	backendinmem "github.com/hashicorp/terraform/backend/remote-state/inmem"
 	"github.com/hashicorp/terraform/helper/copy"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/mitchellh/cli"
@@ -100,3 +106,63 @@ func TestUnlock_inmemBackend(t *testing.T) {
 	}
 
 }
+
+// testForceUnlocker is an enhanced backend, so that it isn't wrapped by
+// the local backend, that records the lock IDs it's asked to force-unlock.
+type testForceUnlocker struct {
+	backend.Backend
+
+	unlocked []string
+}
+
+func (b *testForceUnlocker) Operation(context.Context, *backend.Operation) (*backend.RunningOperation, error) {
+	return nil, errors.New("not supported")
+}
+
+func (b *testForceUnlocker) ForceUnlock(env, id string) error {
+	b.unlocked = append(b.unlocked, id)
+	return nil
+}
+
+// Backends that can remove any lock are given the lock ID to check, unless
+// -any is passed
+func TestUnlock_forceUnlocker(t *testing.T) {
+	b := &testForceUnlocker{Backend: backendinmem.New()}
+	backendinit.Set("force-unlocker", func() backend.Backend { return b })
+	defer backendinit.Set("force-unlocker", nil)
+
+	td := tempDir(t)
+	copy.CopyDir(testFixturePath("unlock-force-unlocker"), td)
+	defer os.RemoveAll(td)
+	defer testChdir(t, td)()
+
+	ui := new(cli.MockUi)
+	ci := &InitCommand{
+		Meta: Meta{
+			Ui: ui,
+		},
+	}
+	if code := ci.Run(nil); code != 0 {
+		t.Fatalf("bad: %d\n%s", code, ui.ErrorWriter)
+	}
+
+	for _, args := range [][]string{
+		{"-force", "LOCK_ID"},
+		{"-force", "-any", "LOCK_ID"},
+	} {
+		ui = new(cli.MockUi)
+		c := &UnlockCommand{
+			Meta: Meta{
+				Ui: ui,
+			},
+		}
+		if code := c.Run(args); code != 0 {
+			t.Fatalf("bad: %d\n%s\n%s", code, ui.OutputWriter.String(), ui.ErrorWriter.String())
+		}
+	}
+
+	expected := []string{"LOCK_ID", ""}
+	if !reflect.DeepEqual(b.unlocked, expected) {
+		t.Fatalf("expected %q, got %q", expected, b.unlocked)
+	}
+}
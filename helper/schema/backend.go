@@ -61,16 +61,7 @@ func (b *Backend) Configure(c *terraform.ResourceConfig) error {
 		return nil
 	}
 
-	sm := schemaMap(b.Schema)
-
-	// Get a ResourceData for this configuration. To do this, we actually
-	// generate an intermediary "diff" although that is never exposed.
-	diff, err := sm.Diff(nil, c)
-	if err != nil {
-		return err
-	}
-
-	data, err := sm.Data(nil, diff)
+	data, err := b.Data(c)
 	if err != nil {
 		return err
 	}
@@ -87,6 +78,22 @@ func (b *Backend) Configure(c *terraform.ResourceConfig) error {
 	return nil
 }
 
+// Data returns a ResourceData for the given configuration without
+// configuring the backend, such as to check fields against each other
+// while validating.
+func (b *Backend) Data(c *terraform.ResourceConfig) (*ResourceData, error) {
+	sm := schemaMap(b.Schema)
+
+	// Get a ResourceData for this configuration. To do this, we actually
+	// generate an intermediary "diff" although that is never exposed.
+	diff, err := sm.Diff(nil, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return sm.Data(nil, diff)
+}
+
 // Config returns the configuration. This is available after Configure is
 // called.
 func (b *Backend) Config() *ResourceData {
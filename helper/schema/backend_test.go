@@ -109,3 +109,47 @@ func TestBackendConfigure(t *testing.T) {
 		})
 	}
 }
+
+func TestBackendData(t *testing.T) {
+	configured := false
+	b := &Backend{
+		Schema: map[string]*Schema{
+			"foo": &Schema{
+				Type:     TypeInt,
+				Optional: true,
+			},
+			"bar": &Schema{
+				Type:     TypeString,
+				Optional: true,
+				Default:  "baz",
+			},
+		},
+
+		ConfigureFunc: func(context.Context) error {
+			configured = true
+			return nil
+		},
+	}
+
+	c, err := config.NewRawConfig(map[string]interface{}{
+		"foo": 42,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	d, err := b.Data(terraform.NewResourceConfig(c))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if d.Get("foo").(int) != 42 || d.Get("bar").(string) != "baz" {
+		t.Fatalf("bad: %#v", d)
+	}
+
+	if configured {
+		t.Fatal("the backend should not be configured")
+	}
+	if b.Config() != nil {
+		t.Fatal("the configuration should not be kept")
+	}
+}
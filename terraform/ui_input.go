@@ -23,4 +23,8 @@ type InputOpts struct {
 
 	// Default will be the value returned if no data is entered.
 	Default string
+
+	// Secret should be set to true for sensitive values, such as tokens,
+	// that shouldn't be echoed back as they are entered.
+	Secret bool
 }